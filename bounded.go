@@ -0,0 +1,213 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"container/list"
+	"net/netip"
+)
+
+// EvictPolicy decides which entry is evicted from a full [BoundedTable].
+type EvictPolicy int
+
+const (
+	// EvictReject, nothing is evicted, new prefixes are rejected once full.
+	EvictReject EvictPolicy = iota
+
+	// EvictLRU, the least recently used entry is evicted,
+	// Insert, Get and Lookup count as use.
+	EvictLRU
+
+	// EvictShortest, the entry with the shortest prefix is evicted,
+	// for equal lengths the oldest one.
+	EvictShortest
+)
+
+// String implements the [fmt.Stringer] interface.
+func (p EvictPolicy) String() string {
+	switch p {
+	case EvictReject:
+		return "reject"
+	case EvictLRU:
+		return "lru"
+	case EvictShortest:
+		return "shortest"
+	default:
+		return "unknown"
+	}
+}
+
+// BoundedTable is a routing table with payload V and a maximum number of
+// entries, e.g. for learned-route, ACL or neighbor caches with bounded memory.
+// Once full, an insert of a new prefix evicts an entry or is rejected,
+// as decided by the [EvictPolicy].
+//
+// With [EvictLRU] Get and Lookup update the access order,
+// they are writes with respect to concurrency.
+//
+// The zero value is ready to use and unbounded, see [BoundedTable.SetCapacity].
+// A BoundedTable must not be copied by value.
+type BoundedTable[V any] struct {
+	tbl Table[*boundedEntry[V]]
+
+	capacity int
+	policy   EvictPolicy
+
+	// access order, most recently used at the front
+	lru list.List
+
+	// insertion order per prefix length
+	byLen [129]list.List
+}
+
+// boundedEntry, the payload of a BoundedTable with
+// its elements in the lru and byLen lists.
+type boundedEntry[V any] struct {
+	val     V
+	lruElem *list.Element
+	lenElem *list.Element
+}
+
+// SetCapacity sets the maximum number of entries and the eviction policy.
+// A capacity less than 1 means unbounded.
+//
+// If the table holds more entries than the new capacity, the surplus is
+// evicted immediately according to the policy, with [EvictReject]
+// the entries are kept, but new prefixes are rejected until
+// enough entries are deleted.
+func (t *BoundedTable[V]) SetCapacity(capacity int, policy EvictPolicy) {
+	t.capacity = capacity
+	t.policy = policy
+
+	for t.isFull() && t.tbl.Size() > t.capacity {
+		if _, ok := t.evict(); !ok {
+			break
+		}
+	}
+}
+
+// Capacity returns the maximum number of entries and the eviction policy.
+func (t *BoundedTable[V]) Capacity() (capacity int, policy EvictPolicy) {
+	return t.capacity, t.policy
+}
+
+// Insert adds pfx with val to the table. If pfx is already present, the
+// value is replaced, this never evicts an entry. If the table is full,
+// an entry is evicted first, or the insert is rejected with [EvictReject].
+//
+// Returns whether pfx was admitted and the evicted prefix, if any,
+// otherwise an invalid prefix.
+func (t *BoundedTable[V]) Insert(pfx netip.Prefix, val V) (admitted bool, evicted netip.Prefix) {
+	if !pfx.IsValid() {
+		return false, evicted
+	}
+
+	// canonicalize prefix
+	pfx = pfx.Masked()
+
+	if e, ok := t.tbl.Get(pfx); ok {
+		e.val = val
+		t.lru.MoveToFront(e.lruElem)
+		return true, evicted
+	}
+
+	if t.isFull() {
+		var ok bool
+		if evicted, ok = t.evict(); !ok {
+			return false, evicted
+		}
+	}
+
+	t.tbl.Insert(pfx, &boundedEntry[V]{
+		val:     val,
+		lruElem: t.lru.PushFront(pfx),
+		lenElem: t.byLen[pfx.Bits()].PushBack(pfx),
+	})
+
+	return true, evicted
+}
+
+// Delete removes pfx from the table.
+func (t *BoundedTable[V]) Delete(pfx netip.Prefix) {
+	if e, ok := t.tbl.GetAndDelete(pfx); ok {
+		t.lru.Remove(e.lruElem)
+		t.byLen[pfx.Bits()].Remove(e.lenElem)
+	}
+}
+
+// Get returns the associated payload for prefix and true, or false if
+// prefix is not set in the table. With [EvictLRU] the prefix counts as used.
+func (t *BoundedTable[V]) Get(pfx netip.Prefix) (val V, ok bool) {
+	e, ok := t.tbl.Get(pfx)
+	if !ok {
+		return val, false
+	}
+
+	t.touch(e)
+	return e.val, true
+}
+
+// Lookup does a route lookup (longest prefix match) for IP and
+// returns the associated value and true, or false if no route matched.
+// With [EvictLRU] the matched prefix counts as used.
+func (t *BoundedTable[V]) Lookup(ip netip.Addr) (val V, ok bool) {
+	e, ok := t.tbl.Lookup(ip)
+	if !ok {
+		return val, false
+	}
+
+	t.touch(e)
+	return e.val, true
+}
+
+// All returns an iterator over all prefixes and values of the table.
+// The iteration order is not specified, the access order is not changed.
+func (t *BoundedTable[V]) All() func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		t.tbl.All()(func(pfx netip.Prefix, e *boundedEntry[V]) bool {
+			return yield(pfx, e.val)
+		})
+	}
+}
+
+// Size returns the prefix count.
+func (t *BoundedTable[V]) Size() int {
+	return t.tbl.Size()
+}
+
+// isFull reports whether an insert of a new prefix needs an eviction.
+func (t *BoundedTable[V]) isFull() bool {
+	return t.capacity > 0 && t.tbl.Size() >= t.capacity
+}
+
+// touch marks e as most recently used.
+func (t *BoundedTable[V]) touch(e *boundedEntry[V]) {
+	if t.policy == EvictLRU {
+		t.lru.MoveToFront(e.lruElem)
+	}
+}
+
+// evict deletes an entry according to the policy and returns its prefix
+// and true, or false if the policy forbids an eviction.
+func (t *BoundedTable[V]) evict() (pfx netip.Prefix, ok bool) {
+	switch t.policy {
+	case EvictLRU:
+		if back := t.lru.Back(); back != nil {
+			pfx, ok = back.Value.(netip.Prefix), true
+		}
+	case EvictShortest:
+		for i := range t.byLen {
+			if front := t.byLen[i].Front(); front != nil {
+				pfx, ok = front.Value.(netip.Prefix), true
+				break
+			}
+		}
+	}
+
+	if ok {
+		t.Delete(pfx)
+	}
+
+	return pfx, ok
+}
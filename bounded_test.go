@@ -0,0 +1,184 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestBoundedTableUnbounded(t *testing.T) {
+	t.Parallel()
+
+	bt := new(BoundedTable[int])
+	if c, p := bt.Capacity(); c != 0 || p != EvictReject {
+		t.Errorf("Capacity, zero value, got (%d, %s), want (0, reject)", c, p)
+	}
+
+	for i, item := range randomPrefixes(1_000) {
+		if admitted, evicted := bt.Insert(item.pfx, i); !admitted || evicted.IsValid() {
+			t.Fatalf("Insert(%s), unbounded, got (%v, %s)", item.pfx, admitted, evicted)
+		}
+	}
+
+	if admitted, _ := bt.Insert(netip.Prefix{}, 0); admitted {
+		t.Errorf("Insert of invalid prefix, expected not admitted")
+	}
+}
+
+func TestBoundedTableReject(t *testing.T) {
+	t.Parallel()
+
+	bt := new(BoundedTable[int])
+	bt.SetCapacity(2, EvictReject)
+
+	bt.Insert(mpp("10.0.0.0/8"), 1)
+	bt.Insert(mpp("11.0.0.0/8"), 2)
+
+	if admitted, evicted := bt.Insert(mpp("12.0.0.0/8"), 3); admitted || evicted.IsValid() {
+		t.Errorf("Insert, full, got (%v, %s), want (false, invalid)", admitted, evicted)
+	}
+
+	// update of a present prefix is always admitted
+	if admitted, _ := bt.Insert(mpp("10.0.0.0/8"), 10); !admitted {
+		t.Errorf("Insert, update when full, expected admitted")
+	}
+	if val, _ := bt.Get(mpp("10.0.0.0/8")); val != 10 {
+		t.Errorf("Get after update, got %d, want 10", val)
+	}
+
+	bt.Delete(mpp("11.0.0.0/8"))
+	if admitted, _ := bt.Insert(mpp("12.0.0.0/8"), 3); !admitted {
+		t.Errorf("Insert after Delete, expected admitted")
+	}
+
+	// shrinking keeps the entries with reject
+	bt.SetCapacity(1, EvictReject)
+	if bt.Size() != 2 {
+		t.Errorf("SetCapacity, reject, expected size 2, got %d", bt.Size())
+	}
+}
+
+func TestBoundedTableLRU(t *testing.T) {
+	t.Parallel()
+
+	bt := new(BoundedTable[int])
+	bt.SetCapacity(3, EvictLRU)
+
+	bt.Insert(mpp("10.0.0.0/8"), 1)
+	bt.Insert(mpp("11.0.0.0/8"), 2)
+	bt.Insert(mpp("2001:db8::/32"), 3)
+
+	// use 10.0.0.0/8 by lookup and 2001:db8::/32 by get
+	if val, ok := bt.Lookup(mpa("10.1.2.3")); !ok || val != 1 {
+		t.Errorf("Lookup, got (%d, %v), want (1, true)", val, ok)
+	}
+	bt.Get(mpp("2001:db8::/32"))
+
+	admitted, evicted := bt.Insert(mpp("12.0.0.0/8"), 4)
+	if !admitted || evicted != mpp("11.0.0.0/8") {
+		t.Errorf("Insert, LRU, got (%v, %s), want (true, 11.0.0.0/8)", admitted, evicted)
+	}
+
+	if _, ok := bt.Get(mpp("11.0.0.0/8")); ok {
+		t.Errorf("Get, evicted prefix still present")
+	}
+
+	// now 10.0.0.0/8 is the least recently used
+	if _, evicted = bt.Insert(mpp("13.0.0.0/8"), 5); evicted != mpp("10.0.0.0/8") {
+		t.Errorf("Insert, LRU, evicted %s, want 10.0.0.0/8", evicted)
+	}
+
+	// shrink, evicts the least recently used immediately
+	bt.SetCapacity(1, EvictLRU)
+	if bt.Size() != 1 {
+		t.Fatalf("SetCapacity, LRU, expected size 1, got %d", bt.Size())
+	}
+	if _, ok := bt.Get(mpp("13.0.0.0/8")); !ok {
+		t.Errorf("SetCapacity, LRU, expected most recently used 13.0.0.0/8 to survive")
+	}
+}
+
+func TestBoundedTableShortest(t *testing.T) {
+	t.Parallel()
+
+	bt := new(BoundedTable[int])
+	bt.SetCapacity(3, EvictShortest)
+
+	bt.Insert(mpp("10.1.0.0/16"), 1)
+	bt.Insert(mpp("10.0.0.0/8"), 2)
+	bt.Insert(mpp("11.0.0.0/8"), 3)
+
+	// oldest of the shortest
+	if _, evicted := bt.Insert(mpp("10.1.1.0/24"), 4); evicted != mpp("10.0.0.0/8") {
+		t.Errorf("Insert, shortest, evicted %s, want 10.0.0.0/8", evicted)
+	}
+	if _, evicted := bt.Insert(mpp("10.1.1.1/32"), 5); evicted != mpp("11.0.0.0/8") {
+		t.Errorf("Insert, shortest, evicted %s, want 11.0.0.0/8", evicted)
+	}
+	if _, evicted := bt.Insert(mpp("::/0"), 6); evicted != mpp("10.1.0.0/16") {
+		t.Errorf("Insert, shortest, evicted %s, want 10.1.0.0/16", evicted)
+	}
+
+	want := map[netip.Prefix]int{
+		mpp("10.1.1.0/24"): 4,
+		mpp("10.1.1.1/32"): 5,
+		mpp("::/0"):        6,
+	}
+
+	got := map[netip.Prefix]int{}
+	bt.All()(func(pfx netip.Prefix, val int) bool {
+		got[pfx] = val
+		return true
+	})
+
+	if len(got) != len(want) {
+		t.Fatalf("All, got %v, want %v", got, want)
+	}
+	for pfx, val := range want {
+		if got[pfx] != val {
+			t.Errorf("All, %s, got %d, want %d", pfx, got[pfx], val)
+		}
+	}
+}
+
+func TestBoundedTableCompare(t *testing.T) {
+	t.Parallel()
+
+	const capacity = 100
+
+	for _, policy := range []EvictPolicy{EvictReject, EvictLRU, EvictShortest} {
+		bt := new(BoundedTable[int])
+		bt.SetCapacity(capacity, policy)
+
+		// the table mirrors the admitted and evicted prefixes
+		mirror := new(Table[int])
+
+		for i, item := range randomPrefixes(1_000) {
+			admitted, evicted := bt.Insert(item.pfx, i)
+
+			if evicted.IsValid() {
+				mirror.Delete(evicted)
+			}
+			if admitted {
+				mirror.Insert(item.pfx, i)
+			}
+
+			if bt.Size() > capacity || bt.Size() != mirror.Size() {
+				t.Fatalf("%s, size %d, mirror size %d, capacity %d", policy, bt.Size(), mirror.Size(), capacity)
+			}
+
+			if i%3 == 0 {
+				bt.Lookup(randomAddr())
+			}
+		}
+
+		mirror.All()(func(pfx netip.Prefix, want int) bool {
+			if got, ok := bt.Get(pfx); !ok || got != want {
+				t.Fatalf("%s, Get(%s), got (%d, %v), want (%d, true)", policy, pfx, got, ok, want)
+			}
+			return true
+		})
+	}
+}
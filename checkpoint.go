@@ -0,0 +1,161 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrCheckpointDiff is returned by [Table.ApplyCheckpointDiff] for malformed deltas.
+var ErrCheckpointDiff = errors.New("bart: malformed checkpoint diff")
+
+// checkpoint diff format version
+const checkpointDiffVersion = 1
+
+// checkpoint diff operations
+const (
+	opInsert byte = iota + 1
+	opUpdate
+	opDelete
+)
+
+// CheckpointDiff returns a compact binary delta of t relative to the
+// base snapshot, e.g. to replicate a table to peers by shipping deltas
+// instead of full snapshots. Applying the delta to base with
+// [Table.ApplyCheckpointDiff] yields a table equal to t.
+//
+// The delta is a version byte followed by a sequence of operations
+// (insert, update or delete) in CIDR sort order, each with the binary
+// encoded prefix and for inserts and updates the JSON encoded value,
+// all length-prefixed as uvarint. Values are compared by their JSON encoding.
+func (t *Table[V]) CheckpointDiff(base *Table[V]) ([]byte, error) {
+	buf := []byte{checkpointDiffVersion}
+
+	var err error
+	encode := func(val V) []byte {
+		data, e := json.Marshal(val)
+		if e != nil && err == nil {
+			err = e
+		}
+		return data
+	}
+
+	eq := func(a, b V) bool {
+		return bytes.Equal(encode(a), encode(b))
+	}
+
+	t.diffSorted(base, eq, func(pfx netip.Prefix, kind DiffKind, tVal, _ V) bool {
+		if err != nil {
+			return false
+		}
+
+		pfxData, _ := pfx.MarshalBinary()
+
+		switch kind {
+		case DiffMissingLeft:
+			buf = append(buf, opDelete)
+			buf = appendBytes(buf, pfxData)
+		case DiffMissingRight:
+			buf = append(buf, opInsert)
+			buf = appendBytes(buf, pfxData)
+			buf = appendBytes(buf, encode(tVal))
+		case DiffValue:
+			buf = append(buf, opUpdate)
+			buf = appendBytes(buf, pfxData)
+			buf = appendBytes(buf, encode(tVal))
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// ApplyCheckpointDiff applies a delta from [Table.CheckpointDiff] to the
+// receiver, which must be equal to the base of the delta.
+//
+// The delta is decoded completely before any change is made, for a malformed
+// delta an error wrapping [ErrCheckpointDiff] is returned and the receiver
+// is unchanged.
+func (t *Table[V]) ApplyCheckpointDiff(data []byte) error {
+	type op struct {
+		kind byte
+		pfx  netip.Prefix
+		val  V
+	}
+
+	if len(data) == 0 || data[0] != checkpointDiffVersion {
+		return fmt.Errorf("%w: unknown version", ErrCheckpointDiff)
+	}
+	data = data[1:]
+
+	var ops []op
+	for len(data) > 0 {
+		var o op
+		o.kind, data = data[0], data[1:]
+
+		var pfxData []byte
+		var err error
+		if pfxData, data, err = readBytes(data); err != nil {
+			return err
+		}
+		if err = o.pfx.UnmarshalBinary(pfxData); err != nil || !o.pfx.IsValid() {
+			return fmt.Errorf("%w: invalid prefix", ErrCheckpointDiff)
+		}
+
+		switch o.kind {
+		case opDelete:
+		case opInsert, opUpdate:
+			var valData []byte
+			if valData, data, err = readBytes(data); err != nil {
+				return err
+			}
+			if err = json.Unmarshal(valData, &o.val); err != nil {
+				return fmt.Errorf("%w: %w", ErrCheckpointDiff, err)
+			}
+		default:
+			return fmt.Errorf("%w: unknown operation %d", ErrCheckpointDiff, o.kind)
+		}
+
+		ops = append(ops, o)
+	}
+
+	for _, o := range ops {
+		switch o.kind {
+		case opDelete:
+			t.Delete(o.pfx)
+		default:
+			t.Insert(o.pfx, o.val)
+		}
+	}
+
+	return nil
+}
+
+// appendBytes, appends the uvarint length-prefixed data to buf.
+func appendBytes(buf, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// readBytes, reads uvarint length-prefixed data from buf
+// and returns the data and the rest of buf.
+func readBytes(buf []byte) (data, rest []byte, err error) {
+	n, size := binary.Uvarint(buf)
+	if size <= 0 || n > uint64(len(buf)-size) {
+		return nil, nil, fmt.Errorf("%w: truncated", ErrCheckpointDiff)
+	}
+
+	buf = buf[size:]
+	return buf[:n], buf[n:], nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestCheckpointDiff(t *testing.T) {
+	t.Parallel()
+
+	base := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		base.Insert(item.pfx, item.val)
+	}
+
+	// empty delta
+	data, err := base.CheckpointDiff(base)
+	if err != nil {
+		t.Fatalf("CheckpointDiff, unexpected error: %v", err)
+	}
+	if len(data) != 1 {
+		t.Errorf("CheckpointDiff of equal tables, expected only the version byte, got %d bytes", len(data))
+	}
+
+	next := base.Clone()
+	i := 0
+	base.All()(func(pfx netip.Prefix, val int) bool {
+		switch i % 10 {
+		case 0:
+			next.Delete(pfx)
+		case 1:
+			next.Insert(pfx, val+1)
+		}
+		i++
+		return true
+	})
+	for _, item := range randomPrefixes(1_000) {
+		next.Insert(item.pfx, item.val)
+	}
+
+	data, err = next.CheckpointDiff(base)
+	if err != nil {
+		t.Fatalf("CheckpointDiff, unexpected error: %v", err)
+	}
+
+	got := base.Clone()
+	if err := got.ApplyCheckpointDiff(data); err != nil {
+		t.Fatalf("ApplyCheckpointDiff, unexpected error: %v", err)
+	}
+
+	if _, kind, ok := got.FirstDifference(next, func(a, b int) bool { return a == b }); ok {
+		t.Fatalf("ApplyCheckpointDiff, result differs from table, %s", kind)
+	}
+
+	// nil base, full snapshot as delta
+	data, err = next.CheckpointDiff(nil)
+	if err != nil {
+		t.Fatalf("CheckpointDiff(nil), unexpected error: %v", err)
+	}
+	got = new(Table[int])
+	if err := got.ApplyCheckpointDiff(data); err != nil {
+		t.Fatalf("ApplyCheckpointDiff, unexpected error: %v", err)
+	}
+	if got.String() != next.String() {
+		t.Fatalf("ApplyCheckpointDiff of full delta, result differs from table")
+	}
+}
+
+func TestCheckpointDiffStruct(t *testing.T) {
+	t.Parallel()
+
+	type route struct {
+		NextHop string
+		Tags    []string
+	}
+
+	base := new(Table[route])
+	base.Insert(mpp("10.0.0.0/8"), route{"a", []string{"x"}})
+	base.Insert(mpp("10.1.0.0/16"), route{"b", nil})
+
+	next := base.Clone()
+	next.Insert(mpp("10.0.0.0/8"), route{"a", []string{"x", "y"}})
+	next.Delete(mpp("10.1.0.0/16"))
+	next.Insert(mpp("2001:db8::/32"), route{"c", nil})
+
+	data, err := next.CheckpointDiff(base)
+	if err != nil {
+		t.Fatalf("CheckpointDiff, unexpected error: %v", err)
+	}
+
+	if err := base.ApplyCheckpointDiff(data); err != nil {
+		t.Fatalf("ApplyCheckpointDiff, unexpected error: %v", err)
+	}
+
+	if base.String() != next.String() {
+		t.Errorf("ApplyCheckpointDiff, expected:\n%s\ngot:\n%s", next, base)
+	}
+
+	// values that can't be encoded
+	bad := new(Table[func()])
+	bad.Insert(mpp("10.0.0.0/8"), func() {})
+	if _, err := bad.CheckpointDiff(nil); err == nil {
+		t.Errorf("CheckpointDiff with func values, expected error")
+	}
+}
+
+func TestCheckpointDiffMalformed(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("10.1.0.0/16"), 2)
+
+	data, err := rt.CheckpointDiff(nil)
+	if err != nil {
+		t.Fatalf("CheckpointDiff, unexpected error: %v", err)
+	}
+
+	tests := map[string][]byte{
+		"empty":           nil,
+		"version":         append([]byte{99}, data[1:]...),
+		"truncated":       data[:len(data)-1],
+		"unknown op":      {checkpointDiffVersion, 42, 0},
+		"invalid prefix":  {checkpointDiffVersion, opDelete, 1, 0},
+		"invalid value":   append(append([]byte{}, data...), opInsert, 5, 10, 0, 0, 0, 8, 1, '{'),
+		"length overflow": {checkpointDiffVersion, opDelete, 0xff, 0xff, 0xff, 0xff, 0x0f},
+	}
+
+	for name, data := range tests {
+		c := rt.Clone()
+		c.Insert(mpp("2001:db8::/32"), 3)
+		before := c.String()
+
+		if err := c.ApplyCheckpointDiff(data); !errors.Is(err, ErrCheckpointDiff) {
+			t.Errorf("%s: ApplyCheckpointDiff, expected ErrCheckpointDiff, got %v", name, err)
+		}
+
+		// unchanged
+		if c.String() != before {
+			t.Errorf("%s: ApplyCheckpointDiff, table changed on error", name)
+		}
+	}
+}
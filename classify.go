@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// MatchClass classifies the matched prefix of a lookup, see [Table.LookupClassified].
+type MatchClass int
+
+const (
+	// MatchNone, no route matched.
+	MatchNone MatchClass = iota
+
+	// MatchHost, the matched prefix is a host route, /32 or /128.
+	MatchHost
+
+	// MatchSubnet, the matched prefix is neither a host nor the default route.
+	MatchSubnet
+
+	// MatchDefault, the matched prefix is the default route, /0.
+	MatchDefault
+)
+
+// String implements the [fmt.Stringer] interface.
+func (c MatchClass) String() string {
+	switch c {
+	case MatchHost:
+		return "host"
+	case MatchSubnet:
+		return "subnet"
+	case MatchDefault:
+		return "default"
+	default:
+		return "none"
+	}
+}
+
+// LookupClassified is similar to [Table.Lookup], but it also classifies
+// the longest prefix match as host route, subnet or default route,
+// e.g. firewalls log host specific, subnet and default matches differently.
+//
+// The prefix length of the match is already known during the lpm descent,
+// the classification comes for free.
+func (t *Table[V]) LookupClassified(ip netip.Addr) (val V, class MatchClass, ok bool) {
+	if !ip.IsValid() {
+		return val, MatchNone, false
+	}
+
+	is4 := ip.Is4()
+
+	var pfxLen int
+	if val, pfxLen, ok = t.rootNodeByVersion(is4).lookup(ip, is4); !ok {
+		return val, MatchNone, false
+	}
+
+	return val, classifyBits(pfxLen, ip.BitLen()), true
+}
+
+// classifyBits, match class of a matched prefix length.
+func classifyBits(bits, maxBits int) MatchClass {
+	switch bits {
+	case 0:
+		return MatchDefault
+	case maxBits:
+		return MatchHost
+	default:
+		return MatchSubnet
+	}
+}
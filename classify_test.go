@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestLookupClassified(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if _, class, ok := rt.LookupClassified(mpa("10.0.0.1")); ok || class != MatchNone {
+		t.Errorf("LookupClassified, empty table, got (%v, %v), want (none, false)", class, ok)
+	}
+
+	rt.Insert(mpp("0.0.0.0/0"), 0)
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("10.0.0.1/32"), 2)
+	rt.Insert(mpp("10.0.0.2/31"), 3)
+	rt.Insert(mpp("192.168.1.1/32"), 4) // path compressed leaf
+	rt.Insert(mpp("2001:db8::/32"), 5)
+	rt.Insert(mpp("2001:db8::1/128"), 6)
+
+	tests := []struct {
+		ip    netip.Addr
+		val   int
+		class MatchClass
+		ok    bool
+	}{
+		{mpa("10.0.0.1"), 2, MatchHost, true},
+		{mpa("10.0.0.3"), 3, MatchSubnet, true},
+		{mpa("10.1.0.0"), 1, MatchSubnet, true},
+		{mpa("11.0.0.0"), 0, MatchDefault, true},
+		{mpa("192.168.1.1"), 4, MatchHost, true},
+		{mpa("192.168.1.2"), 0, MatchDefault, true},
+		{mpa("2001:db8::1"), 6, MatchHost, true},
+		{mpa("2001:db8::2"), 5, MatchSubnet, true},
+		{mpa("2001:db9::1"), 0, MatchNone, false},
+		{netip.Addr{}, 0, MatchNone, false},
+	}
+
+	for _, tt := range tests {
+		val, class, ok := rt.LookupClassified(tt.ip)
+		if val != tt.val || class != tt.class || ok != tt.ok {
+			t.Errorf("LookupClassified(%s) = (%d, %v, %v), want (%d, %v, %v)",
+				tt.ip, val, class, ok, tt.val, tt.class, tt.ok)
+		}
+	}
+
+	// default route only, root fast path
+	rt = new(Table[int])
+	rt.Insert(mpp("::/0"), 7)
+	if val, class, ok := rt.LookupClassified(mpa("2001:db8::1")); val != 7 || class != MatchDefault || !ok {
+		t.Errorf("LookupClassified, default route only, got (%d, %v, %v), want (7, default, true)", val, class, ok)
+	}
+}
+
+func TestLookupClassifiedCompare(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	for _, item := range randomPrefixes(10_000) {
+		ip := item.pfx.Addr()
+
+		wantVal, wantOK := rt.Lookup(ip)
+		lpm, _, _ := rt.LookupPrefixLPM(netip.PrefixFrom(ip, ip.BitLen()))
+
+		wantClass := MatchNone
+		if wantOK {
+			wantClass = classifyBits(lpm.Bits(), ip.BitLen())
+		}
+
+		val, class, ok := rt.LookupClassified(ip)
+		if val != wantVal || class != wantClass || ok != wantOK {
+			t.Fatalf("LookupClassified(%s) = (%d, %v, %v), want (%d, %v, %v)",
+				ip, val, class, ok, wantVal, wantClass, wantOK)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"math"
+	"math/big"
+	"net/netip"
+	"slices"
+)
+
+// Complement returns a new table with the minimal set of CIDRs inside scope
+// that are not covered by any prefix of t, all set to the value fill.
+//
+// This is the classic default-deny generation, everything under scope
+// that is not explicitly allowed. If t has no prefix overlapping scope,
+// the result is a single entry for scope. If scope is invalid or completely
+// covered by t, the result is an empty table.
+func (t *Table[V]) Complement(scope netip.Prefix, fill V) *Table[V] {
+	c := new(Table[V])
+
+	t.gaps(scope)(func(first, last netip.Addr) bool {
+		return rangeToPrefixes(first, last, func(pfx netip.Prefix) bool {
+			c.Insert(pfx, fill)
+			return true
+		})
+	})
+
+	return c
+}
+
+// MergeCoverage joins two tables region-wise by address. The boundaries of
+// all prefixes in a and b split the covered address space into regions, in
+// each region the longest prefix matches of both tables are constant.
+//
+// For each region covered by a or b, combine is called with the lookup
+// results of both tables. If combine returns true, the region is inserted
+// into the result table as minimal list of CIDRs with the combined value.
+// Nil tables are treated as empty.
+//
+// This correlates two datasets by address, e.g. a route table and
+// a geo table.
+func MergeCoverage[V, W, R any](a *Table[V], b *Table[W], combine func(aVal V, aOK bool, bVal W, bOK bool) (R, bool)) *Table[R] {
+	m := new(Table[R])
+
+	// region boundaries, first address of each prefix and next address after it
+	var bounds []netip.Addr
+
+	addBounds := func(pfx netip.Prefix) {
+		bounds = append(bounds, pfx.Addr())
+		if next := lastAddr(pfx).Next(); next.IsValid() {
+			bounds = append(bounds, next)
+		}
+	}
+
+	if a == nil {
+		a = new(Table[V])
+	}
+	if b == nil {
+		b = new(Table[W])
+	}
+
+	a.All()(func(pfx netip.Prefix, _ V) bool {
+		addBounds(pfx)
+		return true
+	})
+	b.All()(func(pfx netip.Prefix, _ W) bool {
+		addBounds(pfx)
+		return true
+	})
+
+	slices.SortFunc(bounds, netip.Addr.Compare)
+	bounds = slices.Compact(bounds)
+
+	for i, first := range bounds {
+		aVal, aOK := a.Lookup(first)
+		bVal, bOK := b.Lookup(first)
+
+		if !aOK && !bOK {
+			continue
+		}
+
+		val, ok := combine(aVal, aOK, bVal, bOK)
+		if !ok {
+			continue
+		}
+
+		// the region ends before the next boundary of the same address family,
+		// or at the end of the address space
+		var last netip.Addr
+		if i+1 < len(bounds) && bounds[i+1].Is4() == first.Is4() {
+			last = bounds[i+1].Prev()
+		} else {
+			pfx, _ := first.Prefix(0)
+			last = lastAddr(pfx)
+		}
+
+		rangeToPrefixes(first, last, func(pfx netip.Prefix) bool {
+			m.Insert(pfx, val)
+			return true
+		})
+	}
+
+	return m
+}
+
+// EffectiveCoverage returns an iterator over the minimal set of disjoint
+// CIDRs tiling the covered address space within, each tile with its
+// effective value, the value a lookup returns for all addresses in the tile.
+// Overlapping prefixes are resolved into a flat cover, adjacent tiles with
+// equal values are merged. Addresses within not covered by any prefix of t
+// are skipped. The tiles are yielded in ascending address order.
+//
+// The tile values are compared with ==, see [Table.EffectiveCoverageFunc].
+func EffectiveCoverage[V comparable](t *Table[V], within netip.Prefix) func(yield func(netip.Prefix, V) bool) {
+	return t.EffectiveCoverageFunc(within, func(a, b V) bool { return a == b })
+}
+
+// EffectiveCoverageFunc is like [EffectiveCoverage], but the values are compared with eq.
+func (t *Table[V]) EffectiveCoverageFunc(within netip.Prefix, eq func(V, V) bool) func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		if !within.IsValid() {
+			return
+		}
+
+		// canonicalize the prefix
+		within = within.Masked()
+		withinLast := lastAddr(within)
+
+		// region boundaries inside within, the lookup result is constant in each region
+		bounds := []netip.Addr{within.Addr()}
+
+		t.Subnets(within)(func(pfx netip.Prefix, _ V) bool {
+			bounds = append(bounds, pfx.Addr())
+			if next := lastAddr(pfx).Next(); next.IsValid() && next.Compare(withinLast) <= 0 {
+				bounds = append(bounds, next)
+			}
+			return true
+		})
+
+		slices.SortFunc(bounds, netip.Addr.Compare)
+		bounds = slices.Compact(bounds)
+
+		// the current run of adjacent regions with equal values
+		var run struct {
+			first, last netip.Addr
+			val         V
+		}
+
+		flush := func() bool {
+			if !run.first.IsValid() {
+				return true
+			}
+			return rangeToPrefixes(run.first, run.last, func(pfx netip.Prefix) bool {
+				return yield(pfx, run.val)
+			})
+		}
+
+		for i, first := range bounds {
+			last := withinLast
+			if i+1 < len(bounds) {
+				last = bounds[i+1].Prev()
+			}
+
+			val, ok := t.Lookup(first)
+			if !ok {
+				if !flush() {
+					return
+				}
+				run.first = netip.Addr{}
+				continue
+			}
+
+			// extend the run
+			if run.first.IsValid() && eq(run.val, val) {
+				run.last = last
+				continue
+			}
+
+			if !flush() {
+				return
+			}
+			run.first, run.last, run.val = first, last, val
+		}
+
+		flush()
+	}
+}
+
+// MinimalCoverageSet returns a new table with the fewest entries that yields
+// identical lookup results as t for every address, the values compared with eq.
+// Unlike merging siblings, also entries made redundant by overlapping
+// less-specifics are dropped and regions may be re-covered by different prefixes.
+// The receiver is not changed.
+//
+// The values in the result are copies of the values in t, for each
+// entry one representative of the equal values is chosen.
+//
+// The algorithm is ORTC, the optimal routing table constructor, with the
+// constraint, that addresses not covered by t must stay uncovered.
+func (t *Table[V]) MinimalCoverageSet(eq func(V, V) bool) *Table[V] {
+	m := new(Table[V])
+
+	for _, root := range []netip.Prefix{netip.PrefixFrom(netip.IPv4Unspecified(), 0), netip.PrefixFrom(netip.IPv6Unspecified(), 0)} {
+		// the flat, disjoint cover of the address family
+		var tiles []ortcTile[V]
+		t.EffectiveCoverageFunc(root, eq)(func(pfx netip.Prefix, val V) bool {
+			tiles = append(tiles, ortcTile[V]{pfx, val})
+			return true
+		})
+
+		if len(tiles) == 0 {
+			continue
+		}
+
+		ortcBuild(root, tiles, eq).insertRec(m, nil, eq)
+	}
+
+	return m
+}
+
+// ortcTile, a tile of the flat cover.
+type ortcTile[V any] struct {
+	pfx netip.Prefix
+	val V
+}
+
+// ortcNode is a node in the binary trie of the flat cover.
+type ortcNode[V any] struct {
+	pfx netip.Prefix
+
+	// the candidate values, that can be inherited by the whole subtrie
+	set []V
+
+	// the subtrie contains uncovered addresses, no entry can be placed
+	// here, a covering entry would also cover the holes
+	holes bool
+
+	// nil for tiles
+	child [2]*ortcNode[V]
+}
+
+// ortcBuild builds the binary trie for the sorted and disjoint tiles
+// inside pfx and calculates the candidate sets bottom-up.
+func ortcBuild[V any](pfx netip.Prefix, tiles []ortcTile[V], eq func(V, V) bool) *ortcNode[V] {
+	n := &ortcNode[V]{pfx: pfx}
+
+	if len(tiles) == 0 {
+		n.holes = true
+		return n
+	}
+
+	if len(tiles) == 1 && tiles[0].pfx == pfx {
+		n.set = []V{tiles[0].val}
+		return n
+	}
+
+	// split into the lower and upper half of pfx
+	lower := netip.PrefixFrom(pfx.Addr(), pfx.Bits()+1)
+	upperAddr := lastAddr(lower).Next()
+	upper := netip.PrefixFrom(upperAddr, pfx.Bits()+1)
+
+	i, _ := slices.BinarySearchFunc(tiles, upperAddr, func(tile ortcTile[V], a netip.Addr) int {
+		return tile.pfx.Addr().Compare(a)
+	})
+
+	n.child[0] = ortcBuild(lower, tiles[:i], eq)
+	n.child[1] = ortcBuild(upper, tiles[i:], eq)
+
+	if n.child[0].holes || n.child[1].holes {
+		n.holes = true
+		return n
+	}
+
+	// the values common to both halves, or all values of both halves
+	l, r := n.child[0].set, n.child[1].set
+	for _, v := range l {
+		if slices.ContainsFunc(r, func(w V) bool { return eq(v, w) }) {
+			n.set = append(n.set, v)
+		}
+	}
+
+	if len(n.set) == 0 {
+		n.set = append(n.set, l...)
+		for _, w := range r {
+			if !slices.ContainsFunc(l, func(v V) bool { return eq(v, w) }) {
+				n.set = append(n.set, w)
+			}
+		}
+	}
+
+	return n
+}
+
+// insertRec inserts the entries for the subtrie at n into m, top-down.
+// The inherited value is nil, if no entry covers n.
+func (n *ortcNode[V]) insertRec(m *Table[V], inherited *V, eq func(V, V) bool) {
+	if n.holes {
+		for _, c := range n.child {
+			if c != nil {
+				c.insertRec(m, nil, eq)
+			}
+		}
+		return
+	}
+
+	if inherited == nil || !slices.ContainsFunc(n.set, func(v V) bool { return eq(*inherited, v) }) {
+		inherited = &n.set[0]
+		m.Insert(n.pfx, cloneOrCopyValue(*inherited))
+	}
+
+	for _, c := range n.child {
+		if c != nil {
+			c.insertRec(m, inherited, eq)
+		}
+	}
+}
+
+// CoverageOverlap returns the fraction of the address space covered by t,
+// that is also covered by other, in the range [0, 1].
+// If t covers no address at all, the result is 0.
+//
+// The addresses of both address families are counted together,
+// if t contains IPv6 prefixes, the IPv6 address space dominates the result.
+func (t *Table[V]) CoverageOverlap(other *Table[V]) float64 {
+	tRanges := t.coveredRanges()
+	if len(tRanges) == 0 {
+		return 0
+	}
+
+	var oRanges []addrRange
+	if other != nil {
+		oRanges = other.coveredRanges()
+	}
+
+	total := new(big.Int)
+	for _, r := range tRanges {
+		total.Add(total, r.size())
+	}
+
+	// intersection of the sorted and disjoint ranges
+	common := new(big.Int)
+	for i, j := 0, 0; i < len(tRanges) && j < len(oRanges); {
+		a, b := tRanges[i], oRanges[j]
+
+		first := a.first
+		if b.first.Compare(first) > 0 {
+			first = b.first
+		}
+
+		last := a.last
+		if b.last.Compare(last) < 0 {
+			last = b.last
+		}
+
+		if first.Compare(last) <= 0 {
+			common.Add(common, addrRange{first, last}.size())
+		}
+
+		// advance the range ending first
+		if a.last.Compare(b.last) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	ratio, _ := new(big.Rat).SetFrac(common, total).Float64()
+	return ratio
+}
+
+// Utilization returns the fraction of the address space of pfx covered by
+// the stored more-specific entries, in the range [0, 1], e.g. for IPAM
+// dashboards showing "this /16 is 73% allocated". The entry pfx itself is
+// not counted, overlapping subnets are counted only once.
+// For an invalid or uncovered pfx the result is 0.
+func (t *Table[V]) Utilization(pfx netip.Prefix) float64 {
+	if !pfx.IsValid() {
+		return 0
+	}
+
+	// canonicalize the prefix
+	pfx = pfx.Masked()
+
+	var used float64
+
+	// the last counted subnet, nested subnets follow in CIDR sort order
+	var top netip.Prefix
+
+	t.StrictSubnets(pfx)(func(sub netip.Prefix, _ V) bool {
+		if top.IsValid() && top.Overlaps(sub) {
+			return true
+		}
+		top = sub
+
+		used += math.Ldexp(1, pfx.Bits()-sub.Bits())
+		return true
+	})
+
+	return used
+}
+
+// addrRange, first and last address inclusive.
+type addrRange struct {
+	first, last netip.Addr
+}
+
+// size, the number of addresses in the range.
+func (r addrRange) size() *big.Int {
+	first := new(big.Int).SetBytes(r.first.AsSlice())
+	last := new(big.Int).SetBytes(r.last.AsSlice())
+
+	size := last.Sub(last, first)
+	return size.Add(size, big.NewInt(1))
+}
+
+// CoverageRanges returns an iterator over the maximal contiguous address
+// ranges covered by any prefix of t, first and last address inclusive,
+// e.g. for security reports preferring ranges over CIDRs. Adjacent and
+// nested prefixes are merged into runs, IPv4 and IPv6 separately.
+// The ranges are yielded in ascending address order, IPv4 before IPv6.
+func (t *Table[V]) CoverageRanges() func(yield func(first, last netip.Addr) bool) {
+	return func(yield func(first, last netip.Addr) bool) {
+		// the pending range
+		var cur addrRange
+
+		stop := false
+		t.AllSorted()(func(pfx netip.Prefix, _ V) bool {
+			first, last := pfx.Addr(), lastAddr(pfx)
+
+			if cur.first.IsValid() {
+				// covered by the pending range, subnets follow the supernet in sort order
+				if last.Compare(cur.last) <= 0 && first.Compare(cur.first) >= 0 {
+					return true
+				}
+
+				// adjacent, same address family
+				if next := cur.last.Next(); next == first {
+					cur.last = last
+					return true
+				}
+
+				if !yield(cur.first, cur.last) {
+					stop = true
+					return false
+				}
+			}
+
+			cur = addrRange{first, last}
+			return true
+		})
+
+		if stop || !cur.first.IsValid() {
+			return
+		}
+
+		yield(cur.first, cur.last)
+	}
+}
+
+// coveredRanges returns the maximal address ranges covered by any prefix of t,
+// in ascending address order, IPv4 before IPv6. Adjacent ranges are merged.
+func (t *Table[V]) coveredRanges() []addrRange {
+	var ranges []addrRange
+
+	t.CoverageRanges()(func(first, last netip.Addr) bool {
+		ranges = append(ranges, addrRange{first, last})
+		return true
+	})
+
+	return ranges
+}
+
+// gaps returns an iterator over the maximal address ranges inside scope,
+// first and last address inclusive, that are not covered by any prefix of t.
+// The ranges are yielded in ascending address order.
+func (t *Table[V]) gaps(scope netip.Prefix) func(yield func(first, last netip.Addr) bool) {
+	return func(yield func(first, last netip.Addr) bool) {
+		if !scope.IsValid() {
+			return
+		}
+
+		// canonicalize the prefix
+		scope = scope.Masked()
+
+		// scope is completely covered by a supernet, no gaps
+		if _, ok := t.LookupPrefix(scope); ok {
+			return
+		}
+
+		cursor := scope.Addr()
+		scopeLast := lastAddr(scope)
+
+		// the cursor has passed the last address of the address family
+		done := false
+
+		// Subnets are in CIDR sort order, a covering prefix is always
+		// visited before all its subnets.
+		stop := false
+		t.Subnets(scope)(func(pfx netip.Prefix, _ V) bool {
+			if pfx.Addr().Compare(cursor) > 0 {
+				if !yield(cursor, pfx.Addr().Prev()) {
+					stop = true
+					return false
+				}
+			}
+
+			// advance cursor, subnets covered by previous prefixes are skipped
+			if last := lastAddr(pfx); last.Compare(cursor) >= 0 {
+				cursor = last.Next()
+				if !cursor.IsValid() {
+					done = true
+					return false
+				}
+			}
+
+			return true
+		})
+
+		if stop || done || cursor.Compare(scopeLast) > 0 {
+			return
+		}
+
+		yield(cursor, scopeLast)
+	}
+}
+
+// rangeToPrefixes calls yield for the minimal list of CIDRs
+// spanning the address range from first to last inclusive.
+func rangeToPrefixes(first, last netip.Addr, yield func(netip.Prefix) bool) bool {
+	for first.Compare(last) <= 0 {
+		// find the shortest prefix starting at first and ending before last
+		bits := first.BitLen()
+		for bits > 0 {
+			pfx, _ := first.Prefix(bits - 1)
+			if pfx.Addr() != first || lastAddr(pfx).Compare(last) > 0 {
+				break
+			}
+			bits--
+		}
+
+		pfx := netip.PrefixFrom(first, bits)
+		if !yield(pfx) {
+			return false
+		}
+
+		first = lastAddr(pfx).Next()
+		if !first.IsValid() {
+			// overflow, end of address space
+			break
+		}
+	}
+
+	return true
+}
+
+// lastAddr returns the last address in pfx,
+// pfx must be valid and already in canonical form.
+func lastAddr(pfx netip.Prefix) netip.Addr {
+	ip := pfx.Addr()
+	bits := pfx.Bits()
+
+	is4 := ip.Is4()
+	if is4 {
+		bits += 96
+	}
+
+	a16 := ip.As16()
+
+	// set all host bits
+	for i := range a16 {
+		switch {
+		case bits >= (i+1)*8:
+			continue
+		case bits <= i*8:
+			a16[i] = 0xff
+		default:
+			a16[i] |= ^netMask(bits - i*8)
+		}
+	}
+
+	if is4 {
+		return netip.AddrFrom4([4]byte(a16[12:]))
+	}
+
+	return netip.AddrFrom16(a16)
+}
@@ -0,0 +1,685 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"math"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestLastAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pfx  netip.Prefix
+		want netip.Addr
+	}{
+		{mpp("0.0.0.0/0"), mpa("255.255.255.255")},
+		{mpp("10.0.0.0/8"), mpa("10.255.255.255")},
+		{mpp("10.1.2.0/23"), mpa("10.1.3.255")},
+		{mpp("10.1.2.3/32"), mpa("10.1.2.3")},
+		{mpp("::/0"), mpa("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")},
+		{mpp("2001:db8::/33"), mpa("2001:db8:7fff:ffff:ffff:ffff:ffff:ffff")},
+		{mpp("2001:db8::1/128"), mpa("2001:db8::1")},
+	}
+
+	for _, tt := range tests {
+		if got := lastAddr(tt.pfx); got != tt.want {
+			t.Errorf("lastAddr(%s), expected %s, got %s", tt.pfx, tt.want, got)
+		}
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		first, last netip.Addr
+		want        []netip.Prefix
+	}{
+		{
+			mpa("10.0.0.0"), mpa("10.0.0.255"),
+			[]netip.Prefix{mpp("10.0.0.0/24")},
+		},
+		{
+			mpa("10.0.0.1"), mpa("10.0.0.6"),
+			[]netip.Prefix{mpp("10.0.0.1/32"), mpp("10.0.0.2/31"), mpp("10.0.0.4/31"), mpp("10.0.0.6/32")},
+		},
+		{
+			mpa("0.0.0.0"), mpa("255.255.255.255"),
+			[]netip.Prefix{mpp("0.0.0.0/0")},
+		},
+		{
+			mpa("128.0.0.0"), mpa("255.255.255.255"),
+			[]netip.Prefix{mpp("128.0.0.0/1")},
+		},
+		{
+			mpa("::"), mpa("::1"),
+			[]netip.Prefix{mpp("::/127")},
+		},
+	}
+
+	for _, tt := range tests {
+		var got []netip.Prefix
+		rangeToPrefixes(tt.first, tt.last, func(pfx netip.Prefix) bool {
+			got = append(got, pfx)
+			return true
+		})
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rangeToPrefixes(%s, %s), expected %v, got %v", tt.first, tt.last, tt.want, got)
+		}
+	}
+}
+
+func TestComplement(t *testing.T) {
+	t.Parallel()
+
+	t.Run("empty table", func(t *testing.T) {
+		t.Parallel()
+
+		rt := new(Table[bool])
+		scope := mpp("10.0.0.0/8")
+
+		got := rt.Complement(scope, false)
+		if got.Size() != 1 {
+			t.Fatalf("Complement of empty table, expected 1 entry, got %d", got.Size())
+		}
+
+		if _, ok := got.Get(scope); !ok {
+			t.Errorf("Complement of empty table, expected scope %s", scope)
+		}
+
+		if got := rt.Complement(netip.Prefix{}, false); got.Size() != 0 {
+			t.Errorf("Complement with invalid scope, expected empty table, got %d", got.Size())
+		}
+	})
+
+	t.Run("simple", func(t *testing.T) {
+		t.Parallel()
+
+		rt := new(Table[bool])
+		rt.Insert(mpp("10.0.0.0/9"), true)
+		rt.Insert(mpp("10.0.1.0/24"), true)
+		rt.Insert(mpp("10.192.0.0/10"), true)
+		rt.Insert(mpp("11.0.0.0/8"), true)
+
+		got := rt.Complement(mpp("10.0.0.0/8"), false)
+
+		var pfxs []netip.Prefix
+		got.AllSorted()(func(pfx netip.Prefix, val bool) bool {
+			if val {
+				t.Errorf("Complement, expected fill value false for %s", pfx)
+			}
+			pfxs = append(pfxs, pfx)
+			return true
+		})
+
+		want := []netip.Prefix{mpp("10.128.0.0/10")}
+		if !reflect.DeepEqual(pfxs, want) {
+			t.Errorf("Complement, expected %v, got %v", want, pfxs)
+		}
+
+		// scope completely covered
+		if got := rt.Complement(mpp("11.1.0.0/16"), false); got.Size() != 0 {
+			t.Errorf("Complement of covered scope, expected empty table, got %d", got.Size())
+		}
+	})
+
+	t.Run("compare", func(t *testing.T) {
+		t.Parallel()
+
+		rt := new(Table[int])
+		for _, item := range randomPrefixes4(1_000) {
+			// no short prefixes, or the complement is mostly empty
+			if item.pfx.Bits() > 8 {
+				rt.Insert(item.pfx, item.val)
+			}
+		}
+
+		for _, scope := range []netip.Prefix{mpp("0.0.0.0/0"), mpp("128.0.0.0/2"), randomPrefix4()} {
+			c := rt.Complement(scope, 0)
+
+			if c.Overlaps(rt) {
+				t.Fatalf("Complement(%s) overlaps table", scope)
+			}
+
+			for range 10_000 {
+				ip := randomIP4()
+				if !scope.Contains(ip) {
+					continue
+				}
+
+				if rt.Contains(ip) == c.Contains(ip) {
+					t.Fatalf("Complement(%s), %s must be covered by exactly one table", scope, ip)
+				}
+			}
+		}
+	})
+}
+
+func TestCoverageOverlap(t *testing.T) {
+	t.Parallel()
+
+	a := new(Table[int])
+	b := new(Table[int])
+
+	if got := a.CoverageOverlap(b); got != 0 {
+		t.Errorf("empty tables, CoverageOverlap, expected 0, got %v", got)
+	}
+
+	a.Insert(mpp("10.0.0.0/8"), 1)
+	a.Insert(mpp("10.1.0.0/16"), 1) // nested, not counted twice
+
+	tests := []struct {
+		pfxs []string
+		want float64
+	}{
+		{nil, 0},
+		{[]string{"10.0.0.0/8"}, 1},
+		{[]string{"0.0.0.0/0"}, 1},
+		{[]string{"10.0.0.0/9"}, 0.5},
+		{[]string{"10.0.0.0/9", "10.128.0.0/10"}, 0.75},
+		{[]string{"10.0.0.0/10", "10.64.0.0/10", "11.0.0.0/8"}, 0.5}, // adjacent ranges
+		{[]string{"10.1.2.0/24"}, 1.0 / 65536},
+		{[]string{"2001:db8::/32", "9.0.0.0/8"}, 0},
+	}
+
+	for _, tt := range tests {
+		b := new(Table[int])
+		for _, s := range tt.pfxs {
+			b.Insert(mpp(s), 1)
+		}
+
+		if got := a.CoverageOverlap(b); got != tt.want {
+			t.Errorf("CoverageOverlap(%v), expected %v, got %v", tt.pfxs, tt.want, got)
+		}
+	}
+
+	if got := a.CoverageOverlap(nil); got != 0 {
+		t.Errorf("CoverageOverlap(nil), expected 0, got %v", got)
+	}
+
+	// asymmetric
+	b.Insert(mpp("10.0.0.0/7"), 1)
+	if got := b.CoverageOverlap(a); got != 0.5 {
+		t.Errorf("CoverageOverlap, reverse, expected 0.5, got %v", got)
+	}
+
+	// IPv6 dominates the result
+	a.Insert(mpp("2001:db8::/32"), 1)
+	if got := a.CoverageOverlap(b); got > 1e-20 {
+		t.Errorf("CoverageOverlap with IPv6, expected about 0, got %v", got)
+	}
+}
+
+func TestCoveredRanges(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, s := range []string{
+		"10.0.0.0/9",
+		"10.128.0.0/9",
+		"10.1.0.0/16",
+		"11.0.0.0/8",
+		"192.168.0.0/24",
+		"192.168.2.0/24",
+		"255.255.255.255/32",
+		"::/128",
+		"2001:db8::/32",
+	} {
+		rt.Insert(mpp(s), 1)
+	}
+
+	want := []addrRange{
+		{mpa("10.0.0.0"), mpa("11.255.255.255")},
+		{mpa("192.168.0.0"), mpa("192.168.0.255")},
+		{mpa("192.168.2.0"), mpa("192.168.2.255")},
+		{mpa("255.255.255.255"), mpa("255.255.255.255")},
+		{mpa("::"), mpa("::")},
+		{mpa("2001:db8::"), mpa("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff")},
+	}
+
+	if got := rt.coveredRanges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("coveredRanges, expected %v, got %v", want, got)
+	}
+}
+
+func TestCoverageRanges(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, s := range []string{
+		"10.0.0.0/9",
+		"10.128.0.0/9",
+		"10.1.0.0/16",
+		"11.0.0.0/8",
+		"192.168.0.0/24",
+		"255.255.255.255/32",
+		"::/128",
+		"2001:db8::/32",
+	} {
+		rt.Insert(mpp(s), 1)
+	}
+
+	var got []addrRange
+	rt.CoverageRanges()(func(first, last netip.Addr) bool {
+		got = append(got, addrRange{first, last})
+		return true
+	})
+
+	want := []addrRange{
+		{mpa("10.0.0.0"), mpa("11.255.255.255")},
+		{mpa("192.168.0.0"), mpa("192.168.0.255")},
+		{mpa("255.255.255.255"), mpa("255.255.255.255")},
+		{mpa("::"), mpa("::")},
+		{mpa("2001:db8::"), mpa("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff")},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CoverageRanges\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// maximal ranges, the neighbors are not covered
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	var prev addrRange
+	rt.CoverageRanges()(func(first, last netip.Addr) bool {
+		if !rt.Contains(first) || !rt.Contains(last) {
+			t.Fatalf("CoverageRanges, %s-%s not covered", first, last)
+		}
+		if p := first.Prev(); p.IsValid() && p.Is4() == first.Is4() && rt.Contains(p) {
+			t.Fatalf("CoverageRanges, %s-%s not maximal, %s is covered", first, last, p)
+		}
+		if n := last.Next(); n.IsValid() && rt.Contains(n) {
+			t.Fatalf("CoverageRanges, %s-%s not maximal, %s is covered", first, last, n)
+		}
+		if prev.last.IsValid() && prev.last.Compare(first) >= 0 {
+			t.Fatalf("CoverageRanges, %s-%s not after %s-%s", first, last, prev.first, prev.last)
+		}
+		prev = addrRange{first, last}
+		return true
+	})
+
+	// stop early
+	count := 0
+	rt.CoverageRanges()(func(netip.Addr, netip.Addr) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("CoverageRanges, stop early, expected 1 call, got %d", count)
+	}
+}
+
+func TestMergeCoverage(t *testing.T) {
+	t.Parallel()
+
+	routes := new(Table[string])
+	routes.Insert(mpp("10.0.0.0/8"), "r1")
+	routes.Insert(mpp("10.1.0.0/16"), "r2")
+	routes.Insert(mpp("255.255.255.0/24"), "r3")
+	routes.Insert(mpp("2001:db8::/32"), "r4")
+
+	geo := new(Table[int])
+	geo.Insert(mpp("10.0.0.0/9"), 49)
+	geo.Insert(mpp("192.168.0.0/16"), 1)
+	geo.Insert(mpp("2001:db8::/33"), 33)
+
+	type joined struct {
+		route string
+		geo   int
+	}
+
+	// inner join
+	inner := MergeCoverage(routes, geo, func(r string, rOK bool, g int, gOK bool) (joined, bool) {
+		return joined{r, g}, rOK && gOK
+	})
+
+	want := map[netip.Prefix]joined{
+		mpp("10.0.0.0/16"):   {"r1", 49},
+		mpp("10.1.0.0/16"):   {"r2", 49},
+		mpp("10.2.0.0/15"):   {"r1", 49},
+		mpp("10.4.0.0/14"):   {"r1", 49},
+		mpp("10.8.0.0/13"):   {"r1", 49},
+		mpp("10.16.0.0/12"):  {"r1", 49},
+		mpp("10.32.0.0/11"):  {"r1", 49},
+		mpp("10.64.0.0/10"):  {"r1", 49},
+		mpp("2001:db8::/33"): {"r4", 33},
+	}
+
+	got := map[netip.Prefix]joined{}
+	inner.All()(func(pfx netip.Prefix, val joined) bool {
+		got[pfx] = val
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeCoverage, inner join\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// nil tables are empty
+	if m := MergeCoverage[int, int](nil, nil, func(int, bool, int, bool) (int, bool) { return 0, true }); m.Size() != 0 {
+		t.Errorf("MergeCoverage of nil tables, expected empty table, got size %d", m.Size())
+	}
+
+	// compare outer join with lookups in both tables
+	a := new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		a.Insert(item.pfx, item.val)
+	}
+	b := new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		b.Insert(item.pfx, item.val)
+	}
+
+	outer := MergeCoverage(a, b, func(aVal int, aOK bool, bVal int, bOK bool) (joined, bool) {
+		var j joined
+		if aOK {
+			j.route = "a"
+			j.geo += aVal
+		}
+		if bOK {
+			j.route += "b"
+			j.geo -= bVal
+		}
+		return j, true
+	})
+
+	for range 10_000 {
+		ip := randomAddr()
+
+		aVal, aOK := a.Lookup(ip)
+		bVal, bOK := b.Lookup(ip)
+
+		var want joined
+		if aOK {
+			want.route = "a"
+			want.geo += aVal
+		}
+		if bOK {
+			want.route += "b"
+			want.geo -= bVal
+		}
+
+		got, ok := outer.Lookup(ip)
+		if ok != (aOK || bOK) || got != want {
+			t.Fatalf("MergeCoverage, Lookup(%s), got (%v, %v), want (%v, %v)", ip, got, ok, want, aOK || bOK)
+		}
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/16"), 1)
+	rt.Insert(mpp("10.0.0.0/17"), 2)
+	rt.Insert(mpp("10.0.0.0/18"), 3) // nested, counted once
+	rt.Insert(mpp("10.0.128.0/18"), 4)
+	rt.Insert(mpp("10.0.255.255/32"), 5)
+	rt.Insert(mpp("10.1.0.0/16"), 6)
+	rt.Insert(mpp("2001:db8::/33"), 7)
+
+	tests := []struct {
+		pfx  netip.Prefix
+		want float64
+	}{
+		{mpp("10.0.0.0/16"), 0.5 + 0.25 + 1.0/65536},
+		{mpp("10.0.0.0/17"), 0.5},
+		{mpp("10.0.0.0/15"), 1},
+		{mpp("10.0.0.0/8"), 2.0 / 256},
+		{mpp("10.0.0.0/18"), 0},
+		{mpp("11.0.0.0/8"), 0},
+		{mpp("2001:db8::/32"), 0.5},
+		{netip.MustParsePrefix("10.0.1.2/16"), 0.5 + 0.25 + 1.0/65536},
+		{netip.Prefix{}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := rt.Utilization(tt.pfx); got != tt.want {
+			t.Errorf("Utilization(%s) = %v, want %v", tt.pfx, got, tt.want)
+		}
+	}
+
+	// compare with the covered ranges of the strict subnets
+	rt = new(Table[int])
+	for _, item := range randomPrefixes4(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	for _, item := range randomPrefixes4(100) {
+		pfx, _ := item.pfx.Addr().Prefix(item.pfx.Bits() / 2)
+
+		sub := new(Table[int])
+		rt.StrictSubnets(pfx)(func(p netip.Prefix, v int) bool {
+			sub.Insert(p, v)
+			return true
+		})
+
+		used := new(big.Int)
+		for _, r := range sub.coveredRanges() {
+			used.Add(used, r.size())
+		}
+
+		want, _ := new(big.Float).Quo(new(big.Float).SetInt(used), new(big.Float).SetInt(addrRange{pfx.Addr(), lastAddr(pfx)}.size())).Float64()
+
+		if got := rt.Utilization(pfx); math.Abs(got-want) > 1e-12 {
+			t.Fatalf("Utilization(%s) = %v, want %v", pfx, got, want)
+		}
+	}
+}
+
+func TestEffectiveCoverage(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[string])
+	rt.Insert(mpp("10.0.0.0/8"), "a")
+	rt.Insert(mpp("10.0.0.0/9"), "b")
+	rt.Insert(mpp("10.0.0.0/10"), "b")
+	rt.Insert(mpp("10.128.0.0/10"), "a")
+	rt.Insert(mpp("10.255.0.0/16"), "c")
+	rt.Insert(mpp("11.0.0.0/8"), "c")
+
+	type tile struct {
+		pfx netip.Prefix
+		val string
+	}
+
+	var got []tile
+	EffectiveCoverage(rt, mpp("10.0.0.0/7"))(func(pfx netip.Prefix, val string) bool {
+		got = append(got, tile{pfx, val})
+		return true
+	})
+
+	want := []tile{
+		{mpp("10.0.0.0/9"), "b"},
+		{mpp("10.128.0.0/10"), "a"},
+		{mpp("10.192.0.0/11"), "a"},
+		{mpp("10.224.0.0/12"), "a"},
+		{mpp("10.240.0.0/13"), "a"},
+		{mpp("10.248.0.0/14"), "a"},
+		{mpp("10.252.0.0/15"), "a"},
+		{mpp("10.254.0.0/16"), "a"},
+		{mpp("10.255.0.0/16"), "c"},
+		{mpp("11.0.0.0/8"), "c"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EffectiveCoverage\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// within is covered by a supernet, uncovered addresses are skipped
+	got = nil
+	EffectiveCoverage(rt, netip.MustParsePrefix("10.1.2.3/16"))(func(pfx netip.Prefix, val string) bool {
+		got = append(got, tile{pfx, val})
+		return true
+	})
+	if want := []tile{{mpp("10.1.0.0/16"), "b"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EffectiveCoverage(10.1.2.3/16), got %v, want %v", got, want)
+	}
+
+	got = nil
+	EffectiveCoverage(rt, mpp("12.0.0.0/8"))(func(pfx netip.Prefix, val string) bool {
+		got = append(got, tile{pfx, val})
+		return true
+	})
+	if got != nil {
+		t.Errorf("EffectiveCoverage(12.0.0.0/8), expected no tiles, got %v", got)
+	}
+
+	// the tiles are disjoint and agree with lookups in t
+	for _, within := range []netip.Prefix{mpp("0.0.0.0/0"), mpp("::/0")} {
+		rt := new(Table[int])
+		for _, item := range randomPrefixes(1_000) {
+			rt.Insert(item.pfx, item.val%4)
+		}
+
+		flat := new(Table[int])
+		var prev netip.Prefix
+		EffectiveCoverage(rt, within)(func(pfx netip.Prefix, val int) bool {
+			if prev.IsValid() && lastAddr(prev).Compare(pfx.Addr()) >= 0 {
+				t.Fatalf("EffectiveCoverage, %s overlaps or precedes %s", pfx, prev)
+			}
+			prev = pfx
+			flat.Insert(pfx, val)
+			return true
+		})
+
+		for range 10_000 {
+			ip := randomAddr()
+			if !within.Contains(ip) {
+				continue
+			}
+
+			want, wantOK := rt.Lookup(ip)
+			got, gotOK := flat.Lookup(ip)
+			if got != want || gotOK != wantOK {
+				t.Fatalf("EffectiveCoverage, Lookup(%s), got (%v, %v), want (%v, %v)", ip, got, gotOK, want, wantOK)
+			}
+		}
+
+		// merged tiles can't be aggregated any further
+		count := flat.Size()
+		if flat.AggregateSiblings(func(a, b int) bool { return a == b }); flat.Size() != count {
+			t.Errorf("EffectiveCoverage(%s), tiling is not minimal", within)
+		}
+	}
+
+	// stop early
+	count := 0
+	EffectiveCoverage(rt, mpp("10.0.0.0/7"))(func(netip.Prefix, string) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("EffectiveCoverage, stop early, expected 1 call, got %d", count)
+	}
+}
+
+func TestMinimalCoverageSet(t *testing.T) {
+	t.Parallel()
+
+	eq := func(a, b string) bool { return a == b }
+
+	tests := []struct {
+		name string
+		in   map[string]string
+		want map[netip.Prefix]string
+	}{
+		{
+			name: "empty",
+			in:   map[string]string{},
+			want: map[netip.Prefix]string{},
+		},
+		{
+			name: "redundant subnet",
+			in:   map[string]string{"10.0.0.0/8": "a", "10.0.0.0/9": "a"},
+			want: map[netip.Prefix]string{mpp("10.0.0.0/8"): "a"},
+		},
+		{
+			name: "siblings",
+			in:   map[string]string{"10.0.0.0/9": "a", "10.128.0.0/9": "a"},
+			want: map[netip.Prefix]string{mpp("10.0.0.0/8"): "a"},
+		},
+		{
+			name: "swap less-specific",
+			in: map[string]string{
+				"10.0.0.0/8":    "a",
+				"10.0.0.0/10":   "b",
+				"10.64.0.0/10":  "b",
+				"10.128.0.0/10": "b",
+			},
+			want: map[netip.Prefix]string{mpp("10.0.0.0/8"): "b", mpp("10.192.0.0/10"): "a"},
+		},
+		{
+			name: "holes stay uncovered",
+			in:   map[string]string{"10.0.0.0/9": "a", "10.128.0.0/10": "a"},
+			want: map[netip.Prefix]string{mpp("10.0.0.0/9"): "a", mpp("10.128.0.0/10"): "a"},
+		},
+		{
+			name: "both families",
+			in:   map[string]string{"0.0.0.0/0": "a", "10.0.0.0/8": "a", "::/1": "b", "8000::/1": "b"},
+			want: map[netip.Prefix]string{mpp("0.0.0.0/0"): "a", mpp("::/0"): "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		rt := new(Table[string])
+		for s, v := range tt.in {
+			rt.Insert(mpp(s), v)
+		}
+
+		got := map[netip.Prefix]string{}
+		rt.MinimalCoverageSet(eq).All()(func(pfx netip.Prefix, val string) bool {
+			got[pfx] = val
+			return true
+		})
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MinimalCoverageSet, %s\ngot:  %v\nwant: %v", tt.name, got, tt.want)
+		}
+	}
+
+	// random tables, identical lookups with fewer entries
+	for range 3 {
+		rt := new(Table[int])
+		for _, item := range randomPrefixes(1_000) {
+			rt.Insert(item.pfx, item.val%3)
+		}
+
+		minimal := rt.MinimalCoverageSet(func(a, b int) bool { return a == b })
+		if minimal.Size() > rt.Size() {
+			t.Fatalf("MinimalCoverageSet, size %d greater than %d", minimal.Size(), rt.Size())
+		}
+
+		// identical flat covers, identical lookups for every address
+		for _, root := range []netip.Prefix{mpp("0.0.0.0/0"), mpp("::/0")} {
+			var want, got []Entry[int]
+			EffectiveCoverage(rt, root)(func(pfx netip.Prefix, val int) bool {
+				want = append(want, Entry[int]{pfx, val})
+				return true
+			})
+			EffectiveCoverage(minimal, root)(func(pfx netip.Prefix, val int) bool {
+				got = append(got, Entry[int]{pfx, val})
+				return true
+			})
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("MinimalCoverageSet, %s, effective coverage differs", root)
+			}
+		}
+
+		// already minimal
+		if again := minimal.MinimalCoverageSet(func(a, b int) bool { return a == b }); again.Size() != minimal.Size() {
+			t.Fatalf("MinimalCoverageSet, not idempotent, size %d, then %d", minimal.Size(), again.Size())
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrInconsistent is returned by [Table.AssertConsistent]
+// if an invariant of the internal trie is violated.
+var ErrInconsistent = errors.New("bart: inconsistent table")
+
+// DebugState is a snapshot of the internal counters of a table
+// per address family, see [Table.DebugState].
+type DebugState struct {
+	// Size4 and Size6 are the maintained entry counts, as reported by [Table.Size4] and [Table.Size6].
+	Size4, Size6 int
+
+	// Nodes4 and Nodes6 are the number of trie nodes, an empty root node is not counted.
+	Nodes4, Nodes6 int
+
+	// Prefixes4 and Prefixes6 are the number of prefixes stored in the nodes.
+	Prefixes4, Prefixes6 int
+
+	// Leaves4 and Leaves6 are the number of path compressed prefixes stored as leaves.
+	Leaves4, Leaves6 int
+}
+
+// DebugState returns a snapshot of the internal counters of t,
+// e.g. for fuzzers and property tests. The counters are recounted
+// by a traversal of the trie in O(nodes).
+func (t *Table[V]) DebugState() DebugState {
+	s4 := t.root4.nodeStatsRec()
+	s6 := t.root6.nodeStatsRec()
+
+	return DebugState{
+		Size4:     t.size4,
+		Size6:     t.size6,
+		Nodes4:    s4.nodes,
+		Nodes6:    s6.nodes,
+		Prefixes4: s4.pfxs,
+		Prefixes6: s6.pfxs,
+		Leaves4:   s4.leaves,
+		Leaves6:   s6.leaves,
+	}
+}
+
+// AssertConsistent verifies the invariants of the internal trie in O(nodes),
+// e.g. for fuzzers and property tests after arbitrary operation sequences.
+//
+// The maintained sizes are verified against an independent recount and the
+// position of every node, prefix and leaf against its path in the trie.
+// Every node below the root must be path compressed, a node with just
+// a single prefix or a single leaf must be a leaf instead.
+// The first inconsistency is returned as error wrapping [ErrInconsistent]
+// with the offending path or prefix, nil if the table is consistent.
+func (t *Table[V]) AssertConsistent() error {
+	for _, is4 := range []bool{true, false} {
+		n := t.rootNodeByVersion(is4)
+
+		count, err := n.assertConsistentRec([16]byte{}, 0, is4)
+		if err != nil {
+			return err
+		}
+
+		size := t.size6
+		if is4 {
+			size = t.size4
+		}
+
+		if count != size {
+			return fmt.Errorf("%w: %s size %d, recount %d", ErrInconsistent, familyName(is4), size, count)
+		}
+	}
+
+	return nil
+}
+
+// assertConsistentRec verifies the subtrie at n with path and depth, rec-descent.
+// The number of entries under n is returned.
+func (n *node[V]) assertConsistentRec(path [16]byte, depth int, is4 bool) (count int, err error) {
+	// the path of this node, as prefix
+	nodePfx := cidrFromPath(path, depth, is4, 1)
+
+	if depth > 0 && n.isEmpty() {
+		return 0, fmt.Errorf("%w: empty node at %s", ErrInconsistent, nodePfx)
+	}
+
+	if depth > 0 && n.isCompressible() {
+		return 0, fmt.Errorf("%w: node at %s, depth %d, path %v not path compressed",
+			ErrInconsistent, nodePfx, depth, path[:depth])
+	}
+
+	if n.prefixes.Size() != n.prefixes.Len() {
+		return 0, fmt.Errorf("%w: node at %s, %d prefix bits but %d items",
+			ErrInconsistent, nodePfx, n.prefixes.Size(), n.prefixes.Len())
+	}
+
+	if n.children.Size() != n.children.Len() {
+		return 0, fmt.Errorf("%w: node at %s, %d child bits but %d items",
+			ErrInconsistent, nodePfx, n.children.Size(), n.children.Len())
+	}
+
+	for _, idx := range n.prefixes.AsSlice(make([]uint, 0, maxNodePrefixes)) {
+		if idx == 0 || idx >= maxNodePrefixes {
+			return 0, fmt.Errorf("%w: node at %s, invalid prefix index %d", ErrInconsistent, nodePfx, idx)
+		}
+	}
+	count = n.prefixes.Len()
+
+	maxDepth := maxTreeDepth
+	if is4 {
+		maxDepth = 4
+	}
+
+	for i, addr := range n.children.AsSlice(make([]uint, 0, maxNodeChildren)) {
+		switch k := n.children.Items[i].(type) {
+		case *node[V]:
+			if depth+1 >= maxDepth {
+				return 0, fmt.Errorf("%w: node at %s, child node %d below max depth", ErrInconsistent, nodePfx, addr)
+			}
+
+			// rec-descent
+			path[depth] = byte(addr)
+			c, err := k.assertConsistentRec(path, depth+1, is4)
+			if err != nil {
+				return 0, err
+			}
+			count += c
+
+		case *leaf[V]:
+			if err := k.assertPosition(path, depth, is4, byte(addr)); err != nil {
+				return 0, fmt.Errorf("%w: leaf %s in node at %s, %s", ErrInconsistent, k.prefix, nodePfx, err)
+			}
+			count++
+
+		default:
+			return 0, fmt.Errorf("%w: node at %s, child %d of unknown type %T", ErrInconsistent, nodePfx, addr, k)
+		}
+	}
+
+	return count, nil
+}
+
+// assertPosition verifies the leaf stored in the child slot addr
+// of the node with path and depth.
+func (l *leaf[V]) assertPosition(path [16]byte, depth int, is4 bool, addr byte) error {
+	pfx := l.prefix
+
+	switch {
+	case !pfx.IsValid():
+		return errors.New("invalid prefix")
+	case pfx.Addr().Is4() != is4:
+		return errors.New("wrong address family")
+	case pfx != pfx.Masked():
+		return errors.New("prefix not canonical")
+	}
+
+	// a leaf must be deeper than the node's stride, else it's a node prefix
+	if lastIdx, _ := lastOctetIdxAndBits(pfx.Bits()); lastIdx <= depth {
+		return errors.New("prefix too short for this depth")
+	}
+
+	octets := ipAsOctets(pfx.Addr(), is4)
+	for i := range depth {
+		if octets[i] != path[i] {
+			return errors.New("prefix not on the path")
+		}
+	}
+
+	if octets[depth] != addr {
+		return errors.New("prefix not at child address")
+	}
+
+	return nil
+}
+
+// familyName, for error messages.
+func familyName(is4 bool) string {
+	if is4 {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// IndexedEntry is a table entry with its position in the
+// internal multibit trie, see [Table.AllWithIndex].
+type IndexedEntry[V any] struct {
+	Prefix netip.Prefix
+	Value  V
+
+	// Path are the octets of the strides above the last stride of the prefix,
+	// the path from the root node to the node the prefix belongs to.
+	Path []byte
+
+	// Index is the base index of the prefix in the complete binary tree
+	// of its last stride, in the range [1, 511]. A prefix with length
+	// l in its last stride and octet o has the index o>>(8-l) + 1<<l,
+	// e.g. 8.0.0.0/5 has the empty path and the index 8>>3 + 32 = 33.
+	Index uint
+
+	// Leaf is true if the prefix is stored path compressed as leaf in a
+	// node above, the Index is then derived and not stored in the node.
+	Leaf bool
+}
+
+// AllWithIndex returns an iterator over all entries of the table with their
+// stride path and base index in the internal allotment, e.g. for building
+// tooling compatible with the ART structure. The iteration order is not specified.
+func (t *Table[V]) AllWithIndex() func(yield func(IndexedEntry[V]) bool) {
+	return func(yield func(IndexedEntry[V]) bool) {
+		_ = t.root4.allRecIndexed(zeroPath, 0, true, yield) && t.root6.allRecIndexed(zeroPath, 0, false, yield)
+	}
+}
+
+// allRecIndexed runs recursive the trie like allRec, but yields the
+// entries with their path and base index.
+func (n *node[V]) allRecIndexed(path [16]byte, depth int, is4 bool, yield func(IndexedEntry[V]) bool) bool {
+	for _, idx := range n.prefixes.AsSlice(make([]uint, 0, maxNodePrefixes)) {
+		e := IndexedEntry[V]{
+			Prefix: cidrFromPath(path, depth, is4, idx),
+			Value:  n.prefixes.MustGet(idx),
+			Path:   append([]byte(nil), path[:depth]...),
+			Index:  idx,
+		}
+
+		if !yield(e) {
+			return false
+		}
+	}
+
+	for i, addr := range n.children.AsSlice(make([]uint, 0, maxNodeChildren)) {
+		switch k := n.children.Items[i].(type) {
+		case *node[V]:
+			path[depth] = byte(addr)
+			if !k.allRecIndexed(path, depth+1, is4, yield) {
+				return false
+			}
+		case *leaf[V]:
+			// derive the index from the last stride of the prefix
+			lastIdx, lastBits := lastOctetIdxAndBits(k.prefix.Bits())
+			octets := ipAsOctets(k.prefix.Addr(), is4)
+
+			e := IndexedEntry[V]{
+				Prefix: k.prefix,
+				Value:  k.value,
+				Path:   append([]byte(nil), octets[:lastIdx]...),
+				Index:  pfxToIdx(octets[lastIdx], lastBits),
+				Leaf:   true,
+			}
+
+			if !yield(e) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestDebugState(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if got := rt.DebugState(); got != (DebugState{}) {
+		t.Errorf("DebugState, empty table, got %+v", got)
+	}
+
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("10.1.0.0/16"), 2)
+	rt.Insert(mpp("10.1.2.0/24"), 3)
+	rt.Insert(mpp("2001:db8::/32"), 4)
+
+	want := DebugState{
+		Size4:     3,
+		Size6:     1,
+		Nodes4:    2,
+		Nodes6:    1,
+		Prefixes4: 2,
+		Prefixes6: 0,
+		Leaves4:   1,
+		Leaves6:   1,
+	}
+
+	if got := rt.DebugState(); got != want {
+		t.Errorf("DebugState\ngot:  %+v\nwant: %+v", got, want)
+	}
+
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	s := rt.DebugState()
+	if s.Prefixes4+s.Leaves4 != s.Size4 || s.Prefixes6+s.Leaves6 != s.Size6 {
+		t.Errorf("DebugState, recount differs from size: %+v", s)
+	}
+}
+
+func TestAssertConsistent(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if err := rt.AssertConsistent(); err != nil {
+		t.Errorf("AssertConsistent, empty table, unexpected error: %v", err)
+	}
+
+	// arbitrary sequence of inserts and deletes
+	pfxs := randomPrefixes(10_000)
+
+	for i, item := range pfxs {
+		rt.Insert(item.pfx, item.val)
+
+		if i%1_000 == 0 {
+			if err := rt.AssertConsistent(); err != nil {
+				t.Fatalf("AssertConsistent after %d inserts: %v", i+1, err)
+			}
+		}
+	}
+
+	for i, item := range pfxs {
+		if i%3 == 0 {
+			rt.Delete(item.pfx)
+		}
+	}
+
+	if err := rt.AssertConsistent(); err != nil {
+		t.Fatalf("AssertConsistent after deletes: %v", err)
+	}
+
+	var bulk []netip.Prefix
+	for i, item := range pfxs {
+		if i%3 == 1 {
+			bulk = append(bulk, item.pfx)
+		}
+	}
+	rt.DeleteAll(bulk)
+
+	if err := rt.AssertConsistent(); err != nil {
+		t.Fatalf("AssertConsistent after DeleteAll: %v", err)
+	}
+
+	// corrupt the size
+	c := rt.Clone()
+	c.size4++
+	if err := c.AssertConsistent(); !errors.Is(err, ErrInconsistent) {
+		t.Errorf("AssertConsistent, wrong size4, expected ErrInconsistent, got %v", err)
+	}
+
+	// corrupt a leaf, move it to another child address
+	rt = new(Table[int])
+	rt.Insert(mpp("10.1.0.0/16"), 1)
+
+	l := rt.root4.children.Items[0].(*leaf[int])
+	l.prefix = mpp("11.1.0.0/16")
+
+	if err := rt.AssertConsistent(); !errors.Is(err, ErrInconsistent) {
+		t.Errorf("AssertConsistent, leaf not on path, expected ErrInconsistent, got %v", err)
+	}
+
+	// a leaf too short for its depth
+	l.prefix = mpp("10.0.0.0/8")
+
+	if err := rt.AssertConsistent(); !errors.Is(err, ErrInconsistent) {
+		t.Errorf("AssertConsistent, leaf too short, expected ErrInconsistent, got %v", err)
+	}
+
+	// a node with a single prefix, not path compressed
+	rt = new(Table[int])
+	rt.Insert(mpp("10.1.0.0/16"), 1)
+	rt.Insert(mpp("10.2.0.0/16"), 2)
+
+	n := rt.root4.children.Items[0].(*node[int])
+	n.prefixes.DeleteAt(pfxToIdx(2, 8))
+	rt.size4--
+
+	if err := rt.AssertConsistent(); !errors.Is(err, ErrInconsistent) {
+		t.Errorf("AssertConsistent, node not path compressed, expected ErrInconsistent, got %v", err)
+	}
+}
+
+func TestAllWithIndex(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("0.0.0.0/0"), 0)
+	rt.Insert(mpp("8.0.0.0/5"), 1)
+	rt.Insert(mpp("10.1.0.0/16"), 2)
+	rt.Insert(mpp("10.1.0.0/17"), 3)
+	rt.Insert(mpp("2001:db8::/32"), 4)
+
+	want := map[netip.Prefix]IndexedEntry[int]{
+		mpp("0.0.0.0/0"):     {mpp("0.0.0.0/0"), 0, nil, 1, false},
+		mpp("8.0.0.0/5"):     {mpp("8.0.0.0/5"), 1, nil, 33, false},
+		mpp("10.1.0.0/16"):   {mpp("10.1.0.0/16"), 2, []byte{10}, 256 + 1, false},
+		mpp("10.1.0.0/17"):   {mpp("10.1.0.0/17"), 3, []byte{10, 1}, 2, true},
+		mpp("2001:db8::/32"): {mpp("2001:db8::/32"), 4, []byte{0x20, 0x01, 0x0d}, 256 + 0xb8, true},
+	}
+
+	got := map[netip.Prefix]IndexedEntry[int]{}
+	rt.AllWithIndex()(func(e IndexedEntry[int]) bool {
+		got[e.Prefix] = e
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AllWithIndex\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// the prefix is reconstructed from path and index
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	count, leaves := 0, 0
+	rt.AllWithIndex()(func(e IndexedEntry[int]) bool {
+		count++
+		if e.Leaf {
+			leaves++
+		}
+
+		var path [16]byte
+		copy(path[:], e.Path)
+
+		is4 := e.Prefix.Addr().Is4()
+		if pfx := cidrFromPath(path, len(e.Path), is4, e.Index); pfx != e.Prefix {
+			t.Fatalf("AllWithIndex, %s, path %v and index %d give %s", e.Prefix, e.Path, e.Index, pfx)
+		}
+
+		if val, _ := rt.Get(e.Prefix); val != e.Value {
+			t.Fatalf("AllWithIndex, %s, got value %d, want %d", e.Prefix, e.Value, val)
+		}
+		return true
+	})
+
+	s := rt.DebugState()
+	if count != rt.Size() || leaves != s.Leaves4+s.Leaves6 {
+		t.Errorf("AllWithIndex, got %d entries and %d leaves, want %d and %d", count, leaves, rt.Size(), s.Leaves4+s.Leaves6)
+	}
+
+	// stop early
+	count = 0
+	rt.AllWithIndex()(func(IndexedEntry[int]) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("AllWithIndex, stop early, expected 1 call, got %d", count)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// Decision is the payload of an allow/deny ACL table, see [Evaluate].
+type Decision bool
+
+const (
+	// Deny, the zero value.
+	Deny Decision = false
+
+	// Allow.
+	Allow Decision = true
+)
+
+// String implements the [fmt.Stringer] interface.
+func (d Decision) String() string {
+	if d {
+		return "allow"
+	}
+	return "deny"
+}
+
+// Evaluate returns the decision of the longest prefix match for ip in the
+// ACL table t, or defaultDecision if no prefix matched or t is nil.
+//
+// Go has no methods on instantiated generic types,
+// therefore Evaluate is a function and not a method.
+func Evaluate(t *Table[Decision], ip netip.Addr, defaultDecision Decision) Decision {
+	if t == nil {
+		return defaultDecision
+	}
+	return t.LookupOr(ip, defaultDecision)
+}
+
+// EvaluatePrefix is like [Evaluate], but for the longest prefix match of pfx,
+// as reported by [Table.LookupPrefix]. The decision applies to the whole pfx.
+func EvaluatePrefix(t *Table[Decision], pfx netip.Prefix, defaultDecision Decision) Decision {
+	if t == nil {
+		return defaultDecision
+	}
+	if d, ok := t.LookupPrefix(pfx); ok {
+		return d
+	}
+	return defaultDecision
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestDecisionString(t *testing.T) {
+	t.Parallel()
+
+	if Allow.String() != "allow" || Deny.String() != "deny" {
+		t.Errorf("Decision.String, got %q and %q", Allow, Deny)
+	}
+
+	var zero Decision
+	if zero != Deny {
+		t.Errorf("Decision, zero value must be Deny")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	acl := new(Table[Decision])
+	acl.Insert(mpp("10.0.0.0/8"), Allow)
+	acl.Insert(mpp("10.1.0.0/16"), Deny)
+	acl.Insert(mpp("10.1.1.0/24"), Allow)
+	acl.Insert(mpp("2001:db8::/32"), Allow)
+
+	tests := []struct {
+		ip   netip.Addr
+		def  Decision
+		want Decision
+	}{
+		{mpa("10.0.0.1"), Deny, Allow},
+		{mpa("10.1.0.1"), Allow, Deny},
+		{mpa("10.1.1.1"), Deny, Allow},
+		{mpa("11.0.0.1"), Deny, Deny},
+		{mpa("11.0.0.1"), Allow, Allow},
+		{mpa("2001:db8::1"), Deny, Allow},
+		{mpa("2001:db9::1"), Deny, Deny},
+		{netip.Addr{}, Allow, Allow},
+	}
+
+	for _, tt := range tests {
+		if got := Evaluate(acl, tt.ip, tt.def); got != tt.want {
+			t.Errorf("Evaluate(%s, %s), got %s, want %s", tt.ip, tt.def, got, tt.want)
+		}
+	}
+
+	pfxTests := []struct {
+		pfx  netip.Prefix
+		def  Decision
+		want Decision
+	}{
+		{mpp("10.0.0.0/8"), Deny, Allow},
+		{mpp("10.1.0.0/17"), Allow, Deny},
+		{mpp("10.1.1.0/25"), Deny, Allow},
+		{mpp("0.0.0.0/0"), Allow, Allow},
+		{mpp("0.0.0.0/0"), Deny, Deny},
+		{netip.Prefix{}, Allow, Allow},
+	}
+
+	for _, tt := range pfxTests {
+		if got := EvaluatePrefix(acl, tt.pfx, tt.def); got != tt.want {
+			t.Errorf("EvaluatePrefix(%s, %s), got %s, want %s", tt.pfx, tt.def, got, tt.want)
+		}
+	}
+
+	// nil table
+	if Evaluate(nil, mpa("10.0.0.1"), Allow) != Allow || EvaluatePrefix(nil, mpp("10.0.0.0/8"), Deny) != Deny {
+		t.Errorf("Evaluate of nil table, expected default decision")
+	}
+}
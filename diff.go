@@ -0,0 +1,305 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+)
+
+// DiffKind describes how two tables differ for a prefix, see [Table.FirstDifference].
+type DiffKind int
+
+const (
+	// DiffMissingLeft, the prefix is only in the other table.
+	DiffMissingLeft DiffKind = iota + 1
+
+	// DiffMissingRight, the prefix is only in the receiver table.
+	DiffMissingRight
+
+	// DiffValue, the prefix is in both tables but the values differ.
+	DiffValue
+)
+
+// String implements the [fmt.Stringer] interface.
+func (k DiffKind) String() string {
+	switch k {
+	case DiffMissingLeft:
+		return "missing-left"
+	case DiffMissingRight:
+		return "missing-right"
+	case DiffValue:
+		return "value-differs"
+	default:
+		return "no-diff"
+	}
+}
+
+// FirstDifference returns the first prefix in CIDR sort order, IPv4 before IPv6,
+// where the tables diverge, how they diverge and true.
+// If the tables are equal, ok is false.
+//
+// The values of prefixes in both tables are compared with eq,
+// if eq is nil only the prefixes are compared.
+func (t *Table[V]) FirstDifference(other *Table[V], eq func(V, V) bool) (pfx netip.Prefix, kind DiffKind, ok bool) {
+	t.diffSorted(other, eq, func(p netip.Prefix, k DiffKind, _, _ V) bool {
+		pfx, kind, ok = p, k, true
+		return false
+	})
+
+	return pfx, kind, ok
+}
+
+// SymmetricDifference returns a new table with the entries present in
+// exactly one of the tables t and other, with the value from the table
+// holding the prefix. The values of prefixes in both tables are not compared.
+// The receiver and other are not changed.
+func (t *Table[V]) SymmetricDifference(other *Table[V]) *Table[V] {
+	d := new(Table[V])
+
+	t.diffSorted(other, nil, func(pfx netip.Prefix, kind DiffKind, tVal, oVal V) bool {
+		switch kind {
+		case DiffMissingLeft:
+			d.Insert(pfx, cloneOrCopyValue(oVal))
+		case DiffMissingRight:
+			d.Insert(pfx, cloneOrCopyValue(tVal))
+		}
+		return true
+	})
+
+	return d
+}
+
+// ReconcileTo changes t in place to match target with the minimal number
+// of operations, e.g. for config management agents making the live table
+// equal to the desired state instead of clear and reload.
+//
+// Prefixes only in target are inserted, prefixes only in t are deleted
+// and prefixes with values different as reported by eq are updated.
+// The number of each operation is returned. The values from target are
+// cloned if V implements the [Cloner] interface. A nil target is
+// treated as an empty table.
+//
+// A nil eq treats all values as unequal, every common prefix is
+// overwritten with the value from target.
+func (t *Table[V]) ReconcileTo(target *Table[V], eq func(V, V) bool) (inserts, deletes, updates int) {
+	if eq == nil {
+		eq = func(V, V) bool { return false }
+	}
+
+	type op struct {
+		pfx  netip.Prefix
+		kind DiffKind
+		val  V
+	}
+
+	// collect the diff first, t must not be changed during iteration
+	var ops []op
+	t.diffSorted(target, eq, func(pfx netip.Prefix, kind DiffKind, _, oVal V) bool {
+		ops = append(ops, op{pfx, kind, oVal})
+		return true
+	})
+
+	for _, o := range ops {
+		switch o.kind {
+		case DiffMissingLeft:
+			t.Insert(o.pfx, cloneOrCopyValue(o.val))
+			inserts++
+		case DiffMissingRight:
+			t.Delete(o.pfx)
+			deletes++
+		case DiffValue:
+			t.Insert(o.pfx, cloneOrCopyValue(o.val))
+			updates++
+		}
+	}
+
+	return inserts, deletes, updates
+}
+
+// FprintDiff writes a unified-diff-style listing of the differences from t
+// to other to w, in CIDR sort order, IPv4 before IPv6, e.g. for reviewing
+// two snapshots of a routing table. Identical tables produce no output.
+//
+// Prefixes only in t are prefixed with "-", prefixes only in other with "+"
+// and prefixes with different values with "~", formatted as in [Table.Fprint]:
+//
+//	~ 10.0.0.0/8 (1 -> 2)
+//	- 10.1.0.0/16 (3)
+//	+ 192.168.0.0/16 (4)
+//
+// The values are formatted by fmtVal, if fmtVal is nil by [fmt.Sprint].
+// Values with equal formatted strings are considered equal.
+func (t *Table[V]) FprintDiff(w io.Writer, other *Table[V], fmtVal func(V) string) error {
+	if fmtVal == nil {
+		fmtVal = func(v V) string { return fmt.Sprint(v) }
+	}
+
+	eq := func(a, b V) bool { return fmtVal(a) == fmtVal(b) }
+
+	var err error
+	t.diffSorted(other, eq, func(pfx netip.Prefix, kind DiffKind, tVal, oVal V) bool {
+		switch kind {
+		case DiffMissingLeft:
+			_, err = fmt.Fprintf(w, "+ %s (%s)\n", pfx, fmtVal(oVal))
+		case DiffMissingRight:
+			_, err = fmt.Fprintf(w, "- %s (%s)\n", pfx, fmtVal(tVal))
+		case DiffValue:
+			_, err = fmt.Fprintf(w, "~ %s (%s -> %s)\n", pfx, fmtVal(tVal), fmtVal(oVal))
+		}
+		return err == nil
+	})
+
+	return err
+}
+
+// diffSorted calls yield in CIDR sort order for every prefix where t and o differ,
+// with the kind of difference and the values of t and o, if present.
+// Stops if yield returns false.
+//
+// Both tables are walked in lockstep with pull-based cursors,
+// only the nodes up to the last yielded difference are visited.
+func (t *Table[V]) diffSorted(o *Table[V], eq func(V, V) bool, yield func(netip.Prefix, DiffKind, V, V) bool) bool {
+	var zero V
+
+	tc, oc := newSortedCursor(t), newSortedCursor(o)
+
+	tPfx, tVal, tOk := tc.next()
+	oPfx, oVal, oOk := oc.next()
+
+	for tOk || oOk {
+		order := 0
+		switch {
+		case !oOk:
+			order = -1
+		case !tOk:
+			order = 1
+		default:
+			order = cmpPrefix(tPfx, oPfx)
+		}
+
+		switch {
+		case order < 0:
+			if !yield(tPfx, DiffMissingRight, tVal, zero) {
+				return false
+			}
+			tPfx, tVal, tOk = tc.next()
+
+		case order > 0:
+			if !yield(oPfx, DiffMissingLeft, zero, oVal) {
+				return false
+			}
+			oPfx, oVal, oOk = oc.next()
+
+		default:
+			if eq != nil && !eq(tVal, oVal) {
+				if !yield(tPfx, DiffValue, tVal, oVal) {
+					return false
+				}
+			}
+			tPfx, tVal, tOk = tc.next()
+			oPfx, oVal, oOk = oc.next()
+		}
+	}
+
+	return true
+}
+
+// sortedCursor is a pull-based iterator over the prefixes of a table
+// in CIDR sort order, IPv4 before IPv6, see [Table.AllSorted].
+type sortedCursor[V any] struct {
+	stack []cursorFrame[V]
+}
+
+// cursorFrame, a node on the cursor stack with its items
+// in CIDR sort order and the position of the next item.
+type cursorFrame[V any] struct {
+	n     *node[V]
+	path  [16]byte
+	depth int
+	is4   bool
+
+	items []cursorItem
+	built bool
+	pos   int
+}
+
+// cursorItem, a prefix index or a child addr in a node.
+type cursorItem struct {
+	key   int
+	idx   uint
+	child bool
+}
+
+// newSortedCursor returns a cursor for t, a nil table is empty.
+func newSortedCursor[V any](t *Table[V]) *sortedCursor[V] {
+	c := new(sortedCursor[V])
+	if t == nil {
+		return c
+	}
+
+	// LIFO, IPv4 on top
+	c.stack = append(c.stack,
+		cursorFrame[V]{n: &t.root6, is4: false},
+		cursorFrame[V]{n: &t.root4, is4: true},
+	)
+
+	return c
+}
+
+// next returns the next prefix and value and true, or false if exhausted.
+func (c *sortedCursor[V]) next() (pfx netip.Prefix, val V, ok bool) {
+	for len(c.stack) > 0 {
+		f := &c.stack[len(c.stack)-1]
+
+		// sort the items of the node on first access
+		if !f.built {
+			f.items = f.n.sortedItems()
+			f.built = true
+		}
+
+		if f.pos == len(f.items) {
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+
+		item := f.items[f.pos]
+		f.pos++
+
+		if !item.child {
+			return cidrFromPath(f.path, f.depth, f.is4, item.idx), f.n.prefixes.MustGet(item.idx), true
+		}
+
+		switch k := f.n.children.MustGet(item.idx).(type) {
+		case *leaf[V]:
+			return k.prefix, k.value, true
+		case *node[V]:
+			path := f.path
+			path[f.depth] = byte(item.idx)
+			c.stack = append(c.stack, cursorFrame[V]{n: k, path: path, depth: f.depth + 1, is4: f.is4})
+		}
+	}
+
+	return pfx, val, false
+}
+
+// sortedItems returns the prefix indices and child addrs of n in CIDR sort order.
+func (n *node[V]) sortedItems() []cursorItem {
+	items := make([]cursorItem, 0, n.prefixes.Len()+n.children.Len())
+
+	for _, idx := range n.prefixes.AsSlice(make([]uint, 0, maxNodePrefixes)) {
+		items = append(items, cursorItem{key: pfxSortKey(idx), idx: idx})
+	}
+
+	for _, addr := range n.children.AsSlice(make([]uint, 0, maxNodeChildren)) {
+		items = append(items, cursorItem{key: childSortKey(addr), idx: addr, child: true})
+	}
+
+	slices.SortFunc(items, func(a, b cursorItem) int { return cmp.Compare(a.key, b.key) })
+
+	return items
+}
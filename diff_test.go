@@ -0,0 +1,449 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"io"
+	"net/netip"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFirstDifference(t *testing.T) {
+	t.Parallel()
+
+	eq := func(a, b int) bool { return a == b }
+
+	a := new(Table[int])
+	b := new(Table[int])
+
+	if _, _, ok := a.FirstDifference(b, eq); ok {
+		t.Errorf("empty tables, FirstDifference, expected no difference")
+	}
+
+	for i, s := range []string{"10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/16", "2001:db8::/32"} {
+		a.Insert(mpp(s), i)
+		b.Insert(mpp(s), i)
+	}
+
+	if pfx, kind, ok := a.FirstDifference(b, eq); ok {
+		t.Errorf("equal tables, FirstDifference, expected no difference, got (%s, %s)", pfx, kind)
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(a, b *Table[int])
+		wantPfx  netip.Prefix
+		wantKind DiffKind
+		nilEq    bool
+	}{
+		{
+			name:     "missing right",
+			modify:   func(a, _ *Table[int]) { a.Insert(mpp("10.1.2.0/24"), 9) },
+			wantPfx:  mpp("10.1.2.0/24"),
+			wantKind: DiffMissingRight,
+		},
+		{
+			name:     "missing left",
+			modify:   func(_, b *Table[int]) { b.Insert(mpp("9.0.0.0/8"), 9) },
+			wantPfx:  mpp("9.0.0.0/8"),
+			wantKind: DiffMissingLeft,
+		},
+		{
+			name:     "missing left at the end",
+			modify:   func(_, b *Table[int]) { b.Insert(mpp("2001:db9::/32"), 9) },
+			wantPfx:  mpp("2001:db9::/32"),
+			wantKind: DiffMissingLeft,
+		},
+		{
+			name:     "value differs",
+			modify:   func(a, _ *Table[int]) { a.Insert(mpp("192.168.0.0/16"), 9) },
+			wantPfx:  mpp("192.168.0.0/16"),
+			wantKind: DiffValue,
+		},
+		{
+			name: "first of many",
+			modify: func(a, b *Table[int]) {
+				a.Insert(mpp("2001:db8::/32"), 9)
+				b.Delete(mpp("192.168.0.0/16"))
+				a.Insert(mpp("10.0.0.0/8"), 9)
+			},
+			wantPfx:  mpp("10.0.0.0/8"),
+			wantKind: DiffValue,
+		},
+		{
+			name: "nil eq",
+			modify: func(a, _ *Table[int]) {
+				a.Insert(mpp("10.0.0.0/8"), 9)
+				a.Delete(mpp("2001:db8::/32"))
+			},
+			nilEq:    true,
+			wantPfx:  mpp("2001:db8::/32"),
+			wantKind: DiffMissingLeft,
+		},
+	}
+
+	for _, tt := range tests {
+		a, b := a.Clone(), b.Clone()
+		tt.modify(a, b)
+
+		cmp := eq
+		if tt.nilEq {
+			cmp = nil
+		}
+
+		pfx, kind, ok := a.FirstDifference(b, cmp)
+		if !ok || pfx != tt.wantPfx || kind != tt.wantKind {
+			t.Errorf("%s: FirstDifference, expected (%s, %s, true), got (%s, %s, %v)",
+				tt.name, tt.wantPfx, tt.wantKind, pfx, kind, ok)
+		}
+	}
+}
+
+func TestFirstDifferenceCompare(t *testing.T) {
+	t.Parallel()
+
+	eq := func(a, b int) bool { return a == b }
+
+	for range 100 {
+		pfxs := randomPrefixes(200)
+
+		a := new(Table[int])
+		b := new(Table[int])
+
+		gold := map[netip.Prefix]DiffKind{}
+		for i, item := range pfxs {
+			switch i % 7 {
+			case 0:
+				a.Insert(item.pfx, item.val)
+				gold[item.pfx] = DiffMissingRight
+			case 1:
+				b.Insert(item.pfx, item.val)
+				gold[item.pfx] = DiffMissingLeft
+			case 2:
+				a.Insert(item.pfx, item.val)
+				b.Insert(item.pfx, item.val+1)
+				gold[item.pfx] = DiffValue
+			default:
+				a.Insert(item.pfx, item.val)
+				b.Insert(item.pfx, item.val)
+			}
+		}
+
+		keys := make([]netip.Prefix, 0, len(gold))
+		for pfx := range gold {
+			keys = append(keys, pfx)
+		}
+		slices.SortFunc(keys, cmpPrefix)
+
+		// all differences in CIDR sort order
+		var got []netip.Prefix
+		a.diffSorted(b, eq, func(pfx netip.Prefix, kind DiffKind, _, _ int) bool {
+			if gold[pfx] != kind {
+				t.Fatalf("diffSorted, %s, expected %s, got %s", pfx, gold[pfx], kind)
+			}
+			got = append(got, pfx)
+			return true
+		})
+
+		if !slices.Equal(got, keys) {
+			t.Fatalf("diffSorted, expected %v, got %v", keys, got)
+		}
+
+		pfx, kind, ok := a.FirstDifference(b, eq)
+		if !ok || pfx != keys[0] || kind != gold[keys[0]] {
+			t.Fatalf("FirstDifference, expected (%s, %s, true), got (%s, %s, %v)",
+				keys[0], gold[keys[0]], pfx, kind, ok)
+		}
+	}
+}
+
+func TestSortedCursor(t *testing.T) {
+	t.Parallel()
+
+	if _, _, ok := newSortedCursor[int](nil).next(); ok {
+		t.Errorf("cursor on nil table, expected exhausted")
+	}
+	if _, _, ok := newSortedCursor(new(Table[int])).next(); ok {
+		t.Errorf("cursor on empty table, expected exhausted")
+	}
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	c := newSortedCursor(rt)
+	rt.AllSorted()(func(pfx netip.Prefix, val int) bool {
+		gotPfx, gotVal, ok := c.next()
+		if !ok || gotPfx != pfx || gotVal != val {
+			t.Fatalf("cursor, expected (%s, %d, true), got (%s, %d, %v)", pfx, val, gotPfx, gotVal, ok)
+		}
+		return true
+	})
+
+	if pfx, _, ok := c.next(); ok {
+		t.Errorf("cursor, expected exhausted, got %s", pfx)
+	}
+}
+
+func TestFirstDifferenceEarlyExit(t *testing.T) {
+	// no t.Parallel(), measures the allocated bytes
+
+	rt1, rt2 := new(Table[int]), new(Table[int])
+	for _, item := range randomPrefixes(50_000) {
+		rt1.Insert(item.pfx, item.val)
+		rt2.Insert(item.pfx, item.val)
+	}
+
+	// differ in the first prefix
+	rt1.Insert(mpp("0.0.0.0/0"), 1)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	if pfx, _, _ := rt1.FirstDifference(rt2, nil); pfx != mpp("0.0.0.0/0") {
+		t.Fatalf("FirstDifference, expected 0.0.0.0/0, got %s", pfx)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	// the cursors visit only the nodes on the path to the first difference,
+	// the entries of the tables are not collected
+	if bytes := after.TotalAlloc - before.TotalAlloc; bytes > 128<<10 {
+		t.Errorf("FirstDifference, expected early exit, got %d bytes allocated", bytes)
+	}
+}
+
+func TestSymmetricDifference(t *testing.T) {
+	t.Parallel()
+
+	a := new(Table[int])
+	b := new(Table[int])
+
+	if got := a.SymmetricDifference(b); got.Size() != 0 {
+		t.Errorf("empty tables, SymmetricDifference, expected empty table, got size %d", got.Size())
+	}
+
+	a.Insert(mpp("10.0.0.0/8"), 1)
+	a.Insert(mpp("10.1.0.0/16"), 2)
+	a.Insert(mpp("2001:db8::/32"), 3)
+
+	b.Insert(mpp("10.0.0.0/8"), 100) // in both, value not compared
+	b.Insert(mpp("10.2.0.0/16"), 200)
+	b.Insert(mpp("2001:db9::/32"), 300)
+
+	want := map[netip.Prefix]int{
+		mpp("10.1.0.0/16"):   2,
+		mpp("10.2.0.0/16"):   200,
+		mpp("2001:db8::/32"): 3,
+		mpp("2001:db9::/32"): 300,
+	}
+
+	for _, got := range []*Table[int]{a.SymmetricDifference(b), b.SymmetricDifference(a)} {
+		if got.Size() != len(want) {
+			t.Fatalf("SymmetricDifference, expected size %d, got %d", len(want), got.Size())
+		}
+		for pfx, val := range want {
+			if v, ok := got.Get(pfx); !ok || v != val {
+				t.Errorf("SymmetricDifference, Get(%s), expected (%d, true), got (%d, %v)", pfx, val, v, ok)
+			}
+		}
+	}
+
+	if got := a.SymmetricDifference(nil); got.String() != a.String() {
+		t.Errorf("SymmetricDifference(nil), expected copy of receiver")
+	}
+
+	if got := a.SymmetricDifference(a); got.Size() != 0 {
+		t.Errorf("SymmetricDifference with itself, expected empty table, got size %d", got.Size())
+	}
+
+	// compare with gold
+	for range 10 {
+		pa := randomPrefixes(1_000)
+		pb := randomPrefixes(1_000)
+
+		a, b := new(Table[int]), new(Table[int])
+		gold := map[netip.Prefix]int{}
+
+		for _, item := range pa {
+			a.Insert(item.pfx, item.val)
+			gold[item.pfx]++
+		}
+		for _, item := range pb {
+			b.Insert(item.pfx, item.val)
+			gold[item.pfx]++
+		}
+		// some common prefixes
+		for _, item := range pa[:100] {
+			if b.Insert(item.pfx, item.val); gold[item.pfx] == 1 {
+				gold[item.pfx]++
+			}
+		}
+
+		got := a.SymmetricDifference(b)
+
+		want := 0
+		for pfx, n := range gold {
+			if n != 1 {
+				continue
+			}
+			want++
+			if _, ok := got.Get(pfx); !ok {
+				t.Fatalf("SymmetricDifference, missing %s", pfx)
+			}
+		}
+
+		if got.Size() != want {
+			t.Fatalf("SymmetricDifference, expected size %d, got %d", want, got.Size())
+		}
+	}
+}
+
+func TestReconcileTo(t *testing.T) {
+	t.Parallel()
+
+	eq := func(a, b int) bool { return a == b }
+
+	live := new(Table[int])
+	live.Insert(mpp("10.0.0.0/8"), 1)
+	live.Insert(mpp("10.1.0.0/16"), 2)
+	live.Insert(mpp("2001:db8::/32"), 3)
+
+	target := new(Table[int])
+	target.Insert(mpp("10.0.0.0/8"), 1)     // unchanged
+	target.Insert(mpp("10.1.0.0/16"), 20)   // update
+	target.Insert(mpp("192.168.0.0/16"), 4) // insert
+	target.Insert(mpp("2001:db9::/32"), 5)  // insert, 2001:db8::/32 deleted
+
+	inserts, deletes, updates := live.ReconcileTo(target, eq)
+	if inserts != 2 || deletes != 1 || updates != 1 {
+		t.Errorf("ReconcileTo, got (%d, %d, %d), want (2, 1, 1)", inserts, deletes, updates)
+	}
+
+	if _, _, ok := live.FirstDifference(target, eq); ok {
+		t.Errorf("ReconcileTo, tables differ after reconciliation")
+	}
+
+	// nothing to do
+	if i, d, u := live.ReconcileTo(target, eq); i+d+u != 0 {
+		t.Errorf("ReconcileTo, equal tables, got (%d, %d, %d), want no operations", i, d, u)
+	}
+
+	// value-only difference, nil eq overwrites every common prefix
+	target.Insert(mpp("10.0.0.0/8"), 10)
+	if i, d, u := live.ReconcileTo(target, nil); i != 0 || d != 0 || u != target.Size() {
+		t.Errorf("ReconcileTo(nil eq), got (%d, %d, %d), want (0, 0, %d)", i, d, u, target.Size())
+	}
+	if val, _ := live.Get(mpp("10.0.0.0/8")); val != 10 {
+		t.Errorf("ReconcileTo(nil eq), got value %d, want 10", val)
+	}
+
+	// nil target
+	if i, d, u := live.ReconcileTo(nil, eq); i != 0 || d != target.Size() || u != 0 || live.Size() != 0 {
+		t.Errorf("ReconcileTo(nil), got (%d, %d, %d) and size %d, want (0, %d, 0) and size 0", i, d, u, live.Size(), target.Size())
+	}
+
+	// compare with the diff size
+	for range 10 {
+		live, target := new(Table[int]), new(Table[int])
+
+		pfxs := randomPrefixes(1_000)
+		for _, item := range pfxs[:700] {
+			live.Insert(item.pfx, item.val)
+		}
+		for _, item := range pfxs[300:] {
+			target.Insert(item.pfx, item.val%2)
+		}
+
+		var wantI, wantD, wantU int
+		live.diffSorted(target, eq, func(_ netip.Prefix, kind DiffKind, _, _ int) bool {
+			switch kind {
+			case DiffMissingLeft:
+				wantI++
+			case DiffMissingRight:
+				wantD++
+			case DiffValue:
+				wantU++
+			}
+			return true
+		})
+
+		i, d, u := live.ReconcileTo(target, eq)
+		if i != wantI || d != wantD || u != wantU {
+			t.Fatalf("ReconcileTo, got (%d, %d, %d), want (%d, %d, %d)", i, d, u, wantI, wantD, wantU)
+		}
+
+		if pfx, kind, ok := live.FirstDifference(target, eq); ok {
+			t.Fatalf("ReconcileTo, tables differ at %s, %s", pfx, kind)
+		}
+	}
+}
+func TestFprintDiff(t *testing.T) {
+	t.Parallel()
+
+	a := new(Table[int])
+	a.Insert(mpp("10.0.0.0/8"), 1)
+	a.Insert(mpp("192.168.0.0/16"), 3)
+	a.Insert(mpp("2001:db8::/32"), 5)
+
+	b := new(Table[int])
+	b.Insert(mpp("10.1.0.0/16"), 2)
+	b.Insert(mpp("192.168.0.0/16"), 4)
+	b.Insert(mpp("2001:db8::/32"), 5)
+	b.Insert(mpp("::/0"), 6)
+
+	want := `- 10.0.0.0/8 (1)
++ 10.1.0.0/16 (2)
+~ 192.168.0.0/16 (3 -> 4)
++ ::/0 (6)
+`
+
+	w := new(strings.Builder)
+	if err := a.FprintDiff(w, b, nil); err != nil {
+		t.Fatalf("FprintDiff, unexpected error: %v", err)
+	}
+	if got := w.String(); got != want {
+		t.Errorf("FprintDiff\ngot:\n%swant:\n%s", got, want)
+	}
+
+	// custom formatter, equal formatted values are equal
+	w.Reset()
+	parity := func(v int) string {
+		if v%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+	if err := a.FprintDiff(w, b, parity); err != nil {
+		t.Fatalf("FprintDiff, unexpected error: %v", err)
+	}
+
+	want = `- 10.0.0.0/8 (odd)
++ 10.1.0.0/16 (even)
+~ 192.168.0.0/16 (odd -> even)
++ ::/0 (even)
+`
+	if got := w.String(); got != want {
+		t.Errorf("FprintDiff with formatter\ngot:\n%swant:\n%s", got, want)
+	}
+
+	// identical tables produce empty output
+	w.Reset()
+	if err := a.FprintDiff(w, a.Clone(), nil); err != nil || w.Len() != 0 {
+		t.Errorf("FprintDiff, identical tables, got %q, %v", w.String(), err)
+	}
+
+	// writer errors are returned
+	if err := a.FprintDiff(errWriter{}, b, nil); err == nil {
+		t.Errorf("FprintDiff, expected writer error")
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, io.ErrShortWrite }
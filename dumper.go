@@ -277,3 +277,35 @@ func (n *node[V]) nodeStatsRec() stats {
 
 	return s
 }
+
+// depthStatsRec, calculate the max depth of the nodes under n, the sum of the
+// depths of all prefixes and the number of prefixes, rec-descent.
+// The depth of n is the number of node levels from the root, including n.
+func (n *node[V]) depthStatsRec(depth int) (maxDepth, depthSum, pfxs int) {
+	if n == nil || n.isEmpty() {
+		return
+	}
+
+	maxDepth = depth
+	pfxs = n.prefixes.Len()
+	depthSum = pfxs * depth
+
+	for _, c := range n.children.Items {
+		switch k := c.(type) {
+		case *node[V]:
+			// rec-descent
+			md, ds, np := k.depthStatsRec(depth + 1)
+
+			maxDepth = max(maxDepth, md)
+			depthSum += ds
+			pfxs += np
+
+		case *leaf[V]:
+			// path compressed, stored in this node
+			depthSum += depth
+			pfxs++
+		}
+	}
+
+	return
+}
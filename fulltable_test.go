@@ -98,6 +98,38 @@ func BenchmarkFullMatchV4(b *testing.B) {
 	})
 }
 
+func BenchmarkFullContainsBytes(b *testing.B) {
+	var rt Table[int]
+
+	for i, route := range routes {
+		rt.Insert(route.CIDR, i)
+	}
+
+	for _, ip := range []netip.Addr{randomIP4(), randomIP6()} {
+		wire := ip.AsSlice()
+
+		name := "V4"
+		if ip.Is6() {
+			name = "V6"
+		}
+
+		b.Run(name+"/AddrFromSlice", func(b *testing.B) {
+			b.ResetTimer()
+			for range b.N {
+				a, _ := netip.AddrFromSlice(wire)
+				okSink = rt.Contains(a)
+			}
+		})
+
+		b.Run(name+"/ContainsBytes", func(b *testing.B) {
+			b.ResetTimer()
+			for range b.N {
+				okSink = rt.ContainsBytes(wire)
+			}
+		})
+	}
+}
+
 func BenchmarkFullMatchV6(b *testing.B) {
 	var rt Table[int]
 
@@ -0,0 +1,176 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrGraftFamily is returned by [Table.Graft] if an entry of the
+// grafted table differs in address family from the graft prefix.
+var ErrGraftFamily = errors.New("bart: graft entry differs in address family")
+
+// ErrGraftOverflow is returned by [Table.Graft] if a re-rooted entry
+// would exceed the address size.
+var ErrGraftOverflow = errors.New("bart: graft entry exceeds address size")
+
+// Graft inserts all entries of sub re-rooted under the prefix under, the bits
+// of under are prepended to the bits of each entry, e.g. the entry 1.2.0.0/16
+// grafted under 10.0.0.0/8 becomes 10.1.2.0/24. Multi-tenant systems compose
+// the per-tenant tables into a global table under each tenant allocation.
+//
+// All entries are checked before any insert, if an entry of sub differs in
+// address family or would exceed the address size, an error is returned and
+// the receiver is not changed. Existing entries of the receiver are
+// overwritten by colliding grafted entries. The table may be grafted
+// into itself, sub is read completely before the receiver is changed.
+//
+// The payload of type V is shallow copied, but if type V implements
+// the [Cloner] interface, the values are cloned.
+func (t *Table[V]) Graft(under netip.Prefix, sub *Table[V]) error {
+	if !under.IsValid() {
+		return fmt.Errorf("%w: invalid prefix %s", ErrGraftFamily, under)
+	}
+
+	// canonicalize the prefix
+	under = under.Masked()
+
+	if sub == nil {
+		return nil
+	}
+
+	is4 := under.Addr().Is4()
+	maxBits := under.Addr().BitLen()
+
+	// check and re-root all entries first, no partial graft, and
+	// no insert while iterating sub, sub may be the receiver itself
+	type entry struct {
+		pfx netip.Prefix
+		val V
+	}
+
+	var entries []entry
+	var err error
+	sub.AllSorted()(func(pfx netip.Prefix, val V) bool {
+		switch {
+		case pfx.Addr().Is4() != is4:
+			err = fmt.Errorf("%w: %s under %s", ErrGraftFamily, pfx, under)
+		case under.Bits()+pfx.Bits() > maxBits:
+			err = fmt.Errorf("%w: %s under %s", ErrGraftOverflow, pfx, under)
+		default:
+			entries = append(entries, entry{graftPrefix(under, pfx), val})
+		}
+		return err == nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		t.Insert(e.pfx, cloneOrCopyValue(e.val))
+	}
+
+	return nil
+}
+
+// Extract returns a new table with the entries covered by the prefix under,
+// re-rooted relative to under, the inverse of [Table.Graft]. The bits of under
+// are stripped from each entry, e.g. the entry 10.1.2.0/24 extracted under
+// 10.0.0.0/8 becomes 1.2.0.0/16 and an entry equal to under becomes the
+// default route. The address family is unchanged. The receiver is not changed.
+//
+// Tenants exporting their slice of a global table get it relative to their
+// allocation, t.Graft(under, t.Extract(under)) is a no-op.
+//
+// The payload of type V is shallow copied, but if type V implements
+// the [Cloner] interface, the values are cloned.
+func (t *Table[V]) Extract(under netip.Prefix) *Table[V] {
+	x := new(Table[V])
+
+	if !under.IsValid() {
+		return x
+	}
+
+	// canonicalize the prefix
+	under = under.Masked()
+
+	t.Subnets(under)(func(pfx netip.Prefix, val V) bool {
+		x.Insert(extractPrefix(under, pfx), cloneOrCopyValue(val))
+		return true
+	})
+
+	return x
+}
+
+// graftPrefix returns pfx re-rooted under the prefix under,
+// both prefixes must be canonical, of the same address family
+// and the sum of their lengths must fit into the address size.
+func graftPrefix(under, pfx netip.Prefix) netip.Prefix {
+	// the IPv4 address is in the last 4 bytes
+	offset := 0
+	if under.Addr().Is4() {
+		offset = 96
+	}
+
+	a16 := under.Addr().As16()
+	p16 := pfx.Addr().As16()
+
+	for i := range pfx.Bits() {
+		if bitAt(p16, offset+i) {
+			setBitAt(&a16, offset+under.Bits()+i)
+		}
+	}
+
+	ip := netip.AddrFrom16(a16)
+	if offset != 0 {
+		ip = ip.Unmap()
+	}
+
+	return netip.PrefixFrom(ip, under.Bits()+pfx.Bits())
+}
+
+// extractPrefix returns pfx relative to the prefix under, the inverse
+// of graftPrefix, pfx must be canonical and covered by under.
+func extractPrefix(under, pfx netip.Prefix) netip.Prefix {
+	// the IPv4 address is in the last 4 bytes
+	offset := 0
+	if under.Addr().Is4() {
+		offset = 96
+	}
+
+	var a16 [16]byte
+	if offset != 0 {
+		a16 = netip.IPv4Unspecified().As16()
+	}
+
+	p16 := pfx.Addr().As16()
+
+	bits := pfx.Bits() - under.Bits()
+	for i := range bits {
+		if bitAt(p16, offset+under.Bits()+i) {
+			setBitAt(&a16, offset+i)
+		}
+	}
+
+	ip := netip.AddrFrom16(a16)
+	if offset != 0 {
+		ip = ip.Unmap()
+	}
+
+	return netip.PrefixFrom(ip, bits)
+}
+
+// bitAt reports whether the bit at position i, counted from the
+// most significant bit, is set.
+func bitAt(a16 [16]byte, i int) bool {
+	return a16[i/8]&(0x80>>(i%8)) != 0
+}
+
+// setBitAt sets the bit at position i, counted from the most significant bit.
+func setBitAt(a16 *[16]byte, i int) {
+	a16[i/8] |= 0x80 >> (i % 8)
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestGraft(t *testing.T) {
+	t.Parallel()
+
+	tenant := new(Table[int])
+	tenant.Insert(mpp("0.0.0.0/0"), 1)
+	tenant.Insert(mpp("1.2.0.0/16"), 2)
+	tenant.Insert(mpp("255.255.255.0/24"), 3)
+
+	global := new(Table[int])
+	global.Insert(mpp("192.168.0.0/16"), 9)
+
+	if err := global.Graft(netip.MustParsePrefix("10.1.2.3/8"), tenant); err != nil {
+		t.Fatalf("Graft, unexpected error: %v", err)
+	}
+
+	want := map[netip.Prefix]int{
+		mpp("10.0.0.0/8"):        1,
+		mpp("10.1.2.0/24"):       2,
+		mpp("10.255.255.255/32"): 3,
+		mpp("192.168.0.0/16"):    9,
+	}
+
+	got := map[netip.Prefix]int{}
+	global.All()(func(pfx netip.Prefix, val int) bool {
+		got[pfx] = val
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Graft\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// IPv6, under a bit boundary inside an octet
+	tenant6 := new(Table[int])
+	tenant6.Insert(mpp("8000::/1"), 4)
+	tenant6.Insert(mpp("ffff::/16"), 5)
+
+	global6 := new(Table[int])
+	if err := global6.Graft(mpp("2001:db8::/33"), tenant6); err != nil {
+		t.Fatalf("Graft IPv6, unexpected error: %v", err)
+	}
+
+	for pfx, val := range map[netip.Prefix]int{
+		mpp("2001:db8:4000::/34"):      4,
+		mpp("2001:db8:7fff:8000::/49"): 5,
+	} {
+		if got, ok := global6.Get(pfx); !ok || got != val {
+			t.Errorf("Graft IPv6, Get(%s) = (%d, %v), want (%d, true)", pfx, got, ok, val)
+		}
+	}
+	if global6.Size() != 2 {
+		t.Errorf("Graft IPv6, Size = %d, want 2", global6.Size())
+	}
+
+	// nil sub is a no-op
+	if err := global.Graft(mpp("10.0.0.0/8"), nil); err != nil {
+		t.Errorf("Graft nil table, unexpected error: %v", err)
+	}
+}
+
+func TestGraftSelf(t *testing.T) {
+	t.Parallel()
+
+	tbl := new(Table[int])
+	tbl.Insert(mpp("0.0.0.0/0"), 1)
+	tbl.Insert(mpp("10.0.0.0/8"), 2)
+
+	if err := tbl.Graft(mpp("10.0.0.0/8"), tbl); err != nil {
+		t.Fatalf("Graft self, unexpected error: %v", err)
+	}
+
+	want := map[netip.Prefix]int{
+		mpp("0.0.0.0/0"):    1,
+		mpp("10.0.0.0/8"):   1,
+		mpp("10.10.0.0/16"): 2,
+	}
+
+	got := map[netip.Prefix]int{}
+	tbl.All()(func(pfx netip.Prefix, val int) bool {
+		got[pfx] = val
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Graft self\ngot:  %v\nwant: %v", got, want)
+	}
+}
+
+func TestGraftErrors(t *testing.T) {
+	t.Parallel()
+
+	global := new(Table[int])
+	global.Insert(mpp("10.0.0.0/8"), 1)
+
+	mixed := new(Table[int])
+	mixed.Insert(mpp("1.0.0.0/8"), 2)
+	mixed.Insert(mpp("2001:db8::/32"), 3)
+
+	if err := global.Graft(mpp("10.0.0.0/8"), mixed); !errors.Is(err, ErrGraftFamily) {
+		t.Errorf("Graft mixed families, expected ErrGraftFamily, got %v", err)
+	}
+
+	long := new(Table[int])
+	long.Insert(mpp("1.0.0.0/8"), 2)
+	long.Insert(mpp("1.2.3.0/25"), 3)
+
+	if err := global.Graft(mpp("10.0.0.0/8"), long); !errors.Is(err, ErrGraftOverflow) {
+		t.Errorf("Graft too long, expected ErrGraftOverflow, got %v", err)
+	}
+
+	if err := global.Graft(netip.Prefix{}, long); !errors.Is(err, ErrGraftFamily) {
+		t.Errorf("Graft invalid prefix, expected ErrGraftFamily, got %v", err)
+	}
+
+	// no partial graft
+	if global.Size() != 1 {
+		t.Errorf("Graft with error, receiver changed, Size = %d, want 1", global.Size())
+	}
+}
+
+func TestExtract(t *testing.T) {
+	t.Parallel()
+
+	global := new(Table[int])
+	global.Insert(mpp("0.0.0.0/0"), 0)
+	global.Insert(mpp("10.0.0.0/8"), 1)
+	global.Insert(mpp("10.1.2.0/24"), 2)
+	global.Insert(mpp("10.255.255.255/32"), 3)
+	global.Insert(mpp("11.0.0.0/8"), 4)
+	global.Insert(mpp("2001:db8::/32"), 5)
+	global.Insert(mpp("2001:db8:4000::/34"), 6)
+	global.Insert(mpp("2001:db8:7fff:8000::/49"), 7)
+
+	tests := []struct {
+		under netip.Prefix
+		want  map[netip.Prefix]int
+	}{
+		{
+			under: netip.MustParsePrefix("10.1.2.3/8"),
+			want: map[netip.Prefix]int{
+				mpp("0.0.0.0/0"):        1,
+				mpp("1.2.0.0/16"):       2,
+				mpp("255.255.255.0/24"): 3,
+			},
+		},
+		{
+			under: mpp("2001:db8::/33"),
+			want: map[netip.Prefix]int{
+				mpp("8000::/1"):  6,
+				mpp("ffff::/16"): 7,
+			},
+		},
+		{
+			under: mpp("12.0.0.0/8"),
+			want:  map[netip.Prefix]int{},
+		},
+		{
+			under: netip.Prefix{},
+			want:  map[netip.Prefix]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		got := map[netip.Prefix]int{}
+		global.Extract(tt.under).All()(func(pfx netip.Prefix, val int) bool {
+			got[pfx] = val
+			return true
+		})
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Extract(%s)\ngot:  %v\nwant: %v", tt.under, got, tt.want)
+		}
+	}
+
+	if global.Size() != 8 {
+		t.Errorf("Extract, receiver changed, Size = %d, want 8", global.Size())
+	}
+}
+
+func TestExtractGraftRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	global := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		global.Insert(item.pfx, item.val)
+	}
+
+	for _, item := range randomPrefixes(100) {
+		under := item.pfx
+
+		sub := global.Extract(under)
+
+		grafted := new(Table[int])
+		if err := grafted.Graft(under, sub); err != nil {
+			t.Fatalf("Graft(%s, Extract(%s)), unexpected error: %v", under, under, err)
+		}
+
+		want := new(Table[int])
+		global.Subnets(under)(func(pfx netip.Prefix, val int) bool {
+			want.Insert(pfx, val)
+			return true
+		})
+
+		if grafted.dumpString() != want.dumpString() {
+			t.Fatalf("Graft(%s, Extract(%s)) differs from Subnets(%s)", under, under, under)
+		}
+	}
+}
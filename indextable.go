@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// IndexTable is a routing table with 4-byte payloads, indexes into
+// an external slab of values of type S.
+//
+// This is the cache friendly pattern for large value structs: the trie
+// nodes stay small and dense, the hot path [IndexTable.LookupIndex] never
+// touches the values. The slab is only accessed for the final match.
+//
+// The zero value is ready to use. An IndexTable must not be copied by value.
+type IndexTable[S any] struct {
+	tbl  Table[uint32]
+	slab []S
+}
+
+// SetSlab sets the slab of values, the stored indexes refer to.
+// The slab is not copied, it is owned by the caller.
+func (t *IndexTable[S]) SetSlab(slab []S) {
+	t.slab = slab
+}
+
+// Insert adds pfx to the table with the slab index idx.
+// The index is not checked against the slab.
+func (t *IndexTable[S]) Insert(pfx netip.Prefix, idx uint32) {
+	t.tbl.Insert(pfx, idx)
+}
+
+// Delete removes pfx from the table.
+func (t *IndexTable[S]) Delete(pfx netip.Prefix) {
+	t.tbl.Delete(pfx)
+}
+
+// LookupIndex does a route lookup (longest prefix match) for IP and
+// returns the associated slab index and true, or false if no route matched.
+func (t *IndexTable[S]) LookupIndex(ip netip.Addr) (idx uint32, ok bool) {
+	return t.tbl.Lookup(ip)
+}
+
+// Lookup does a route lookup (longest prefix match) for IP and returns
+// a pointer to the slab value and true, or false if no route matched
+// or the index is out of range of the slab.
+func (t *IndexTable[S]) Lookup(ip netip.Addr) (val *S, ok bool) {
+	idx, ok := t.tbl.Lookup(ip)
+	if !ok || int(idx) >= len(t.slab) {
+		return nil, false
+	}
+
+	return &t.slab[idx], true
+}
+
+// Size returns the prefix count.
+func (t *IndexTable[S]) Size() int {
+	return t.tbl.Size()
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"testing"
+)
+
+type bigStruct struct {
+	name  string
+	attrs [32]int64
+}
+
+func TestIndexTable(t *testing.T) {
+	t.Parallel()
+
+	var it IndexTable[bigStruct]
+
+	if _, ok := it.Lookup(mpa("10.1.2.3")); ok {
+		t.Errorf("empty table, Lookup, expected false")
+	}
+
+	it.Insert(mpp("10.0.0.0/8"), 0)
+	it.Insert(mpp("10.1.0.0/16"), 1)
+	it.Insert(mpp("2001:db8::/32"), 7) // out of slab range
+
+	if idx, ok := it.LookupIndex(mpa("10.1.2.3")); !ok || idx != 1 {
+		t.Errorf("LookupIndex, expected (1, true), got (%d, %v)", idx, ok)
+	}
+
+	// no slab yet
+	if _, ok := it.Lookup(mpa("10.1.2.3")); ok {
+		t.Errorf("Lookup without slab, expected false")
+	}
+
+	slab := []bigStruct{{name: "aggregate"}, {name: "customer"}}
+	it.SetSlab(slab)
+
+	if val, ok := it.Lookup(mpa("10.1.2.3")); !ok || val.name != "customer" {
+		t.Errorf("Lookup, expected customer, got (%v, %v)", val, ok)
+	}
+	if val, ok := it.Lookup(mpa("10.2.0.1")); !ok || val.name != "aggregate" {
+		t.Errorf("Lookup, expected aggregate, got (%v, %v)", val, ok)
+	}
+
+	// the slab is shared, not copied
+	slab[1].name = "changed"
+	if val, _ := it.Lookup(mpa("10.1.2.3")); val.name != "changed" {
+		t.Errorf("Lookup, expected slab shared, got %s", val.name)
+	}
+
+	if _, ok := it.Lookup(mpa("2001:db8::1")); ok {
+		t.Errorf("Lookup, index out of slab range, expected false")
+	}
+
+	it.Delete(mpp("10.1.0.0/16"))
+	if it.Size() != 2 {
+		t.Errorf("Delete, expected size 2, got %d", it.Size())
+	}
+}
+
+func BenchmarkIndexTable(b *testing.B) {
+	var it IndexTable[bigStruct]
+	var rt Table[bigStruct]
+
+	slab := make([]bigStruct, len(routes))
+	for i, route := range routes {
+		slab[i].attrs[0] = int64(i)
+
+		it.Insert(route.CIDR, uint32(i))
+		rt.Insert(route.CIDR, slab[i])
+	}
+	it.SetSlab(slab)
+
+	probes := make([]netip.Addr, 1<<16)
+	for i := range probes {
+		probes[i] = randomAddr()
+	}
+
+	b.Run("Table[bigStruct]/Lookup", func(b *testing.B) {
+		for i := range b.N {
+			val, ok := rt.Lookup(probes[i&(len(probes)-1)])
+			intSink, okSink = int(val.attrs[0]), ok
+		}
+	})
+
+	b.Run("IndexTable/LookupIndex", func(b *testing.B) {
+		for i := range b.N {
+			idx, ok := it.LookupIndex(probes[i&(len(probes)-1)])
+			intSink, okSink = int(idx), ok
+		}
+	})
+
+	b.Run("IndexTable/Lookup", func(b *testing.B) {
+		for i := range b.N {
+			val, ok := it.Lookup(probes[i&(len(probes)-1)])
+			if ok {
+				intSink = int(val.attrs[0])
+			}
+			okSink = ok
+		}
+	})
+}
@@ -57,6 +57,30 @@ func (t *Table[V]) DumpList6() []DumpListNode[V] {
 	return t.root6.dumpListRec(0, zeroPath, 0, false)
 }
 
+// FromDumpList rebuilds a table from the structured export of
+// [Table.DumpList4] and [Table.DumpList6], e.g. after a tool has manipulated
+// the dump lists. All CIDRs of the lists and their subnets are inserted
+// recursively, invalid CIDRs are skipped.
+//
+// The trie structure depends only on the set of prefixes, the rebuilt table
+// is structurally equal to the original table.
+func FromDumpList[V any](v4, v6 []DumpListNode[V]) *Table[V] {
+	t := new(Table[V])
+
+	t.insertDumpListRec(v4)
+	t.insertDumpListRec(v6)
+
+	return t
+}
+
+// insertDumpListRec, inserts the dump list nodes and their subnets, rec-descent.
+func (t *Table[V]) insertDumpListRec(nodes []DumpListNode[V]) {
+	for _, n := range nodes {
+		t.Insert(n.CIDR, n.Value)
+		t.insertDumpListRec(n.Subnets)
+	}
+}
+
 func (n *node[V]) dumpListRec(parentIdx uint, path [16]byte, depth int, is4 bool) []DumpListNode[V] {
 	// recursion stop condition
 	if n == nil {
@@ -229,6 +229,53 @@ func TestJSONSample(t *testing.T) {
 	checkJSON(t, tbl, tt)
 }
 
+func TestFromDumpList(t *testing.T) {
+	t.Parallel()
+
+	if got := FromDumpList[int](nil, nil); got.Size() != 0 {
+		t.Errorf("FromDumpList(nil, nil), expected empty table, got size %d", got.Size())
+	}
+
+	tbl := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		tbl.Insert(item.pfx, item.val)
+	}
+
+	// the trie may have been modified by deletes
+	for _, item := range randomPrefixes(1_000) {
+		tbl.Delete(item.pfx)
+	}
+
+	got := FromDumpList(tbl.DumpList4(), tbl.DumpList6())
+
+	if got.Size4() != tbl.Size4() || got.Size6() != tbl.Size6() {
+		t.Fatalf("FromDumpList, sizes differ, got (%d, %d), want (%d, %d)",
+			got.Size4(), got.Size6(), tbl.Size4(), tbl.Size6())
+	}
+
+	if got.dumpString() != tbl.dumpString() {
+		t.Errorf("FromDumpList, dumpString differs")
+	}
+
+	// manipulated dump list, add a subnet and an invalid CIDR
+	v4 := tbl.DumpList4()
+	v4 = append(v4, DumpListNode[int]{
+		CIDR:  netip.Prefix{},
+		Value: 1,
+		Subnets: []DumpListNode[int]{
+			{CIDR: mpp("198.51.100.0/24"), Value: 42},
+		},
+	})
+
+	got = FromDumpList(v4, nil)
+	if got.Size4() != tbl.Size4()+1 || got.Size6() != 0 {
+		t.Errorf("FromDumpList, manipulated, got sizes (%d, %d), want (%d, 0)", got.Size4(), got.Size6(), tbl.Size4()+1)
+	}
+	if val, _ := got.Get(mpp("198.51.100.0/24")); val != 42 {
+		t.Errorf("FromDumpList, manipulated, got %d, want 42", val)
+	}
+}
+
 func checkJSON(t *testing.T, tbl *Table[any], tt jsonTest) {
 	t.Helper()
 	for _, node := range tt.nodes {
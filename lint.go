@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// LintKind is the kind of a [LintFinding].
+type LintKind int
+
+const (
+	// LintRedundant, the prefix is shadowed by its closest covering
+	// prefix with an equal value, it can be deleted.
+	LintRedundant LintKind = iota + 1
+
+	// LintAggregatable, the two adjacent sibling prefixes have equal
+	// values and can be merged into their parent, see [Table.AggregateSiblings].
+	LintAggregatable
+)
+
+// String implements the [fmt.Stringer] interface.
+func (k LintKind) String() string {
+	switch k {
+	case LintRedundant:
+		return "redundant"
+	case LintAggregatable:
+		return "aggregatable"
+	default:
+		return "unknown"
+	}
+}
+
+// LintFinding is a machine-readable finding of [Lint] and [Table.LintFunc].
+//
+// For [LintRedundant] the prefixes are the redundant prefix and its
+// covering prefix, for [LintAggregatable] the lower and the upper sibling.
+type LintFinding struct {
+	Kind     LintKind
+	Prefixes []netip.Prefix
+}
+
+// Lint returns an iterator over the findings of a config linter for ACLs
+// or RIBs, redundant prefixes shadowed by a covering prefix with an equal
+// value and adjacent siblings with equal values that could be aggregated.
+// The findings are reported in natural CIDR sort order of the first prefix.
+//
+// Lint is like [Table.LintFunc] with ==.
+func Lint[V comparable](t *Table[V]) func(yield func(LintFinding) bool) {
+	return t.LintFunc(func(a, b V) bool { return a == b })
+}
+
+// LintFunc is like [Lint], but the values are compared with eq.
+func (t *Table[V]) LintFunc(eq func(V, V) bool) func(yield func(LintFinding) bool) {
+	return func(yield func(LintFinding) bool) {
+		type ancestor struct {
+			pfx netip.Prefix
+			val V
+		}
+
+		// stack of the covering prefixes, CIDR sort order visits supernets first
+		var stack []ancestor
+
+		t.AllSorted()(func(pfx netip.Prefix, val V) bool {
+			for len(stack) > 0 && !stack[len(stack)-1].pfx.Overlaps(pfx) {
+				stack = stack[:len(stack)-1]
+			}
+
+			if len(stack) > 0 {
+				if parent := stack[len(stack)-1]; eq(parent.val, val) {
+					if !yield(LintFinding{LintRedundant, []netip.Prefix{pfx, parent.pfx}}) {
+						return false
+					}
+				}
+			}
+
+			stack = append(stack, ancestor{pfx, val})
+
+			if pfx.Bits() == 0 {
+				return true
+			}
+
+			// only the lower half starts the pair
+			parent, _ := pfx.Addr().Prefix(pfx.Bits() - 1)
+			if parent.Addr() != pfx.Addr() {
+				return true
+			}
+
+			sibling, _ := lastAddr(parent).Prefix(pfx.Bits())
+			if sval, ok := t.Get(sibling); ok && eq(val, sval) {
+				return yield(LintFinding{LintAggregatable, []netip.Prefix{pfx, sibling}})
+			}
+
+			return true
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[string])
+
+	Lint(rt)(func(f LintFinding) bool {
+		t.Errorf("Lint, empty table, unexpected finding %v", f)
+		return true
+	})
+
+	rt.Insert(mpp("10.0.0.0/8"), "a")
+	rt.Insert(mpp("10.0.0.1/32"), "a")   // redundant
+	rt.Insert(mpp("10.1.0.0/16"), "b")   // not redundant
+	rt.Insert(mpp("10.1.1.1/32"), "a")   // closest covering 10.1.0.0/16 differs
+	rt.Insert(mpp("10.2.0.0/25"), "c")   // aggregatable with 10.2.0.128/25
+	rt.Insert(mpp("10.2.0.128/25"), "c") // upper half, reported with the lower half
+	rt.Insert(mpp("10.3.0.0/25"), "a")   // redundant and aggregatable
+	rt.Insert(mpp("10.3.0.128/25"), "a") // redundant
+	rt.Insert(mpp("2001:db8::/32"), "a")
+
+	want := []LintFinding{
+		{LintRedundant, []netip.Prefix{mpp("10.0.0.1/32"), mpp("10.0.0.0/8")}},
+		{LintAggregatable, []netip.Prefix{mpp("10.2.0.0/25"), mpp("10.2.0.128/25")}},
+		{LintRedundant, []netip.Prefix{mpp("10.3.0.0/25"), mpp("10.0.0.0/8")}},
+		{LintAggregatable, []netip.Prefix{mpp("10.3.0.0/25"), mpp("10.3.0.128/25")}},
+		{LintRedundant, []netip.Prefix{mpp("10.3.0.128/25"), mpp("10.0.0.0/8")}},
+	}
+
+	var got []LintFinding
+	Lint(rt)(func(f LintFinding) bool {
+		got = append(got, f)
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// premature exit
+	count := 0
+	Lint(rt)(func(LintFinding) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("Lint with premature exit, expected 2 findings, got %d", count)
+	}
+
+	// LintFunc with a custom equality, all values equal
+	count = 0
+	rt.LintFunc(func(string, string) bool { return true })(func(f LintFinding) bool {
+		count++
+		return true
+	})
+	if count != 9 {
+		t.Errorf("LintFunc, expected 9 findings, got %d", count)
+	}
+}
+
+func TestLintRedundantDeletable(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val%3)
+	}
+
+	probes := make([]netip.Addr, 10_000)
+	for i := range probes {
+		probes[i] = randomAddr()
+	}
+
+	type result struct {
+		val int
+		ok  bool
+	}
+
+	want := make([]result, len(probes))
+	for i, ip := range probes {
+		want[i].val, want[i].ok = rt.Lookup(ip)
+	}
+
+	var redundant []netip.Prefix
+	Lint(rt)(func(f LintFinding) bool {
+		if f.Kind == LintRedundant {
+			redundant = append(redundant, f.Prefixes[0])
+		}
+		return true
+	})
+
+	if len(redundant) == 0 {
+		t.Fatalf("Lint, expected redundant findings")
+	}
+
+	// all redundant prefixes can be deleted together
+	for _, pfx := range redundant {
+		rt.Delete(pfx)
+	}
+
+	for i, ip := range probes {
+		if val, ok := rt.Lookup(ip); val != want[i].val || ok != want[i].ok {
+			t.Fatalf("Lookup(%s) after deleting redundant, got (%d, %v), want (%d, %v)", ip, val, ok, want[i].val, want[i].ok)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+//go:build bart_trace
+
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import "net/netip"
+
+// LookupTrace records the steps of a single [Table.LookupWithTrace].
+//
+// Only available with the build tag bart_trace, the hot path
+// of [Table.Lookup] is not instrumented.
+type LookupTrace struct {
+	// Nodes is the number of trie nodes visited on the way down.
+	Nodes int
+
+	// Strides is the number of address octets consumed on the way down.
+	Strides int
+
+	// Backtracks is the number of nodes tested for a longest-prefix-match
+	// while unwinding the stack.
+	Backtracks int
+
+	// PathCompressed reports whether the descent stopped at
+	// a path compressed leaf.
+	PathCompressed bool
+}
+
+// LookupWithTrace is like [Table.Lookup] but additionally returns
+// a trace of the descent and the backtracking, e.g. for performance
+// investigations without an external profiler.
+//
+// Only available with the build tag bart_trace.
+func (t *Table[V]) LookupWithTrace(ip netip.Addr) (val V, ok bool, trace LookupTrace) {
+	if !ip.IsValid() {
+		return val, false, trace
+	}
+
+	is4 := ip.Is4()
+	n := t.rootNodeByVersion(is4)
+
+	octets := ipAsOctets(ip, is4)
+
+	// stack of the traversed nodes for fast backtracking, if needed
+	stack := [maxTreeDepth]*node[V]{}
+
+	// run variable, used after for loop
+	var depth int
+	var octet byte
+
+LOOP:
+	// find leaf node
+	for depth, octet = range octets {
+		addr := uint(octet)
+
+		// push current node on stack for fast backtracking
+		stack[depth] = n
+		trace.Nodes++
+		trace.Strides++
+
+		// go down in tight loop to last octet
+		if !n.children.Test(addr) {
+			// no more nodes below octet
+			break LOOP
+		}
+
+		// get the child: node or leaf
+		switch k := n.children.MustGet(addr).(type) {
+		case *node[V]:
+			// descend down to next trie level
+			n = k
+			continue
+		case *leaf[V]:
+			// reached a path compressed prefix, stop traversing
+			trace.PathCompressed = true
+			if k.prefix.Contains(ip) {
+				return k.value, true, trace
+			}
+			break LOOP
+		}
+	}
+
+	// start backtracking, unwind the stack
+	for ; depth >= 0; depth-- {
+		n = stack[depth]
+		trace.Backtracks++
+
+		// longest prefix match, skip if node has no prefixes
+		if n.prefixes.Len() != 0 {
+			if _, val, ok := n.lpmGet(hostIndex(uint(octets[depth]))); ok {
+				return val, true, trace
+			}
+		}
+	}
+
+	return val, false, trace
+}
@@ -0,0 +1,59 @@
+//go:build bart_trace
+
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"testing"
+)
+
+func TestLookupWithTrace(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 8)
+	rt.Insert(mpp("10.1.0.0/16"), 16)
+	rt.Insert(mpp("10.1.2.0/24"), 24)
+	rt.Insert(mpp("192.168.1.0/24"), 1)
+
+	tests := []struct {
+		ip    string
+		val   int
+		ok    bool
+		trace LookupTrace
+	}{
+		{"10.1.2.3", 24, true, LookupTrace{Nodes: 2, Strides: 2, PathCompressed: true}},
+		{"10.1.3.3", 16, true, LookupTrace{Nodes: 2, Strides: 2, Backtracks: 1, PathCompressed: true}},
+		{"10.2.3.3", 8, true, LookupTrace{Nodes: 2, Strides: 2, Backtracks: 2}},
+		{"192.168.1.1", 1, true, LookupTrace{Nodes: 1, Strides: 1, PathCompressed: true}},
+		{"192.168.2.1", 0, false, LookupTrace{Nodes: 1, Strides: 1, Backtracks: 1, PathCompressed: true}},
+		{"11.0.0.1", 0, false, LookupTrace{Nodes: 1, Strides: 1, Backtracks: 1}},
+	}
+
+	for _, tt := range tests {
+		val, ok, trace := rt.LookupWithTrace(mpa(tt.ip))
+		if val != tt.val || ok != tt.ok || trace != tt.trace {
+			t.Errorf("LookupWithTrace(%s), expected (%v, %v, %+v), got (%v, %v, %+v)",
+				tt.ip, tt.val, tt.ok, tt.trace, val, ok, trace)
+		}
+	}
+
+	// compare with Lookup
+	pfxs := randomPrefixes(10_000)
+	for _, item := range pfxs {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	for range 10_000 {
+		ip := randomAddr()
+
+		wantVal, wantOK := rt.Lookup(ip)
+		gotVal, gotOK, _ := rt.LookupWithTrace(ip)
+
+		if wantVal != gotVal || wantOK != gotOK {
+			t.Fatalf("LookupWithTrace(%s), expected (%v, %v), got (%v, %v)", ip, wantVal, wantOK, gotVal, gotOK)
+		}
+	}
+}
@@ -4,9 +4,11 @@
 package bart
 
 import (
+	"math"
 	"net/netip"
 	"slices"
 
+	"github.com/gaissmai/bart/internal/bitset"
 	"github.com/gaissmai/bart/internal/sparse"
 )
 
@@ -50,6 +52,12 @@ func (n *node[V]) isEmpty() bool {
 	return n.prefixes.Len() == 0 && n.children.Len() == 0
 }
 
+// isDefaultOnly returns true if the node has no children and
+// just the default route as single prefix, only useful for root nodes.
+func (n *node[V]) isDefaultOnly() bool {
+	return n.prefixes.Len() == 1 && n.children.Len() == 0 && n.prefixes.Test(1)
+}
+
 // leaf is a prefix and value together, it's a path compressed child
 type leaf[V any] struct {
 	prefix netip.Prefix
@@ -141,38 +149,118 @@ func (n *node[V]) purgeAndCompress(parentStack []*node[V], childPath []byte, is4
 	// unwind the stack
 	for i := len(parentStack) - 1; i >= 0; i-- {
 		parent := parentStack[i]
-		addr := uint(childPath[i])
+		n.purgeOrCompress(parent, uint(childPath[i]), childPath, i+1, is4)
+		n = parent
+	}
+}
+
+// purgeOrCompress, purge the empty node n or replace it by a leaf, if n has
+// a single prefix or a single leaf. The node n is the child of parent at addr,
+// depth is the depth of n and path the octet path to n.
+//
+// Returns true if n was removed from the trie.
+func (n *node[V]) purgeOrCompress(parent *node[V], addr uint, path []byte, depth int, is4 bool) bool {
+	pfxCount := n.prefixes.Len()
+	childCount := n.children.Len()
+
+	switch {
+	case n.isEmpty():
+		// purge empty node
+		parent.children.DeleteAt(addr)
+		return true
+
+	case pfxCount == 1 && childCount == 0:
+		// make leaf from prefix idx, shift leaf one level up
+		// and override current node with new leaf
+		idx, _ := n.prefixes.FirstSet()
+		val := n.prefixes.Items[0]
+
+		path16 := [16]byte{}
+		copy(path16[:], path)
+		pfx := cidrFromPath(path16, depth, is4, idx)
+
+		parent.children.InsertAt(addr, &leaf[V]{pfx, val})
+		return true
+
+	case pfxCount == 0 && childCount == 1:
+		// if single child is a leaf, shift it up one level
+		// and override current node with this leaf
+		if leafPtr, ok := n.children.Items[0].(*leaf[V]); ok {
+			parent.children.InsertAt(addr, leafPtr)
+			return true
+		}
+	}
+
+	return false
+}
 
-		pfxCount := n.prefixes.Len()
-		childCount := n.children.Len()
+// isCompressible returns true if the node is empty or could be
+// replaced by a leaf, see purgeOrCompress.
+func (n *node[V]) isCompressible() bool {
+	pfxCount := n.prefixes.Len()
+	childCount := n.children.Len()
 
-		switch {
-		case n.isEmpty():
-			// purge empty node
-			parent.children.DeleteAt(addr)
+	switch {
+	case pfxCount == 0 && childCount == 0:
+		return true
+	case pfxCount == 1 && childCount == 0:
+		return true
+	case pfxCount == 0 && childCount == 1:
+		_, ok := n.children.Items[0].(*leaf[V])
+		return ok
+	}
 
-		case pfxCount == 1 && childCount == 0:
-			// make leaf from prefix idx, shift leaf one level up
-			// and override current node with new leaf
-			idx, _ := n.prefixes.FirstSet()
-			val := n.prefixes.Items[0]
+	return false
+}
 
-			path := [16]byte{}
-			copy(path[:], childPath)
-			pfx := cidrFromPath(path, i+1, is4, idx)
+// compactRec, purge and compress the nodes along the paths of the deleted prefixes,
+// bottom up in a single pass. The deleted prefixes must be in CIDR sort order,
+// only the nodes on their paths are visited.
+//
+// Returns the number of nodes removed from the trie.
+func (n *node[V]) compactRec(deleted []netip.Prefix, depth int, is4 bool) (freed int) {
+	// octet of deleted prefix at this depth, without allocations
+	octetAt := func(pfx netip.Prefix) byte {
+		a16 := pfx.Addr().As16()
+		if is4 {
+			return a16[12+depth]
+		}
+		return a16[depth]
+	}
 
-			parent.children.InsertAt(addr, &leaf[V]{pfx, val})
+	for i := 0; i < len(deleted); {
+		// prefix was deleted in this node
+		if lastIdx, _ := lastOctetIdxAndBits(deleted[i].Bits()); lastIdx == depth {
+			i++
+			continue
+		}
 
-		case pfxCount == 0 && childCount == 1:
-			// if single child is a leaf, shift it up one level
-			// and override current node with this leaf
-			if leafPtr, ok := n.children.Items[0].(*leaf[V]); ok {
-				parent.children.InsertAt(addr, leafPtr)
+		// all deleted prefixes below the same child are adjacent in sort order
+		addr := octetAt(deleted[i])
+		j := i + 1
+		for ; j < len(deleted); j++ {
+			if lastIdx, _ := lastOctetIdxAndBits(deleted[j].Bits()); lastIdx == depth {
+				break
+			}
+			if octetAt(deleted[j]) != addr {
+				break
 			}
 		}
 
-		n = parent
+		if c, ok := n.children.Get(uint(addr)); ok {
+			if kid, ok := c.(*node[V]); ok {
+				freed += kid.compactRec(deleted[i:j], depth+1, is4)
+
+				if kid.purgeOrCompress(n, uint(addr), ipAsOctets(deleted[i].Addr(), is4), depth+1, is4) {
+					freed++
+				}
+			}
+		}
+
+		i = j
 	}
+
+	return freed
 }
 
 // lpmGet does a route lookup for idx in the 8-bit (stride) routing table
@@ -197,6 +285,11 @@ func (n *node[V]) lpmTest(idx uint) bool {
 
 // cloneRec, clones the node recursive.
 func (n *node[V]) cloneRec() *node[V] {
+	return n.cloneRecFunc(cloneOrCopyValue[V])
+}
+
+// cloneRecFunc, clones the node recursive, the values are copied by fn.
+func (n *node[V]) cloneRecFunc(fn func(V) V) *node[V] {
 	if n == nil {
 		return nil
 	}
@@ -209,9 +302,9 @@ func (n *node[V]) cloneRec() *node[V] {
 	// shallow
 	c.prefixes = *(n.prefixes.Copy())
 
-	// deep copy if V implements Cloner[V]
+	// copy the values with fn
 	for i, v := range c.prefixes.Items {
-		c.prefixes.Items[i] = cloneOrCopyValue(v)
+		c.prefixes.Items[i] = fn(v)
 	}
 
 	// shallow
@@ -222,16 +315,64 @@ func (n *node[V]) cloneRec() *node[V] {
 		switch k := k.(type) {
 		case *node[V]:
 			// clone the child node rec-descent
-			c.children.Items[i] = k.cloneRec()
+			c.children.Items[i] = k.cloneRecFunc(fn)
 		case *leaf[V]:
-			// deep copy if V implements Cloner[V]
-			c.children.Items[i] = k.cloneLeaf()
+			// copy the value with fn
+			c.children.Items[i] = &leaf[V]{k.prefix, fn(k.value)}
 		}
 	}
 
 	return c
 }
 
+// cloneRecFuncErr is like cloneRecFunc, but fn may fail. The rec-descent
+// stops at the first error, no further nodes are visited and copied.
+func (n *node[V]) cloneRecFuncErr(fn func(V) (V, error)) (*node[V], error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	c := new(node[V])
+	if n.isEmpty() {
+		return c, nil
+	}
+
+	var err error
+
+	// shallow
+	c.prefixes = *(n.prefixes.Copy())
+
+	// copy the values with fn
+	for i, v := range c.prefixes.Items {
+		if c.prefixes.Items[i], err = fn(v); err != nil {
+			return nil, err
+		}
+	}
+
+	// shallow
+	c.children = *(n.children.Copy())
+
+	// deep copy of nodes and leaves
+	for i, k := range c.children.Items {
+		switch k := k.(type) {
+		case *node[V]:
+			// clone the child node rec-descent
+			if c.children.Items[i], err = k.cloneRecFuncErr(fn); err != nil {
+				return nil, err
+			}
+		case *leaf[V]:
+			// copy the value with fn
+			val, err := fn(k.value)
+			if err != nil {
+				return nil, err
+			}
+			c.children.Items[i] = &leaf[V]{k.prefix, val}
+		}
+	}
+
+	return c, nil
+}
+
 // allRec runs recursive the trie, starting at this node and
 // the yield function is called for each route entry with prefix and value.
 // If the yield function returns false the recursion ends prematurely and the
@@ -347,6 +488,289 @@ func (n *node[V]) allRecSorted(path [16]byte, depth int, is4 bool, yield func(ne
 	return true
 }
 
+// allRecSortedReverse runs recursive the trie like allRecSorted,
+// but the iteration is in reverse prefix sort order.
+//
+// If the yield function returns false the recursion ends prematurely and the
+// false value is propagated.
+func (n *node[V]) allRecSortedReverse(path [16]byte, depth int, is4 bool, yield func(netip.Prefix, V) bool) bool {
+	// get slice of all child octets, sorted by addr
+	allChildAddrs := n.children.AsSlice(make([]uint, 0, maxNodeChildren))
+
+	// get slice of all indexes, sorted by idx
+	allIndices := n.prefixes.AsSlice(make([]uint, 0, maxNodePrefixes))
+
+	// sort indices in CIDR sort order
+	slices.SortFunc(allIndices, cmpIndexRank)
+
+	// yield the node (rec-descent) or leaf at child index j
+	yieldChild := func(j int) bool {
+		switch k := n.children.Items[j].(type) {
+		case *node[V]:
+			path[depth] = byte(allChildAddrs[j])
+			return k.allRecSortedReverse(path, depth+1, is4, yield)
+		case *leaf[V]:
+			return yield(k.prefix, k.value)
+		}
+		return true
+	}
+
+	childCursor := len(allChildAddrs) - 1
+
+	// yield indices and childs in reverse CIDR sort order
+	for i := len(allIndices) - 1; i >= 0; i-- {
+		pfxIdx := allIndices[i]
+		pfxOctet, _ := idxToPfx(pfxIdx)
+
+		// yield all childs after idx
+		for ; childCursor >= 0 && allChildAddrs[childCursor] >= uint(pfxOctet); childCursor-- {
+			if !yieldChild(childCursor) {
+				return false
+			}
+		}
+
+		// yield the prefix for this idx
+		cidr := cidrFromPath(path, depth, is4, pfxIdx)
+		if !yield(cidr, n.prefixes.MustGet(pfxIdx)) {
+			return false
+		}
+	}
+
+	// yield the rest of leaves and nodes (rec-descent)
+	for ; childCursor >= 0; childCursor-- {
+		if !yieldChild(childCursor) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// minimalCoverRec finds the shortest covering prefix for the sorted pfxs
+// in the subtrie at n, all pfxs share the path to n. The covers and the
+// uncovered pfxs are appended, rec-descent for the pfxs sharing a child.
+func (n *node[V]) minimalCoverRec(pfxs []netip.Prefix, path [16]byte, depth int, is4 bool, covers, uncovered *[]netip.Prefix) {
+	// the pfxs descending to a child, consecutive per child addr
+	var descend []netip.Prefix
+
+	for _, pfx := range pfxs {
+		lastIdx, lastBits := lastOctetIdxAndBits(pfx.Bits())
+		octet := octetAt(pfx.Addr(), depth)
+
+		maxLen := strideLen
+		if depth == lastIdx {
+			maxLen = lastBits
+		}
+
+		// shortest covering prefix in this node, shorter than in any child
+		if idx, ok := n.shortestCover(octet, maxLen); ok {
+			*covers = append(*covers, cidrFromPath(path, depth, is4, idx))
+			continue
+		}
+
+		// pfx ends in this node, the children are more specific
+		if depth == lastIdx {
+			*uncovered = append(*uncovered, pfx)
+			continue
+		}
+
+		descend = append(descend, pfx)
+	}
+
+	for len(descend) > 0 {
+		addr := octetAt(descend[0].Addr(), depth)
+
+		// the run of pfxs with the same child addr
+		i := 1
+		for i < len(descend) && octetAt(descend[i].Addr(), depth) == addr {
+			i++
+		}
+		group := descend[:i]
+		descend = descend[i:]
+
+		if !n.children.Test(uint(addr)) {
+			*uncovered = append(*uncovered, group...)
+			continue
+		}
+
+		switch k := n.children.MustGet(uint(addr)).(type) {
+		case *node[V]:
+			path[depth] = addr
+			k.minimalCoverRec(group, path, depth+1, is4, covers, uncovered)
+		case *leaf[V]:
+			for _, pfx := range group {
+				if k.prefix.Bits() <= pfx.Bits() && k.prefix.Contains(pfx.Addr()) {
+					*covers = append(*covers, k.prefix)
+				} else {
+					*uncovered = append(*uncovered, pfx)
+				}
+			}
+		}
+	}
+}
+
+// shortestCover returns the index of the shortest prefix in n covering
+// octet with a prefix length up to maxLen and true, or false if none.
+func (n *node[V]) shortestCover(octet byte, maxLen int) (idx uint, ok bool) {
+	if n.prefixes.Len() == 0 {
+		return 0, false
+	}
+
+	for pfxLen := 0; pfxLen <= maxLen; pfxLen++ {
+		if idx = pfxToIdx(octet, pfxLen); n.prefixes.Test(idx) {
+			return idx, true
+		}
+	}
+
+	return 0, false
+}
+
+// pfxSortKey and childSortKey, the position of a prefix index or
+// child addr in a node in CIDR sort order, prefixes by octet and
+// length, a child after all prefixes with the same octet.
+func pfxSortKey(idx uint) int {
+	octet, pfxLen := idxToPfx(idx)
+	return int(octet)<<4 | pfxLen
+}
+
+func childSortKey(addr uint) int {
+	return int(addr)<<4 | (strideLen + 1)
+}
+
+// targetSortKey returns the sort key of pfx in the node at depth and true,
+// if pfx is stored as prefix at this depth, otherwise the key of the child.
+func targetSortKey(octets []byte, depth, lastIdx, lastBits int) (key int, here bool) {
+	if depth == lastIdx {
+		return pfxSortKey(pfxToIdx(octets[depth], lastBits)), true
+	}
+
+	return childSortKey(uint(octets[depth])), false
+}
+
+// predecessorRec returns the greatest prefix in the subtrie at n less than pfx
+// in CIDR sort order, descends along the path of pfx and backtracks.
+func (n *node[V]) predecessorRec(path [16]byte, depth int, is4 bool, pfx netip.Prefix, octets []byte) (netip.Prefix, bool) {
+	lastIdx, lastBits := lastOctetIdxAndBits(pfx.Bits())
+	key, here := targetSortKey(octets, depth, lastIdx, lastBits)
+
+	if !here {
+		addr := uint(octets[depth])
+		if c, ok := n.children.Get(addr); ok {
+			switch k := c.(type) {
+			case *node[V]:
+				path[depth] = byte(addr)
+				if prev, ok := k.predecessorRec(path, depth+1, is4, pfx, octets); ok {
+					return prev, true
+				}
+			case *leaf[V]:
+				if cmpPrefix(k.prefix, pfx) < 0 {
+					return k.prefix, true
+				}
+			}
+		}
+	}
+
+	return n.lastBefore(path, depth, is4, key)
+}
+
+// successorRec returns the smallest prefix in the subtrie at n greater than pfx
+// in CIDR sort order, descends along the path of pfx and backtracks.
+func (n *node[V]) successorRec(path [16]byte, depth int, is4 bool, pfx netip.Prefix, octets []byte) (netip.Prefix, bool) {
+	lastIdx, lastBits := lastOctetIdxAndBits(pfx.Bits())
+	key, here := targetSortKey(octets, depth, lastIdx, lastBits)
+
+	if !here {
+		addr := uint(octets[depth])
+		if c, ok := n.children.Get(addr); ok {
+			switch k := c.(type) {
+			case *node[V]:
+				path[depth] = byte(addr)
+				if next, ok := k.successorRec(path, depth+1, is4, pfx, octets); ok {
+					return next, true
+				}
+			case *leaf[V]:
+				if cmpPrefix(k.prefix, pfx) > 0 {
+					return k.prefix, true
+				}
+			}
+		}
+	}
+
+	return n.firstAfter(path, depth, is4, key)
+}
+
+// lastBefore returns the greatest prefix in the subtrie at n
+// with a sort key in this node less than key.
+func (n *node[V]) lastBefore(path [16]byte, depth int, is4 bool, key int) (netip.Prefix, bool) {
+	bestKey, bestIdx, bestAddr, isChild := -1, uint(0), uint(0), false
+
+	for _, idx := range n.prefixes.AsSlice(make([]uint, 0, maxNodePrefixes)) {
+		if k := pfxSortKey(idx); k < key && k > bestKey {
+			bestKey, bestIdx, isChild = k, idx, false
+		}
+	}
+
+	for _, addr := range n.children.AsSlice(make([]uint, 0, maxNodeChildren)) {
+		if k := childSortKey(addr); k < key && k > bestKey {
+			bestKey, bestAddr, isChild = k, addr, true
+		}
+	}
+
+	switch {
+	case bestKey < 0:
+		return netip.Prefix{}, false
+	case !isChild:
+		return cidrFromPath(path, depth, is4, bestIdx), true
+	}
+
+	// the last prefix in the subtrie of the child
+	switch k := n.children.MustGet(bestAddr).(type) {
+	case *node[V]:
+		path[depth] = byte(bestAddr)
+		return k.lastBefore(path, depth+1, is4, math.MaxInt)
+	case *leaf[V]:
+		return k.prefix, true
+	}
+
+	panic("unreachable")
+}
+
+// firstAfter returns the smallest prefix in the subtrie at n
+// with a sort key in this node greater than key.
+func (n *node[V]) firstAfter(path [16]byte, depth int, is4 bool, key int) (netip.Prefix, bool) {
+	bestKey, bestIdx, bestAddr, isChild := math.MaxInt, uint(0), uint(0), false
+
+	for _, idx := range n.prefixes.AsSlice(make([]uint, 0, maxNodePrefixes)) {
+		if k := pfxSortKey(idx); k > key && k < bestKey {
+			bestKey, bestIdx, isChild = k, idx, false
+		}
+	}
+
+	for _, addr := range n.children.AsSlice(make([]uint, 0, maxNodeChildren)) {
+		if k := childSortKey(addr); k > key && k < bestKey {
+			bestKey, bestAddr, isChild = k, addr, true
+		}
+	}
+
+	switch {
+	case bestKey == math.MaxInt:
+		return netip.Prefix{}, false
+	case !isChild:
+		return cidrFromPath(path, depth, is4, bestIdx), true
+	}
+
+	// the first prefix in the subtrie of the child
+	switch k := n.children.MustGet(bestAddr).(type) {
+	case *node[V]:
+		path[depth] = byte(bestAddr)
+		return k.firstAfter(path, depth+1, is4, -1)
+	case *leaf[V]:
+		return k.prefix, true
+	}
+
+	panic("unreachable")
+}
+
 // unionRec combines two nodes, changing the receiver node.
 // If there are duplicate entries, the value is taken from the other node.
 // Count duplicate entries to adjust the t.size struct members.
@@ -433,6 +857,13 @@ LOOP:
 				continue LOOP
 
 			case *leaf[V]: // leaf, leaf
+				// same prefix, just overwrite this leaf, keep it path compressed
+				if this.prefix == otherChild.prefix {
+					n.children.InsertAt(addr, otherChild.cloneLeaf())
+					duplicates++
+					continue LOOP
+				}
+
 				// create new node
 				nc := new(node[V])
 
@@ -455,6 +886,145 @@ LOOP:
 	return duplicates
 }
 
+// unionAllRec merges the children of all inputs, nodes or leaves at the
+// same position, into the empty node n in a single rec-descent.
+//
+// For duplicate prefixes the values are combined in argument order, if
+// combine is nil the last writer wins. The inputs are not changed,
+// the values are cloned. Merged child nodes with a single prefix or leaf
+// are path compressed, as with [Table.Union].
+func (n *node[V]) unionAllRec(others []any, path [16]byte, depth int, is4 bool, combine func(V, V) V) (duplicates int) {
+	// union of all prefix indices and child addrs
+	var idxs, addrs bitset.BitSet
+
+	for _, other := range others {
+		switch o := other.(type) {
+		case *node[V]:
+			idxs.InPlaceUnion(o.prefixes.BitSet)
+			addrs.InPlaceUnion(o.children.BitSet)
+		case *leaf[V]:
+			// a path compressed leaf is a prefix at this depth or a child
+			if octet, idx, ok := leafAtDepth(o.prefix, depth); ok {
+				idxs = idxs.Set(idx)
+			} else {
+				addrs = addrs.Set(uint(octet))
+			}
+		}
+	}
+
+	// the prefixes are built in index order, set the bitset and the items at once
+	n.prefixes.BitSet = idxs
+	n.prefixes.Items = make([]V, 0, idxs.Size())
+
+	buf := make([]uint, 0, maxNodePrefixes)
+	for _, idx := range idxs.AsSlice(buf) {
+		var val V
+		count := 0
+
+		// combine the values in argument order
+		for _, other := range others {
+			var oVal V
+			var ok bool
+
+			switch o := other.(type) {
+			case *node[V]:
+				oVal, ok = o.prefixes.Get(idx)
+			case *leaf[V]:
+				var lIdx uint
+				if _, lIdx, ok = leafAtDepth(o.prefix, depth); ok && lIdx == idx {
+					oVal = o.value
+				} else {
+					ok = false
+				}
+			}
+
+			if !ok {
+				continue
+			}
+
+			oVal = cloneOrCopyValue(oVal)
+			if count != 0 && combine != nil {
+				oVal = combine(val, oVal)
+			}
+
+			val = oVal
+			count++
+		}
+
+		duplicates += count - 1
+		n.prefixes.Items = append(n.prefixes.Items, val)
+	}
+
+	kids := make([]any, 0, len(others))
+
+	// the children are built in addr order, set the bitset and the items at once
+	n.children.BitSet = addrs
+	n.children.Items = make([]any, 0, addrs.Size())
+
+	for _, addr := range addrs.AsSlice(buf) {
+		// collect the children at addr in argument order
+		kids = kids[:0]
+		for _, other := range others {
+			switch o := other.(type) {
+			case *node[V]:
+				if c, ok := o.children.Get(addr); ok {
+					kids = append(kids, c)
+				}
+			case *leaf[V]:
+				if octet, _, ok := leafAtDepth(o.prefix, depth); !ok && uint(octet) == addr {
+					kids = append(kids, o)
+				}
+			}
+		}
+
+		// only one child at addr, just clone it
+		if len(kids) == 1 {
+			switch k := kids[0].(type) {
+			case *node[V]:
+				n.children.Items = append(n.children.Items, k.cloneRec())
+			case *leaf[V]:
+				n.children.Items = append(n.children.Items, k.cloneLeaf())
+			}
+			continue
+		}
+
+		path[depth] = byte(addr)
+
+		nc := new(node[V])
+		duplicates += nc.unionAllRec(kids, path, depth+1, is4, combine)
+		n.children.Items = append(n.children.Items, nc)
+
+		// replace nc by a leaf, if possible
+		nc.purgeOrCompress(n, addr, path[:], depth+1, is4)
+	}
+
+	return duplicates
+}
+
+// leafAtDepth returns the octet of pfx at depth and true with the
+// prefix index, if pfx is stored as prefix in a node at this depth.
+func leafAtDepth(pfx netip.Prefix, depth int) (octet byte, idx uint, ok bool) {
+	lastIdx, lastBits := lastOctetIdxAndBits(pfx.Bits())
+	octet = octetAt(pfx.Addr(), depth)
+
+	if depth == lastIdx {
+		return octet, pfxToIdx(octet, lastBits), true
+	}
+
+	return octet, 0, false
+}
+
+// octetAt returns the octet of ip at depth, without allocation.
+func octetAt(ip netip.Addr, depth int) byte {
+	if ip.Is4() {
+		a4 := ip.As4()
+		return a4[depth]
+	}
+
+	a16 := ip.As16()
+	return a16[depth]
+}
+
 // eachLookupPrefix does an all prefix match in the 8-bit (stride) routing table
 // at this depth and calls yield() for any matching CIDR.
 func (n *node[V]) eachLookupPrefix(octets []byte, depth int, is4 bool, pfxLen int, yield func(netip.Prefix, V) bool) (ok bool) {
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// ChangeOp is the kind of a table mutation, see [Table.OnChange].
+type ChangeOp int
+
+const (
+	// ChangeInsert, a new prefix was inserted, the old value is the zero value.
+	ChangeInsert ChangeOp = iota + 1
+
+	// ChangeUpdate, the value of an existing prefix was set.
+	ChangeUpdate
+
+	// ChangeDelete, a prefix was deleted, the new value is the zero value.
+	ChangeDelete
+)
+
+// String implements the [fmt.Stringer] interface.
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeInsert:
+		return "insert"
+	case ChangeUpdate:
+		return "update"
+	case ChangeDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// OnChange registers the hook fn, called on every insert, update and delete
+// of a prefix, e.g. for metrics or audit logs of the route churn.
+// A nil fn clears the hook, there is only one hook per table.
+//
+// The hook is called after the mutation has succeeded, with the canonical
+// prefix, the old and the new value. It is called by all mutating methods,
+// a bulk [Table.Union] falls back to single inserts while a hook is set.
+// The hook must not modify the table and it is not copied by [Table.Clone].
+//
+// The costs without a hook are a nil check per mutation.
+func (t *Table[V]) OnChange(fn func(op ChangeOp, pfx netip.Prefix, oldVal V, newVal V)) {
+	t.onChange = fn
+}
+
+// notify calls the hook, if set.
+func (t *Table[V]) notify(op ChangeOp, pfx netip.Prefix, oldVal V, newVal V) {
+	if t.onChange != nil {
+		t.onChange(op, pfx, oldVal, newVal)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+type changeEvent struct {
+	op     ChangeOp
+	pfx    netip.Prefix
+	oldVal int
+	newVal int
+}
+
+func TestOnChange(t *testing.T) {
+	t.Parallel()
+
+	var events []changeEvent
+
+	rt := new(Table[int])
+	rt.OnChange(func(op ChangeOp, pfx netip.Prefix, oldVal, newVal int) {
+		// the hook fires after the mutation
+		if val, ok := rt.Get(pfx); ok != (op != ChangeDelete) || val != newVal {
+			t.Errorf("OnChange(%v, %s), hook fired before mutation", op, pfx)
+		}
+		events = append(events, changeEvent{op, pfx, oldVal, newVal})
+	})
+
+	rt.Insert(netip.MustParsePrefix("10.0.0.1/8"), 1)
+	rt.Insert(mpp("10.0.0.0/8"), 2)
+	rt.Update(mpp("10.0.0.0/8"), func(val int, _ bool) int { return val + 1 })
+	rt.Update(mpp("2001:db8::/32"), func(int, bool) int { return 4 })
+	rt.Insert(mpp("192.168.0.0/16"), 5)
+	rt.SwapValues(mpp("10.0.0.0/8"), mpp("192.168.0.0/16"))
+	rt.Delete(mpp("10.0.0.0/8"))
+	rt.Delete(mpp("10.0.0.0/8")) // not found, no event
+	rt.GetAndDelete(mpp("2001:db8::/32"))
+	rt.DeleteAll([]netip.Prefix{mpp("192.168.0.0/16"), mpp("172.16.0.0/12")})
+	rt.Insert(netip.Prefix{}, 6) // invalid, no event
+
+	o := new(Table[int])
+	o.Insert(mpp("10.1.0.0/16"), 7)
+	rt.Union(o)
+
+	want := []changeEvent{
+		{ChangeInsert, mpp("10.0.0.0/8"), 0, 1},
+		{ChangeUpdate, mpp("10.0.0.0/8"), 1, 2},
+		{ChangeUpdate, mpp("10.0.0.0/8"), 2, 3},
+		{ChangeInsert, mpp("2001:db8::/32"), 0, 4},
+		{ChangeInsert, mpp("192.168.0.0/16"), 0, 5},
+		{ChangeUpdate, mpp("10.0.0.0/8"), 3, 5},
+		{ChangeUpdate, mpp("192.168.0.0/16"), 5, 3},
+		{ChangeDelete, mpp("10.0.0.0/8"), 5, 0},
+		{ChangeDelete, mpp("2001:db8::/32"), 4, 0},
+		{ChangeDelete, mpp("192.168.0.0/16"), 3, 0},
+		{ChangeInsert, mpp("10.1.0.0/16"), 0, 7},
+	}
+
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("OnChange events\ngot:  %v\nwant: %v", events, want)
+	}
+
+	// clear the hook
+	events = nil
+	rt.OnChange(nil)
+	rt.Insert(mpp("10.0.0.0/8"), 8)
+	rt.Delete(mpp("10.0.0.0/8"))
+
+	if len(events) != 0 {
+		t.Errorf("OnChange(nil), expected no events, got %v", events)
+	}
+
+	// clones have no hook
+	rt.OnChange(func(ChangeOp, netip.Prefix, int, int) {
+		t.Errorf("OnChange, hook fired for a clone")
+	})
+	rt.Clone().Insert(mpp("10.0.0.0/8"), 9)
+}
+
+func TestOnChangeUnion(t *testing.T) {
+	t.Parallel()
+
+	a := new(Table[int])
+	b := new(Table[int])
+	o := new(Table[int])
+
+	for _, item := range randomPrefixes(1_000) {
+		a.Insert(item.pfx, item.val)
+		b.Insert(item.pfx, item.val)
+	}
+	for _, item := range randomPrefixes(1_000) {
+		o.Insert(item.pfx, item.val)
+	}
+
+	count := 0
+	b.OnChange(func(ChangeOp, netip.Prefix, int, int) { count++ })
+
+	a.Union(o)
+	b.Union(o)
+
+	if count != o.Size() {
+		t.Errorf("OnChange, Union fired %d events, want %d", count, o.Size())
+	}
+
+	if !reflect.DeepEqual(a.ToSlice(), b.ToSlice()) {
+		t.Errorf("OnChange, Union with hook differs from Union")
+	}
+}
+
+func TestOnChangeInsertDedup(t *testing.T) {
+	t.Parallel()
+
+	var events []changeEvent
+
+	rt := new(Table[int])
+	rt.OnChange(func(op ChangeOp, pfx netip.Prefix, oldVal, newVal int) {
+		events = append(events, changeEvent{op, pfx, oldVal, newVal})
+	})
+
+	eq := func(a, b int) bool { return a == b }
+
+	rt.InsertDedupFunc(mpp("10.0.0.0/8"), 1, eq)
+	rt.InsertDedupFunc(mpp("10.0.0.0/8"), 1, eq) // no-op, no event
+	rt.InsertDedupFunc(mpp("10.0.0.0/8"), 2, eq)
+	rt.InsertDedupFunc(mpp("10.0.0.0/8"), 2, eq) // no-op, no event
+
+	want := []changeEvent{
+		{ChangeInsert, mpp("10.0.0.0/8"), 0, 1},
+		{ChangeUpdate, mpp("10.0.0.0/8"), 1, 2},
+	}
+
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("OnChange events for InsertDedupFunc\ngot:  %v\nwant: %v", events, want)
+	}
+}
+
+func TestOnChangeDeaggregate(t *testing.T) {
+	t.Parallel()
+
+	var events []changeEvent
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/24"), 1)
+	rt.Insert(mpp("10.0.0.128/25"), 2)
+
+	rt.OnChange(func(op ChangeOp, pfx netip.Prefix, oldVal, newVal int) {
+		events = append(events, changeEvent{op, pfx, oldVal, newVal})
+	})
+
+	if !rt.Deaggregate(mpp("10.0.0.0/24")) {
+		t.Fatalf("Deaggregate(10.0.0.0/24), got false, want true")
+	}
+
+	// the stored upper half keeps its value, no event
+	want := []changeEvent{
+		{ChangeDelete, mpp("10.0.0.0/24"), 1, 0},
+		{ChangeInsert, mpp("10.0.0.0/25"), 0, 1},
+	}
+
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("OnChange events for Deaggregate\ngot:  %v\nwant: %v", events, want)
+	}
+}
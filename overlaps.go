@@ -209,7 +209,7 @@ func overlapsTwoChilds[V any](nChild, oChild any, depth int) bool {
 	case *node[V]:
 		switch oKind := oChild.(type) {
 		case *node[V]: // node, node
-			return nKind.overlaps(oKind, depth+1) // node, node
+			return nKind.overlaps(oKind, depth) // node, node
 		case *leaf[V]: // node, leaf
 			return nKind.overlapsPrefixAtDepth(oKind.prefix, depth) // node, node
 		}
@@ -299,3 +299,62 @@ func (n *node[V]) overlapsIdx(octet byte, pfxLen int) bool {
 	// use bitsets intersection instead of range loops
 	return allotedPrefixRoutes.IntersectsAny(hostRoutes)
 }
+
+// OverlapCount returns the number of prefixes in the table that overlap
+// at least one other prefix in the table, either as supernet or as subnet.
+//
+// The prefixes are visited once in CIDR sort order, a covering prefix is
+// always visited before all its subnets, no pairwise cross check is needed.
+func (t *Table[V]) OverlapCount() int {
+	return overlapCountSorted(t.AllSorted4()) + overlapCountSorted(t.AllSorted6())
+}
+
+// overlapCountSorted, count overlapping prefixes from a CIDR sorted iterator.
+func overlapCountSorted[V any](allSorted func(yield func(netip.Prefix, V) bool)) (count int) {
+	// max depth is 129 for IPv6
+	stack := make(overlapStack, 0, 129)
+
+	allSorted(func(pfx netip.Prefix, _ V) bool {
+		count += stack.push(pfx)
+		return true
+	})
+
+	return count
+}
+
+// overlapStack is the stack of the covering prefixes of the current prefix
+// in a CIDR sorted traversal, each marked if already counted as overlapping.
+type overlapStack []overlapItem
+
+type overlapItem struct {
+	pfx     netip.Prefix
+	counted bool
+}
+
+// push pfx, the next prefix in CIDR sort order, onto the stack and return
+// the number of prefixes newly counted as overlapping, pfx itself and its
+// covering prefix if not yet counted.
+func (s *overlapStack) push(pfx netip.Prefix) (count int) {
+	stack := *s
+
+	// pop all prefixes from stack that do not cover pfx
+	for len(stack) > 0 && !stack[len(stack)-1].pfx.Contains(pfx.Addr()) {
+		stack = stack[:len(stack)-1]
+	}
+
+	if len(stack) == 0 {
+		*s = append(stack, overlapItem{pfx, false})
+		return 0
+	}
+
+	// pfx is covered by top of stack, both overlap
+	if top := &stack[len(stack)-1]; !top.counted {
+		top.counted = true
+		count++
+	}
+
+	count++
+	*s = append(stack, overlapItem{pfx, true})
+
+	return count
+}
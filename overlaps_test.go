@@ -56,6 +56,27 @@ func TestRegressionOverlaps(t *testing.T) {
 			t.Fatal("tables unexpectedly do not overlap")
 		}
 	})
+
+	t.Run("overlaps_same_children_node_and_leaf_at_depth_two", func(t *testing.T) {
+		t.Parallel()
+		t1, t2 := new(Table[int]), new(Table[int])
+
+		t1.Insert(mpp("10.1.2.0/24"), 1)
+		t1.Insert(mpp("10.1.3.0/24"), 1)
+
+		t2.Insert(mpp("10.1.200.0/24"), 1)
+		t2.Insert(mpp("10.5.0.0/16"), 1)
+
+		if t1.Overlaps(t2) {
+			t.Fatal("tables unexpectedly do overlap")
+		}
+
+		t2.Insert(mpp("10.1.3.128/25"), 1)
+
+		if !t1.Overlaps(t2) {
+			t.Fatal("tables unexpectedly do not overlap")
+		}
+	})
 }
 
 func TestOverlapsCompare(t *testing.T) {
@@ -186,3 +207,53 @@ func TestOverlapsChildren(t *testing.T) {
 		t.Fatal("tables unexpectedly do overlap")
 	}
 }
+
+func TestOverlapCount(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if got := rt.OverlapCount(); got != 0 {
+		t.Errorf("empty table, OverlapCount, expected 0, got %d", got)
+	}
+
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.0/24",
+		"11.0.0.0/8",
+		"12.0.0.0/8",
+		"12.0.0.0/24",
+		"2001:db8::/32",
+		"2001:db9::/32",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	if got := rt.OverlapCount(); got != 5 {
+		t.Errorf("OverlapCount, expected 5, got %d", got)
+	}
+
+	for range 10 {
+		pfxs := randomPrefixes(1_000)
+
+		rt := new(Table[int])
+		for _, item := range pfxs {
+			rt.Insert(item.pfx, item.val)
+		}
+
+		// gold, full cross check
+		want := 0
+		for i := range pfxs {
+			for j := range pfxs {
+				if i != j && pfxs[i].pfx.Overlaps(pfxs[j].pfx) {
+					want++
+					break
+				}
+			}
+		}
+
+		if got := rt.OverlapCount(); got != want {
+			t.Fatalf("OverlapCount, expected %d, got %d", want, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// PriorityTable is a routing table with payload V, where every prefix
+// carries an integer priority, e.g. for the best-path selection when
+// merging routes from multiple sources.
+//
+// For duplicate prefixes the value with the higher priority is kept instead
+// of last-writer-wins. For equal priorities the stored value is kept, the
+// result does not depend on the order of sources with different priorities.
+//
+// The zero value is ready to use. A PriorityTable must not be copied by value.
+type PriorityTable[V any] struct {
+	tbl Table[prioValue[V]]
+}
+
+// prioValue, the payload of a PriorityTable.
+type prioValue[V any] struct {
+	val  V
+	prio int
+}
+
+// Insert adds pfx with val and prio to the table. If pfx is already present
+// with a higher or equal priority, the table is not changed.
+// Returns true if val was stored.
+func (t *PriorityTable[V]) Insert(pfx netip.Prefix, val V, prio int) bool {
+	if !pfx.IsValid() {
+		return false
+	}
+
+	stored := false
+	t.tbl.Update(pfx, func(old prioValue[V], ok bool) prioValue[V] {
+		if ok && old.prio >= prio {
+			return old
+		}
+		stored = true
+		return prioValue[V]{val, prio}
+	})
+
+	return stored
+}
+
+// Delete removes pfx from the table, regardless of its priority.
+func (t *PriorityTable[V]) Delete(pfx netip.Prefix) {
+	t.tbl.Delete(pfx)
+}
+
+// Get returns the associated payload and priority for prefix and true,
+// or false if prefix is not set in the table.
+func (t *PriorityTable[V]) Get(pfx netip.Prefix) (val V, prio int, ok bool) {
+	pv, ok := t.tbl.Get(pfx)
+	return pv.val, pv.prio, ok
+}
+
+// Lookup does a route lookup (longest prefix match) for IP and
+// returns the associated value, its priority and true, or false if no route matched.
+//
+// The longest prefix always wins, priorities only decide between
+// duplicate prefixes during insert.
+func (t *PriorityTable[V]) Lookup(ip netip.Addr) (val V, prio int, ok bool) {
+	pv, ok := t.tbl.Lookup(ip)
+	return pv.val, pv.prio, ok
+}
+
+// UnionWith merges the entries of o into the receiver, for duplicate
+// prefixes the entry with the higher priority wins, see [PriorityTable.Insert].
+//
+// If type V implements the [Cloner] interface, the values from o are cloned.
+func (t *PriorityTable[V]) UnionWith(o *PriorityTable[V]) {
+	if o == nil {
+		return
+	}
+
+	o.tbl.All()(func(pfx netip.Prefix, pv prioValue[V]) bool {
+		t.tbl.Update(pfx, func(old prioValue[V], ok bool) prioValue[V] {
+			if ok && old.prio >= pv.prio {
+				return old
+			}
+			return prioValue[V]{cloneOrCopyValue(pv.val), pv.prio}
+		})
+		return true
+	})
+}
+
+// All returns an iterator over all prefixes and values of the table,
+// the priorities are not reported, see [PriorityTable.Get].
+func (t *PriorityTable[V]) All() func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		t.tbl.All()(func(pfx netip.Prefix, pv prioValue[V]) bool {
+			return yield(pfx, pv.val)
+		})
+	}
+}
+
+// Size returns the prefix count.
+func (t *PriorityTable[V]) Size() int {
+	return t.tbl.Size()
+}
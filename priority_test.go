@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPriorityTable(t *testing.T) {
+	t.Parallel()
+
+	var pt PriorityTable[string]
+	pfx := mpp("10.0.0.0/8")
+
+	if pt.Insert(netip.Prefix{}, "invalid", 1) {
+		t.Errorf("Insert(invalid), expected false")
+	}
+
+	if !pt.Insert(pfx, "igp", 10) {
+		t.Errorf("Insert, new prefix, expected true")
+	}
+	if pt.Insert(pfx, "bgp", 5) {
+		t.Errorf("Insert, lower priority, expected false")
+	}
+	if pt.Insert(pfx, "other", 10) {
+		t.Errorf("Insert, equal priority, expected false")
+	}
+	if val, prio, ok := pt.Get(pfx); !ok || val != "igp" || prio != 10 {
+		t.Errorf("Get, expected (igp, 10, true), got (%s, %d, %v)", val, prio, ok)
+	}
+	if !pt.Insert(pfx, "static", 20) {
+		t.Errorf("Insert, higher priority, expected true")
+	}
+
+	// longest prefix wins, regardless of priority
+	pt.Insert(mpp("10.1.0.0/16"), "more specific", 1)
+
+	if val, prio, ok := pt.Lookup(mpa("10.1.2.3")); !ok || val != "more specific" || prio != 1 {
+		t.Errorf("Lookup, expected (more specific, 1, true), got (%s, %d, %v)", val, prio, ok)
+	}
+	if val, prio, ok := pt.Lookup(mpa("10.2.0.1")); !ok || val != "static" || prio != 20 {
+		t.Errorf("Lookup, expected (static, 20, true), got (%s, %d, %v)", val, prio, ok)
+	}
+
+	pt.Delete(mpp("10.1.0.0/16"))
+	if pt.Size() != 1 {
+		t.Errorf("Delete, expected size 1, got %d", pt.Size())
+	}
+}
+
+func TestPriorityTableUnionWith(t *testing.T) {
+	t.Parallel()
+
+	feeds := []struct {
+		prio int
+		pfxs []string
+	}{
+		{5, []string{"10.0.0.0/8", "10.1.0.0/16", "2001:db8::/32"}},
+		{10, []string{"10.0.0.0/8", "192.168.0.0/16"}},
+		{1, []string{"10.1.0.0/16", "192.168.0.0/16", "172.16.0.0/12"}},
+	}
+
+	want := map[netip.Prefix]int{
+		mpp("10.0.0.0/8"):     10,
+		mpp("10.1.0.0/16"):    5,
+		mpp("2001:db8::/32"):  5,
+		mpp("192.168.0.0/16"): 10,
+		mpp("172.16.0.0/12"):  1,
+	}
+
+	tables := make([]*PriorityTable[int], len(feeds))
+	for i, feed := range feeds {
+		tables[i] = new(PriorityTable[int])
+		for _, s := range feed.pfxs {
+			tables[i].Insert(mpp(s), feed.prio, feed.prio)
+		}
+	}
+
+	// deterministic, independent of merge order
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+		merged := new(PriorityTable[int])
+		for _, i := range order {
+			merged.UnionWith(tables[i])
+		}
+		merged.UnionWith(nil)
+
+		if merged.Size() != len(want) {
+			t.Fatalf("UnionWith %v, expected size %d, got %d", order, len(want), merged.Size())
+		}
+
+		merged.All()(func(pfx netip.Prefix, val int) bool {
+			if _, prio, _ := merged.Get(pfx); val != want[pfx] || prio != want[pfx] {
+				t.Errorf("UnionWith %v, %s, expected %d, got value %d and prio %d", order, pfx, want[pfx], val, prio)
+			}
+			return true
+		})
+	}
+}
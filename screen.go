@@ -0,0 +1,374 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"slices"
+
+	"github.com/gaissmai/bart/internal/bitset"
+)
+
+const (
+	screenBits4 = 8  // one bucket per /8 for IPv4
+	screenBits6 = 16 // one bucket per /16 for IPv6
+)
+
+// topLevelScreen is an occupancy bitmap of the first address bits,
+// one bit per /8 (IPv4) or /16 (IPv6) bucket.
+//
+// A bit is set if any route in the table overlaps the bucket,
+// an unset bit guarantees that no route can match an address in this bucket.
+type topLevelScreen struct {
+	v4 bitset.BitSet
+	v6 bitset.BitSet
+
+	refs4 screenRefs
+	refs6 screenRefs
+}
+
+// screenRefs counts the routes of one address family, the bucket bits are
+// maintained from the counts on delete without any trie descent.
+//
+// Routes with at least the bucket length are counted per bucket, the shorter
+// routes per subtree of a complete binary tree over the buckets, in base index
+// order like the ART. A bucket is occupied if it has routes of its own or if
+// one of its ancestors in the tree is a route.
+type screenRefs struct {
+	bits  int           // bucket length, 8 for IPv4 and 16 for IPv6
+	long  []uint32      // routes in the bucket, by bucket
+	occ   bitset.BitSet // buckets with routes of their own
+	short []uint16      // shorter routes in the subtree, by base index
+}
+
+// EnableTopLevelScreen enables an occupancy bitmap of all /8 (IPv4)
+// and /16 (IPv6) buckets touched by the routes in the table.
+//
+// With the screen enabled, [Table.Contains] rejects addresses in
+// unoccupied buckets without any trie descent, for sparse tables
+// concentrated in a few buckets the common miss becomes almost free.
+//
+// The screen is kept consistent by all mutating methods by reference
+// counting, the costs are a counter and bitmap update on insert and delete,
+// for prefixes shorter than the bucket a word-wise update of the bitmap
+// range and about 400KB memory for the bitmaps and counters.
+// Calling EnableTopLevelScreen on an already screened table is a no-op.
+func (t *Table[V]) EnableTopLevelScreen() {
+	if t.screen != nil {
+		return
+	}
+
+	t.screen = &topLevelScreen{
+		v4: bitset.BitSet(make([]uint64, (1<<screenBits4)/64)),
+		v6: bitset.BitSet(make([]uint64, (1<<screenBits6)/64)),
+
+		refs4: newScreenRefs(screenBits4),
+		refs6: newScreenRefs(screenBits6),
+	}
+
+	t.screenRebuild()
+}
+
+// MightContain is an approximate membership check for ip without false
+// negatives, it returns false only if no route can match ip. A true result
+// must be confirmed by [Table.Contains] or [Table.Lookup].
+//
+// Ultra-hot paths may skip the full lookup on obvious misses. With the
+// screen enabled, see [Table.EnableTopLevelScreen], all addresses in
+// unoccupied /8 (IPv4) or /16 (IPv6) buckets are rejected, without the
+// screen only the addresses of an empty address family.
+func (t *Table[V]) MightContain(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+
+	if ip.Is4() && t.size4 == 0 || ip.Is6() && t.size6 == 0 {
+		return false
+	}
+
+	return t.screenTest(ip)
+}
+
+// screenRebuild sets the bucket bits and counts for all prefixes in the table.
+func (t *Table[V]) screenRebuild() {
+	if t.screen == nil {
+		return
+	}
+
+	clear(t.screen.v4)
+	clear(t.screen.v6)
+	t.screen.refs4.reset()
+	t.screen.refs6.reset()
+
+	t.All()(func(pfx netip.Prefix, _ V) bool {
+		t.screen.insert(pfx)
+		return true
+	})
+}
+
+// screenInsert updates the screen, if enabled, after pfx was inserted.
+func (t *Table[V]) screenInsert(pfx netip.Prefix) {
+	if t.screen == nil {
+		return
+	}
+	t.screen.insert(pfx)
+}
+
+// screenDelete updates the screen, if enabled, after pfx was deleted.
+func (t *Table[V]) screenDelete(pfx netip.Prefix) {
+	if t.screen == nil {
+		return
+	}
+	t.screen.delete(pfx)
+}
+
+// screenTest reports whether the bucket for ip is occupied.
+// If the screen is disabled, screenTest returns always true.
+func (t *Table[V]) screenTest(ip netip.Addr) bool {
+	if t.screen == nil {
+		return true
+	}
+
+	return t.screen.test(ip)
+}
+
+// clone returns a copy of the screen.
+func (s *topLevelScreen) clone() *topLevelScreen {
+	if s == nil {
+		return nil
+	}
+
+	return &topLevelScreen{
+		v4: s.v4.Clone(),
+		v6: s.v6.Clone(),
+
+		refs4: s.refs4.clone(),
+		refs6: s.refs6.clone(),
+	}
+}
+
+// bitsetByVersion, bucket bitset getter for ip version.
+func (s *topLevelScreen) bitsetByVersion(is4 bool) bitset.BitSet {
+	if is4 {
+		return s.v4
+	}
+
+	return s.v6
+}
+
+// refsByVersion, bucket counters getter for ip version.
+func (s *topLevelScreen) refsByVersion(is4 bool) *screenRefs {
+	if is4 {
+		return &s.refs4
+	}
+
+	return &s.refs6
+}
+
+// insert counts pfx and sets the bits for all buckets covered by pfx.
+func (s *topLevelScreen) insert(pfx netip.Prefix) {
+	is4 := pfx.Addr().Is4()
+	s.refsByVersion(is4).insert(s.bitsetByVersion(is4), pfx)
+}
+
+// delete uncounts pfx and clears the bits of all buckets
+// covered by pfx and no longer occupied by any other route.
+func (s *topLevelScreen) delete(pfx netip.Prefix) {
+	is4 := pfx.Addr().Is4()
+	s.refsByVersion(is4).delete(s.bitsetByVersion(is4), pfx)
+}
+
+// test reports whether the bucket for ip is occupied.
+func (s *topLevelScreen) test(ip netip.Addr) bool {
+	if ip.Is4() {
+		return s.v4.Test(screenBucket(ip))
+	}
+
+	return s.v6.Test(screenBucket(ip))
+}
+
+// testBytes reports whether the bucket for the IP in wire form is occupied,
+// ip must be a 4 or 16 byte slice.
+func (s *topLevelScreen) testBytes(ip []byte) bool {
+	if len(ip) == 4 {
+		return s.v4.Test(uint(ip[0]))
+	}
+
+	return s.v6.Test(uint(ip[0])<<8 | uint(ip[1]))
+}
+
+// screenBucket returns the bucket number for ip,
+// the first octet for IPv4 and the first two octets for IPv6.
+func screenBucket(ip netip.Addr) uint {
+	if ip.Is4() {
+		return uint(ip.As4()[0])
+	}
+
+	a16 := ip.As16()
+	return uint(a16[0])<<8 | uint(a16[1])
+}
+
+// screenBucketRange returns the first and last bucket covered by pfx,
+// pfx must be valid and already in canonical form.
+func screenBucketRange(pfx netip.Prefix) (first, last uint) {
+	bucketBits := screenBits6
+	if pfx.Addr().Is4() {
+		bucketBits = screenBits4
+	}
+
+	first = screenBucket(pfx.Addr())
+	if bits := pfx.Bits(); bits < bucketBits {
+		last = first | (1<<(bucketBits-bits) - 1)
+		return first, last
+	}
+
+	return first, first
+}
+
+// newScreenRefs returns the counters for buckets of the given length.
+func newScreenRefs(bits int) screenRefs {
+	return screenRefs{
+		bits:  bits,
+		long:  make([]uint32, 1<<bits),
+		occ:   bitset.BitSet(make([]uint64, (1<<bits+63)/64)),
+		short: make([]uint16, 1<<bits),
+	}
+}
+
+// reset clears all counters.
+func (r *screenRefs) reset() {
+	clear(r.long)
+	clear(r.occ)
+	clear(r.short)
+}
+
+// clone returns a copy of the counters.
+func (r *screenRefs) clone() screenRefs {
+	return screenRefs{
+		bits:  r.bits,
+		long:  slices.Clone(r.long),
+		occ:   r.occ.Clone(),
+		short: slices.Clone(r.short),
+	}
+}
+
+// shortIdx returns the base index of pfx, pfx must be shorter than the bucket.
+func (r *screenRefs) shortIdx(pfx netip.Prefix, first uint) uint {
+	bits := pfx.Bits()
+	return 1<<bits | first>>(r.bits-bits)
+}
+
+// own returns the number of routes at the base index idx itself,
+// the subtree count minus the counts of both child subtrees.
+func (r *screenRefs) own(idx uint) uint16 {
+	if left := idx << 1; left < uint(len(r.short)) {
+		return r.short[idx] - r.short[left] - r.short[left+1]
+	}
+
+	return r.short[idx]
+}
+
+// covered reports whether a route is at a strict ancestor of idx.
+func (r *screenRefs) covered(idx uint) bool {
+	for idx >>= 1; idx > 0; idx >>= 1 {
+		if r.own(idx) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// insert counts pfx and sets the bucket bits in b.
+func (r *screenRefs) insert(b bitset.BitSet, pfx netip.Prefix) {
+	first, last := screenBucketRange(pfx)
+
+	if pfx.Bits() >= r.bits {
+		r.long[first]++
+		r.occ.Set(first)
+		b.Set(first)
+		return
+	}
+
+	for idx := r.shortIdx(pfx, first); idx > 0; idx >>= 1 {
+		r.short[idx]++
+	}
+
+	setBucketRange(b, first, last-first+1)
+}
+
+// delete uncounts pfx and clears the bucket bits in b
+// for buckets no longer occupied.
+func (r *screenRefs) delete(b bitset.BitSet, pfx netip.Prefix) {
+	first, _ := screenBucketRange(pfx)
+
+	if pfx.Bits() >= r.bits {
+		r.long[first]--
+		if r.long[first] != 0 {
+			return
+		}
+
+		r.occ.Clear(first)
+
+		// the bucket is the child of the last base index
+		if !r.covered(uint(len(r.short)) | first) {
+			b.Clear(first)
+		}
+		return
+	}
+
+	idx := r.shortIdx(pfx, first)
+	for i := idx; i > 0; i >>= 1 {
+		r.short[i]--
+	}
+
+	if r.covered(idx) {
+		return
+	}
+
+	r.rebuild(b, idx, first, uint(1)<<(r.bits-pfx.Bits()))
+}
+
+// rebuild recomputes the bits of the n buckets starting at first
+// below the base index idx, subtrees without shorter routes are
+// copied word-wise from the buckets with routes of their own.
+func (r *screenRefs) rebuild(b bitset.BitSet, idx, first, n uint) {
+	switch {
+	case r.short[idx] == 0:
+		copyBucketRange(b, r.occ, first, n)
+	case r.own(idx) != 0:
+		setBucketRange(b, first, n)
+	default:
+		// shorter routes deeper in the subtree, descend
+		n >>= 1
+		r.rebuild(b, idx<<1, first, n)
+		r.rebuild(b, idx<<1+1, first+n, n)
+	}
+}
+
+// setBucketRange sets the n bits starting at first in b,
+// n is a power of two and first is aligned to n.
+func setBucketRange(b bitset.BitSet, first, n uint) {
+	if n >= 64 {
+		for w := first >> 6; w < (first+n)>>6; w++ {
+			b[w] = ^uint64(0)
+		}
+		return
+	}
+
+	b[first>>6] |= (1<<n - 1) << (first & 63)
+}
+
+// copyBucketRange copies the n bits starting at first from src to b,
+// n is a power of two and first is aligned to n.
+func copyBucketRange(b, src bitset.BitSet, first, n uint) {
+	if n >= 64 {
+		copy(b[first>>6:(first+n)>>6], src[first>>6:])
+		return
+	}
+
+	mask := uint64(1<<n-1) << (first & 63)
+	w := first >> 6
+	b[w] = b[w]&^mask | src[w]&mask
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+func TestTopLevelScreenContains(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.EnableTopLevelScreen()
+
+	if rt.Contains(mpa("10.0.0.1")) {
+		t.Errorf("empty screened table, Contains, expected false")
+	}
+
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("2001:db8::/32"), 2)
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"11.0.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := rt.Contains(mpa(tt.ip)); got != tt.want {
+			t.Errorf("Contains(%s), expected %v, got %v", tt.ip, tt.want, got)
+		}
+	}
+
+	// short prefix covers many buckets
+	rt.Insert(mpp("0.0.0.0/1"), 3)
+	if !rt.Contains(mpa("127.0.0.1")) {
+		t.Errorf("Contains(127.0.0.1) after insert 0.0.0.0/1, expected true")
+	}
+
+	// bucket 10 must stay occupied after delete of covering prefix
+	rt.Delete(mpp("0.0.0.0/1"))
+	if rt.Contains(mpa("127.0.0.1")) {
+		t.Errorf("Contains(127.0.0.1) after delete 0.0.0.0/1, expected false")
+	}
+	if !rt.Contains(mpa("10.0.0.1")) {
+		t.Errorf("Contains(10.0.0.1) after delete 0.0.0.0/1, expected true")
+	}
+
+	rt.Delete(mpp("10.0.0.0/8"))
+	if rt.Contains(mpa("10.0.0.1")) {
+		t.Errorf("Contains(10.0.0.1) after delete 10.0.0.0/8, expected false")
+	}
+}
+
+func TestTopLevelScreenCompare(t *testing.T) {
+	t.Parallel()
+
+	pfxs := randomPrefixes(10_000)
+
+	plain := new(Table[int])
+	screened := new(Table[int])
+
+	for i, item := range pfxs {
+		plain.Insert(item.pfx, item.val)
+		screened.Insert(item.pfx, item.val)
+
+		// enable screen in the middle of the inserts
+		if i == len(pfxs)/2 {
+			screened.EnableTopLevelScreen()
+		}
+	}
+
+	// delete half of the prefixes, in random order
+	rand.Shuffle(len(pfxs), func(i, j int) {
+		pfxs[i], pfxs[j] = pfxs[j], pfxs[i]
+	})
+
+	for _, item := range pfxs[:len(pfxs)/2] {
+		plain.Delete(item.pfx)
+		screened.Delete(item.pfx)
+	}
+
+	// union and clone must keep the screen consistent
+	other := new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		other.Insert(item.pfx, item.val)
+	}
+
+	plain.Union(other)
+	screened.Union(other)
+	screened = screened.Clone()
+
+	for range 100_000 {
+		ip := randomAddr()
+
+		want := plain.Contains(ip)
+		got := screened.Contains(ip)
+
+		if got != want {
+			t.Fatalf("Contains(%s), screened: %v, plain: %v", ip, got, want)
+		}
+	}
+}
+
+func TestTopLevelScreenRefs(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.EnableTopLevelScreen()
+
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("10.1.0.0/16"), 2)
+	rt.Insert(mpp("::/0"), 3)
+	rt.Insert(mpp("2001:db8::/32"), 4)
+
+	// duplicates in a union are counted once
+	other := new(Table[int])
+	other.Insert(mpp("10.0.0.0/8"), 5)
+	other.Insert(mpp("::/0"), 6)
+	rt.Union(other)
+
+	rt.Delete(mpp("10.0.0.0/8"))
+	if !rt.MightContain(mpa("10.1.2.3")) {
+		t.Errorf("MightContain(10.1.2.3), bucket still covered by 10.1.0.0/16, expected true")
+	}
+
+	rt.Delete(mpp("10.1.0.0/16"))
+	if rt.MightContain(mpa("10.1.2.3")) {
+		t.Errorf("MightContain(10.1.2.3), bucket no longer covered, expected false")
+	}
+
+	rt.Delete(mpp("::/0"))
+	if rt.MightContain(mpa("fe80::1")) {
+		t.Errorf("MightContain(fe80::1), bucket no longer covered, expected false")
+	}
+	if !rt.MightContain(mpa("2001:db8::1")) {
+		t.Errorf("MightContain(2001:db8::1), bucket still covered, expected true")
+	}
+}
+
+func TestTopLevelScreenBuckets(t *testing.T) {
+	t.Parallel()
+
+	// many prefixes shorter than the buckets
+	var pfxs []netip.Prefix
+	for range 2_000 {
+		pfxs = append(pfxs,
+			netip.PrefixFrom(randomIP4(), rand.Intn(12)).Masked(),
+			netip.PrefixFrom(randomIP6(), rand.Intn(20)).Masked())
+	}
+
+	rt := new(Table[int])
+	rt.EnableTopLevelScreen()
+
+	// every bucket bit must match a trie descent
+	check := func() {
+		t.Helper()
+		for bucket := range uint(1 << screenBits4) {
+			pfx := netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(bucket)}), screenBits4)
+			if got, want := rt.screen.v4.Test(bucket), rt.OverlapsPrefix(pfx); got != want {
+				t.Fatalf("bucket %s, screen: %v, overlaps: %v", pfx, got, want)
+			}
+		}
+		for bucket := range uint(1 << screenBits6) {
+			pfx := netip.PrefixFrom(netip.AddrFrom16([16]byte{byte(bucket >> 8), byte(bucket)}), screenBits6)
+			if got, want := rt.screen.v6.Test(bucket), rt.OverlapsPrefix(pfx); got != want {
+				t.Fatalf("bucket %s, screen: %v, overlaps: %v", pfx, got, want)
+			}
+		}
+	}
+
+	for i, pfx := range pfxs {
+		rt.Insert(pfx, i)
+		if i%500 == 0 {
+			check()
+		}
+	}
+	check()
+
+	rand.Shuffle(len(pfxs), func(i, j int) {
+		pfxs[i], pfxs[j] = pfxs[j], pfxs[i]
+	})
+
+	for i, pfx := range pfxs {
+		rt.Delete(pfx)
+		if i%500 == 0 {
+			check()
+		}
+	}
+	check()
+}
+
+func BenchmarkTopLevelScreenDeleteShort(b *testing.B) {
+	for _, screened := range []bool{false, true} {
+		rt := new(Table[int])
+		if screened {
+			rt.EnableTopLevelScreen()
+		}
+
+		for _, item := range randomPrefixes(10_000) {
+			rt.Insert(item.pfx, item.val)
+		}
+
+		for _, pfx := range []netip.Prefix{mpp("0.0.0.0/0"), mpp("::/0")} {
+			b.Run(fmt.Sprintf("screened=%v/%s", screened, pfx), func(b *testing.B) {
+				for range b.N {
+					rt.Insert(pfx, 0)
+					rt.Delete(pfx)
+				}
+			})
+		}
+	}
+}
+
+func TestMightContain(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if rt.MightContain(mpa("10.0.0.1")) || rt.MightContain(mpa("2001:db8::1")) {
+		t.Errorf("MightContain, empty table, expected false")
+	}
+
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+
+	// without screen, only the empty address family is rejected
+	if !rt.MightContain(mpa("11.0.0.1")) {
+		t.Errorf("MightContain(11.0.0.1) without screen, expected true")
+	}
+	if rt.MightContain(mpa("2001:db8::1")) {
+		t.Errorf("MightContain(2001:db8::1), empty IPv6, expected false")
+	}
+
+	rt.EnableTopLevelScreen()
+	if rt.MightContain(mpa("11.0.0.1")) {
+		t.Errorf("MightContain(11.0.0.1) with screen, expected false")
+	}
+	if !rt.MightContain(mpa("10.0.0.1")) {
+		t.Errorf("MightContain(10.0.0.1) with screen, expected true")
+	}
+
+	rt.Delete(mpp("10.0.0.0/8"))
+	if rt.MightContain(mpa("10.0.0.1")) {
+		t.Errorf("MightContain(10.0.0.1) after delete, expected false")
+	}
+
+	if rt.MightContain(mpa("::")) || rt.MightContain(netip.Addr{}) {
+		t.Errorf("MightContain, expected false")
+	}
+
+	// no false negatives across inserts and deletes
+	pfxs := randomPrefixes(10_000)
+
+	for _, screened := range []bool{false, true} {
+		rt := new(Table[int])
+		if screened {
+			rt.EnableTopLevelScreen()
+		}
+
+		for _, item := range pfxs {
+			rt.Insert(item.pfx, item.val)
+		}
+		for _, item := range pfxs[:len(pfxs)/2] {
+			rt.Delete(item.pfx)
+		}
+
+		for range 100_000 {
+			ip := randomAddr()
+			if rt.Contains(ip) && !rt.MightContain(ip) {
+				t.Fatalf("MightContain(%s), screened: %v, false negative", ip, screened)
+			}
+		}
+	}
+}
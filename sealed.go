@@ -0,0 +1,334 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// SealedTable is an immutable, flattened copy of a [Table], see [Table.Seal].
+//
+// All nodes are stored in one slice and the children are referenced by
+// index instead of by pointer and interface. The bitsets have a fixed size
+// with precomputed ranks. This improves the cache locality for lookups.
+//
+// A SealedTable is safe for concurrent readers without any locking,
+// there are no methods to change it.
+type SealedTable[V any] struct {
+	// root4 at index 0, root6 at index 1
+	nodes []sealedNode
+
+	// prefix values, consecutive for each node
+	values []V
+
+	// child references, consecutive for each node,
+	// >= 0: index into nodes, < 0: bitwise negated index into leaves
+	kids []int32
+
+	// path compressed prefixes
+	leaves []leaf[V]
+
+	size4 int
+	size6 int
+}
+
+// sealedNode, flattened node with fixed size bitsets and precomputed ranks.
+type sealedNode struct {
+	prefixes [8]uint64
+	children [4]uint64
+
+	// popcount of all words below the word index
+	pfxRank [8]uint16
+	kidRank [4]uint8
+
+	// number of prefixes, fast skip for nodes without prefixes
+	pfxCount uint16
+
+	// offsets into values and kids
+	pfxBase uint32
+	kidBase uint32
+}
+
+// Seal returns an immutable, read-only copy of the table, optimized for
+// concurrent lookups with maximum throughput. The table itself is not changed
+// and later modifications of the table are not reflected in the sealed copy.
+//
+// The payload of type V is shallow copied, but if type V implements the
+// [Cloner] interface, the values are cloned.
+func (t *Table[V]) Seal() *SealedTable[V] {
+	s := &SealedTable[V]{
+		size4: t.size4,
+		size6: t.size6,
+	}
+
+	// reserve the root nodes at fixed indexes
+	s.nodes = make([]sealedNode, 2)
+
+	s.sealRec(&t.root4, 0)
+	s.sealRec(&t.root6, 1)
+
+	return s
+}
+
+// sealRec, flattens the node n at index i in depth first order.
+func (s *SealedTable[V]) sealRec(n *node[V], i int32) {
+	sn := sealedNode{
+		pfxBase: uint32(len(s.values)),
+		kidBase: uint32(len(s.kids)),
+	}
+
+	copy(sn.prefixes[:], n.prefixes.BitSet)
+	copy(sn.children[:], n.children.BitSet)
+
+	for w := 1; w < len(sn.pfxRank); w++ {
+		sn.pfxRank[w] = sn.pfxRank[w-1] + uint16(bits.OnesCount64(sn.prefixes[w-1]))
+	}
+	for w := 1; w < len(sn.kidRank); w++ {
+		sn.kidRank[w] = sn.kidRank[w-1] + uint8(bits.OnesCount64(sn.children[w-1]))
+	}
+
+	sn.pfxCount = uint16(n.prefixes.Len())
+
+	for _, val := range n.prefixes.Items {
+		s.values = append(s.values, cloneOrCopyValue(val))
+	}
+
+	// reserve the consecutive child references, set after descent
+	kidBase := len(s.kids)
+	for range n.children.Items {
+		s.kids = append(s.kids, 0)
+	}
+
+	s.nodes[i] = sn
+
+	for j, kid := range n.children.Items {
+		switch k := kid.(type) {
+		case *node[V]:
+			ci := int32(len(s.nodes))
+			s.nodes = append(s.nodes, sealedNode{})
+			s.kids[kidBase+j] = ci
+			s.sealRec(k, ci)
+		case *leaf[V]:
+			s.kids[kidBase+j] = ^int32(len(s.leaves))
+			s.leaves = append(s.leaves, leaf[V]{k.prefix, cloneOrCopyValue(k.value)})
+		}
+	}
+}
+
+// Size returns the prefix count.
+func (s *SealedTable[V]) Size() int {
+	return s.size4 + s.size6
+}
+
+// Contains does a route lookup for IP and
+// returns true if any route matched, or false if not.
+func (s *SealedTable[V]) Contains(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+
+	is4 := ip.Is4()
+	n := &s.nodes[rootIdx(is4)]
+
+	for _, octet := range ipAsOctets(ip, is4) {
+		// contains: any lpm match good enough, no backtracking needed
+		if n.pfxCount != 0 && n.lpmTest(hostIndex(uint(octet))) {
+			return true
+		}
+
+		if !n.kidTest(octet) {
+			return false
+		}
+
+		ref := s.kids[n.kidBase+n.kidRank0(octet)]
+		if ref < 0 {
+			return s.leaves[^ref].prefix.Contains(ip)
+		}
+
+		n = &s.nodes[ref]
+	}
+
+	panic("unreachable")
+}
+
+// Lookup does a route lookup (longest prefix match) for IP and
+// returns the associated value and true, or false if no route matched.
+func (s *SealedTable[V]) Lookup(ip netip.Addr) (val V, ok bool) {
+	if !ip.IsValid() {
+		return val, false
+	}
+
+	is4 := ip.Is4()
+	n := &s.nodes[rootIdx(is4)]
+
+	octets := ipAsOctets(ip, is4)
+
+	// stack of the traversed nodes for fast backtracking, if needed
+	stack := [maxTreeDepth]*sealedNode{}
+
+	var depth int
+	var octet byte
+
+LOOP:
+	for depth, octet = range octets {
+		stack[depth] = n
+
+		if !n.kidTest(octet) {
+			break LOOP
+		}
+
+		ref := s.kids[n.kidBase+n.kidRank0(octet)]
+		if ref < 0 {
+			// reached a path compressed prefix, stop traversing
+			if lf := &s.leaves[^ref]; lf.prefix.Contains(ip) {
+				return lf.value, true
+			}
+			break LOOP
+		}
+
+		n = &s.nodes[ref]
+	}
+
+	// start backtracking, unwind the stack, bounds check eliminated
+	for ; depth >= 0 && depth < len(stack) && depth < len(octets); depth-- {
+		n = stack[depth]
+
+		// longest prefix match, skip if node has no prefixes
+		if n.pfxCount == 0 {
+			continue
+		}
+
+		if topIdx, ok := n.lpmTop(hostIndex(uint(octets[depth]))); ok {
+			return s.values[n.pfxBase+n.pfxRank0(topIdx)], true
+		}
+	}
+
+	return val, false
+}
+
+// LookupPrefixLPM is similar to [SealedTable.Lookup], but it returns
+// the lpm prefix in addition to value,ok, see [Table.LookupPrefixLPM].
+func (s *SealedTable[V]) LookupPrefixLPM(pfx netip.Prefix) (lpm netip.Prefix, val V, ok bool) {
+	if !pfx.IsValid() {
+		return lpm, val, false
+	}
+
+	ip := pfx.Addr()
+	bits := pfx.Bits()
+	is4 := ip.Is4()
+
+	n := &s.nodes[rootIdx(is4)]
+
+	lastIdx, lastBits := lastOctetIdxAndBits(bits)
+
+	octets := ipAsOctets(ip, is4)
+	octets = octets[:lastIdx+1]
+
+	// mask the last octet from IP
+	octets[lastIdx] &= netMask(lastBits)
+
+	stack := [maxTreeDepth]*sealedNode{}
+
+	var depth int
+	var octet byte
+
+LOOP:
+	for depth, octet = range octets {
+		stack[depth] = n
+
+		if !n.kidTest(octet) {
+			break LOOP
+		}
+
+		ref := s.kids[n.kidBase+n.kidRank0(octet)]
+		if ref < 0 {
+			// reached a path compressed prefix, stop traversing
+			if lf := &s.leaves[^ref]; lf.prefix.Contains(ip) && lf.prefix.Bits() <= bits {
+				return lf.prefix, lf.value, true
+			}
+			break LOOP
+		}
+
+		n = &s.nodes[ref]
+	}
+
+	// start backtracking, unwind the stack, bounds check eliminated
+	for ; depth >= 0 && depth < len(stack) && depth < len(octets); depth-- {
+		n = stack[depth]
+
+		// longest prefix match, skip if node has no prefixes
+		if n.pfxCount == 0 {
+			continue
+		}
+
+		// only the lastOctet may have a different prefix len
+		// all others are just host routes
+		var idx uint
+		if depth == lastIdx {
+			idx = pfxToIdx(octets[depth], lastBits)
+		} else {
+			idx = hostIndex(uint(octets[depth]))
+		}
+
+		if topIdx, ok := n.lpmTop(idx); ok {
+			val = s.values[n.pfxBase+n.pfxRank0(topIdx)]
+
+			// calculate the pfxLen from depth and top idx
+			pfxLen := depth*strideLen + int(baseIdxLookupTbl[topIdx].pfxLen)
+
+			lpm, _ = ip.Prefix(pfxLen)
+			return lpm, val, true
+		}
+	}
+
+	return lpm, val, false
+}
+
+// rootIdx, index of the root node for ip version.
+func rootIdx(is4 bool) int {
+	if is4 {
+		return 0
+	}
+	return 1
+}
+
+// kidTest, test if the child at octet is set.
+func (n *sealedNode) kidTest(octet byte) bool {
+	return n.children[octet>>6&3]&(1<<(octet&63)) != 0
+}
+
+// kidRank0, rank of the child at octet, the child must be set.
+func (n *sealedNode) kidRank0(octet byte) uint32 {
+	w := octet >> 6 & 3
+	return uint32(n.kidRank[w]) + uint32(bits.OnesCount64(n.children[w]<<(63-octet&63))) - 1
+}
+
+// pfxRank0, rank of the prefix at idx, the prefix must be set.
+func (n *sealedNode) pfxRank0(idx uint) uint32 {
+	w := idx >> 6 & 7
+	return uint32(n.pfxRank[w]) + uint32(bits.OnesCount64(n.prefixes[w]<<(63-idx&63))) - 1
+}
+
+// lpmTest, any prefix of the backtracking sequence of idx is set.
+func (n *sealedNode) lpmTest(idx uint) bool {
+	tbl := lpmLookupTbl[idx]
+	for i := range tbl {
+		if n.prefixes[i&7]&tbl[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lpmTop, the longest prefix of the backtracking sequence of idx.
+func (n *sealedNode) lpmTop(idx uint) (top uint, ok bool) {
+	tbl := lpmLookupTbl[idx]
+	for i := len(tbl) - 1; i >= 0; i-- {
+		if word := n.prefixes[i&7] & tbl[i]; word != 0 {
+			return uint(i<<6+bits.Len64(word)) - 1, true
+		}
+	}
+	return 0, false
+}
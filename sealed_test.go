@@ -0,0 +1,191 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+func TestSealedEmpty(t *testing.T) {
+	t.Parallel()
+
+	s := new(Table[int]).Seal()
+
+	if s.Size() != 0 {
+		t.Errorf("Seal of empty table, expected size 0, got %d", s.Size())
+	}
+	if s.Contains(randomAddr()) {
+		t.Errorf("Seal of empty table, Contains, expected false")
+	}
+	if _, ok := s.Lookup(randomAddr()); ok {
+		t.Errorf("Seal of empty table, Lookup, expected false")
+	}
+	if _, _, ok := s.LookupPrefixLPM(randomPrefix()); ok {
+		t.Errorf("Seal of empty table, LookupPrefixLPM, expected false")
+	}
+
+	// invalid input
+	if s.Contains(netip.Addr{}) {
+		t.Errorf("Contains(invalid), expected false")
+	}
+	if _, ok := s.Lookup(netip.Addr{}); ok {
+		t.Errorf("Lookup(invalid), expected false")
+	}
+	if _, _, ok := s.LookupPrefixLPM(netip.Prefix{}); ok {
+		t.Errorf("LookupPrefixLPM(invalid), expected false")
+	}
+}
+
+func TestSealedCompare(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+	rt.Insert(mpp("0.0.0.0/0"), -4)
+	rt.Insert(mpp("::/0"), -6)
+
+	s := rt.Seal()
+
+	if s.Size() != rt.Size() {
+		t.Fatalf("Seal, expected size %d, got %d", rt.Size(), s.Size())
+	}
+
+	for range 100_000 {
+		ip := randomAddr()
+
+		if got, want := s.Contains(ip), rt.Contains(ip); got != want {
+			t.Fatalf("Contains(%s), expected %v, got %v", ip, want, got)
+		}
+
+		gotVal, gotOK := s.Lookup(ip)
+		wantVal, wantOK := rt.Lookup(ip)
+		if gotVal != wantVal || gotOK != wantOK {
+			t.Fatalf("Lookup(%s), expected (%d, %v), got (%d, %v)", ip, wantVal, wantOK, gotVal, gotOK)
+		}
+	}
+
+	for range 100_000 {
+		pfx := randomPrefix()
+
+		gotLPM, gotVal, gotOK := s.LookupPrefixLPM(pfx)
+		wantLPM, wantVal, wantOK := rt.LookupPrefixLPM(pfx)
+		if gotLPM != wantLPM || gotVal != wantVal || gotOK != wantOK {
+			t.Fatalf("LookupPrefixLPM(%s), expected (%s, %d, %v), got (%s, %d, %v)",
+				pfx, wantLPM, wantVal, wantOK, gotLPM, gotVal, gotOK)
+		}
+	}
+
+	// the sealed table is a snapshot
+	rt.Delete(mpp("0.0.0.0/0"))
+	if !s.Contains(mpa("0.0.0.1")) {
+		t.Errorf("Seal, snapshot changed by later delete")
+	}
+}
+
+func TestSealedConcurrentReaders(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+	s := rt.Seal()
+
+	// the shared prng is not safe for concurrent use
+	addrs := make([]netip.Addr, 10_000)
+	for i := range addrs {
+		addrs[i] = randomAddr()
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, ip := range addrs {
+				s.Lookup(ip)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkSealedFullMatch(b *testing.B) {
+	var rt Table[int]
+
+	for i, route := range routes {
+		rt.Insert(route.CIDR, i)
+	}
+	s := rt.Seal()
+
+	for _, fam := range []string{"V4", "V6"} {
+		rndIP := randomIP4
+		if fam == "V6" {
+			rndIP = randomIP6
+		}
+
+		// find a random match
+		var ip netip.Addr
+		for {
+			ip = rndIP()
+			if _, ok := rt.Lookup(ip); ok {
+				break
+			}
+		}
+
+		b.Run(fam+"/Table/Contains", func(b *testing.B) {
+			for range b.N {
+				okSink = rt.Contains(ip)
+			}
+		})
+
+		b.Run(fam+"/Sealed/Contains", func(b *testing.B) {
+			for range b.N {
+				okSink = s.Contains(ip)
+			}
+		})
+
+		b.Run(fam+"/Table/Lookup", func(b *testing.B) {
+			for range b.N {
+				intSink, okSink = rt.Lookup(ip)
+			}
+		})
+
+		b.Run(fam+"/Sealed/Lookup", func(b *testing.B) {
+			for range b.N {
+				intSink, okSink = s.Lookup(ip)
+			}
+		})
+	}
+}
+
+func BenchmarkSealedRandomLookup(b *testing.B) {
+	var rt Table[int]
+
+	for i, route := range routes {
+		rt.Insert(route.CIDR, i)
+	}
+	s := rt.Seal()
+
+	probes := make([]netip.Addr, 1<<16)
+	for i := range probes {
+		probes[i] = randomAddr()
+	}
+
+	b.Run("Table", func(b *testing.B) {
+		for i := range b.N {
+			intSink, okSink = rt.Lookup(probes[i&(len(probes)-1)])
+		}
+	})
+
+	b.Run("Sealed", func(b *testing.B) {
+		for i := range b.N {
+			intSink, okSink = s.Lookup(probes[i&(len(probes)-1)])
+		}
+	})
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+var (
+	// ErrInvalidPrefix is returned by the E-variants of the
+	// table methods in strict mode for an invalid prefix.
+	ErrInvalidPrefix = errors.New("bart: invalid prefix")
+
+	// ErrInvalidAddr is returned by the E-variants of the
+	// table methods in strict mode for an invalid address.
+	ErrInvalidAddr = errors.New("bart: invalid address")
+
+	// ErrHostBits is returned by [ValidateBatchStrict] for a prefix
+	// with host bits set.
+	ErrHostBits = errors.New("bart: host bits set")
+)
+
+// SetStrict enables or disables the strict mode of the table.
+//
+// By default, the table methods silently ignore invalid (zero value)
+// prefixes and addresses. In strict mode the E-variants of the methods,
+// e.g. [Table.InsertE] or [Table.LookupE], return [ErrInvalidPrefix] or
+// [ErrInvalidAddr] instead, to catch garbage input. Without strict mode
+// the E-variants behave like the methods without the E-suffix and
+// always return a nil error. No method panics in either mode.
+func (t *Table[V]) SetStrict(strict bool) {
+	t.strict = strict
+}
+
+// InsertE is like [Table.Insert], but returns [ErrInvalidPrefix] in strict mode.
+func (t *Table[V]) InsertE(pfx netip.Prefix, val V) error {
+	if err := t.checkPrefix(pfx); err != nil {
+		return err
+	}
+
+	t.Insert(pfx, val)
+	return nil
+}
+
+// DeleteE is like [Table.Delete], but returns [ErrInvalidPrefix] in strict mode.
+func (t *Table[V]) DeleteE(pfx netip.Prefix) error {
+	if err := t.checkPrefix(pfx); err != nil {
+		return err
+	}
+
+	t.Delete(pfx)
+	return nil
+}
+
+// GetE is like [Table.Get], but returns [ErrInvalidPrefix] in strict mode.
+func (t *Table[V]) GetE(pfx netip.Prefix) (val V, ok bool, err error) {
+	if err = t.checkPrefix(pfx); err != nil {
+		return val, false, err
+	}
+
+	val, ok = t.Get(pfx)
+	return val, ok, nil
+}
+
+// LookupE is like [Table.Lookup], but returns [ErrInvalidAddr] in strict mode.
+func (t *Table[V]) LookupE(ip netip.Addr) (val V, ok bool, err error) {
+	if t.strict && !ip.IsValid() {
+		return val, false, ErrInvalidAddr
+	}
+
+	val, ok = t.Lookup(ip)
+	return val, ok, nil
+}
+
+// LookupPrefixE is like [Table.LookupPrefix], but returns [ErrInvalidPrefix] in strict mode.
+func (t *Table[V]) LookupPrefixE(pfx netip.Prefix) (val V, ok bool, err error) {
+	if err = t.checkPrefix(pfx); err != nil {
+		return val, false, err
+	}
+
+	val, ok = t.LookupPrefix(pfx)
+	return val, ok, nil
+}
+
+// checkPrefix, returns ErrInvalidPrefix for invalid prefixes in strict mode.
+func (t *Table[V]) checkPrefix(pfx netip.Prefix) error {
+	if t.strict && !pfx.IsValid() {
+		return ErrInvalidPrefix
+	}
+	return nil
+}
+
+// BatchError is the error for a single item of a batch, see [ValidateBatch].
+type BatchError struct {
+	// Index of the item in the input batch.
+	Index int
+
+	// Prefix of the item, as given.
+	Prefix netip.Prefix
+
+	// Err is [ErrInvalidPrefix] or [ErrHostBits].
+	Err error
+}
+
+// Error implements the error interface.
+func (e BatchError) Error() string {
+	return fmt.Sprintf("item %d: %s: %v", e.Index, e.Prefix, e.Err)
+}
+
+// Unwrap returns the underlying error, for [errors.Is].
+func (e BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateBatch checks and canonicalizes the prefixes of items before
+// insertion, e.g. for bulk loaders ingesting the good rows and reporting
+// the bad ones.
+//
+// Items with an invalid prefix are reported as [BatchError] with
+// their index in items, the other items are returned in input order
+// with the prefix masked. The whole batch is always processed,
+// items is not changed.
+func ValidateBatch[V any](items []Entry[V]) (valid []Entry[V], errs []BatchError) {
+	return validateBatch(items, false)
+}
+
+// ValidateBatchStrict is like [ValidateBatch], but items
+// with host bits set are also reported, with [ErrHostBits].
+func ValidateBatchStrict[V any](items []Entry[V]) (valid []Entry[V], errs []BatchError) {
+	return validateBatch(items, true)
+}
+
+func validateBatch[V any](items []Entry[V], strict bool) (valid []Entry[V], errs []BatchError) {
+	valid = make([]Entry[V], 0, len(items))
+
+	for i, item := range items {
+		pfx := item.Prefix
+
+		switch {
+		case !pfx.IsValid():
+			errs = append(errs, BatchError{Index: i, Prefix: pfx, Err: ErrInvalidPrefix})
+		case strict && pfx != pfx.Masked():
+			errs = append(errs, BatchError{Index: i, Prefix: pfx, Err: ErrHostBits})
+		default:
+			valid = append(valid, Entry[V]{Prefix: pfx.Masked(), Value: item.Value})
+		}
+	}
+
+	return valid, errs
+}
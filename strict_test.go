@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	t.Parallel()
+
+	var zeroPfx netip.Prefix
+	var zeroIP netip.Addr
+
+	tbl := new(Table[int])
+
+	// default, no errors
+	if err := tbl.InsertE(zeroPfx, 1); err != nil {
+		t.Errorf("InsertE, not strict, expected nil error, got %v", err)
+	}
+	if _, _, err := tbl.LookupE(zeroIP); err != nil {
+		t.Errorf("LookupE, not strict, expected nil error, got %v", err)
+	}
+
+	tbl.SetStrict(true)
+
+	if err := tbl.InsertE(zeroPfx, 1); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("InsertE, expected ErrInvalidPrefix, got %v", err)
+	}
+	if err := tbl.DeleteE(zeroPfx); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("DeleteE, expected ErrInvalidPrefix, got %v", err)
+	}
+	if _, _, err := tbl.GetE(zeroPfx); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("GetE, expected ErrInvalidPrefix, got %v", err)
+	}
+	if _, _, err := tbl.LookupPrefixE(zeroPfx); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("LookupPrefixE, expected ErrInvalidPrefix, got %v", err)
+	}
+	if _, _, err := tbl.LookupE(zeroIP); !errors.Is(err, ErrInvalidAddr) {
+		t.Errorf("LookupE, expected ErrInvalidAddr, got %v", err)
+	}
+
+	// valid input in strict mode
+	pfx := mpp("10.0.0.0/8")
+	if err := tbl.InsertE(pfx, 8); err != nil {
+		t.Fatalf("InsertE(%s), unexpected error: %v", pfx, err)
+	}
+	if val, ok, err := tbl.GetE(pfx); err != nil || !ok || val != 8 {
+		t.Errorf("GetE(%s), expected (8, true, nil), got (%d, %v, %v)", pfx, val, ok, err)
+	}
+	if val, ok, err := tbl.LookupE(mpa("10.1.2.3")); err != nil || !ok || val != 8 {
+		t.Errorf("LookupE, expected (8, true, nil), got (%d, %v, %v)", val, ok, err)
+	}
+	if val, ok, err := tbl.LookupPrefixE(mpp("10.1.0.0/16")); err != nil || !ok || val != 8 {
+		t.Errorf("LookupPrefixE, expected (8, true, nil), got (%d, %v, %v)", val, ok, err)
+	}
+
+	// strict mode is cloned
+	if err := tbl.Clone().InsertE(zeroPfx, 1); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("InsertE on clone, expected ErrInvalidPrefix, got %v", err)
+	}
+
+	if err := tbl.DeleteE(pfx); err != nil {
+		t.Fatalf("DeleteE(%s), unexpected error: %v", pfx, err)
+	}
+	if tbl.Size() != 0 {
+		t.Errorf("DeleteE, expected empty table, got size %d", tbl.Size())
+	}
+}
+
+func TestValidateBatch(t *testing.T) {
+	t.Parallel()
+
+	items := []Entry[int]{
+		{mpp("10.0.0.0/8"), 0},
+		{netip.Prefix{}, 1},
+		{netip.MustParsePrefix("10.1.2.3/16"), 2},
+		{netip.MustParsePrefix("2001:db8::1/32"), 3},
+		{mpp("::/0"), 4},
+	}
+
+	valid, errs := ValidateBatch(items)
+
+	wantValid := []Entry[int]{
+		{mpp("10.0.0.0/8"), 0},
+		{mpp("10.1.0.0/16"), 2},
+		{mpp("2001:db8::/32"), 3},
+		{mpp("::/0"), 4},
+	}
+	if !reflect.DeepEqual(valid, wantValid) {
+		t.Errorf("ValidateBatch, valid\ngot:  %v\nwant: %v", valid, wantValid)
+	}
+	if len(errs) != 1 || errs[0].Index != 1 || !errors.Is(errs[0], ErrInvalidPrefix) {
+		t.Errorf("ValidateBatch, expected one ErrInvalidPrefix at index 1, got %v", errs)
+	}
+
+	valid, errs = ValidateBatchStrict(items)
+
+	wantValid = []Entry[int]{
+		{mpp("10.0.0.0/8"), 0},
+		{mpp("::/0"), 4},
+	}
+	if !reflect.DeepEqual(valid, wantValid) {
+		t.Errorf("ValidateBatchStrict, valid\ngot:  %v\nwant: %v", valid, wantValid)
+	}
+
+	wantIdx := []int{1, 2, 3}
+	if len(errs) != len(wantIdx) {
+		t.Fatalf("ValidateBatchStrict, expected %d errors, got %v", len(wantIdx), errs)
+	}
+	for i, err := range errs {
+		if err.Index != wantIdx[i] || err.Prefix != items[err.Index].Prefix {
+			t.Errorf("ValidateBatchStrict, error %d, got %v", i, err)
+		}
+	}
+	if !errors.Is(errs[1], ErrHostBits) {
+		t.Errorf("ValidateBatchStrict, expected ErrHostBits, got %v", errs[1].Err)
+	}
+	if got, want := errs[1].Error(), "item 2: 10.1.2.3/16: bart: host bits set"; got != want {
+		t.Errorf("BatchError.Error, got %q, want %q", got, want)
+	}
+
+	// the input is not changed
+	if items[2].Prefix != netip.MustParsePrefix("10.1.2.3/16") {
+		t.Errorf("ValidateBatch changed the input")
+	}
+}
@@ -19,7 +19,13 @@
 package bart
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
 	"net/netip"
+	"slices"
 )
 
 // Table is an IPv4 and IPv6 routing table with payload V.
@@ -40,6 +46,18 @@ type Table[V any] struct {
 	// the number of prefixes in the routing table
 	size4 int
 	size6 int
+
+	// optional occupancy bitmap, see EnableTopLevelScreen
+	screen *topLevelScreen
+
+	// table metadata, see SetMeta
+	meta map[string]any
+
+	// strict mode, see SetStrict
+	strict bool
+
+	// optional mutation hook, see OnChange
+	onChange func(op ChangeOp, pfx netip.Prefix, oldVal V, newVal V)
 }
 
 // rootNodeByVersion, root node getter for ip version.
@@ -51,6 +69,23 @@ func (t *Table[V]) rootNodeByVersion(is4 bool) *node[V] {
 	return &t.root6
 }
 
+// Family reports the address family of x, which must be a [netip.Addr]
+// or [netip.Prefix], with the same dispatch as all methods of the table.
+// The result ok is false for invalid addresses or prefixes and for all
+// other types.
+//
+// IPv4-mapped IPv6 addresses and prefixes are IPv6, they are not unmapped.
+func Family(x any) (is4 bool, ok bool) {
+	switch v := x.(type) {
+	case netip.Addr:
+		return v.Is4(), v.IsValid()
+	case netip.Prefix:
+		return v.Addr().Is4(), v.IsValid()
+	default:
+		return false, false
+	}
+}
+
 // Insert adds pfx to the tree, with given val.
 // If pfx is already present in the tree, its value is set to val.
 func (t *Table[V]) Insert(pfx netip.Prefix, val V) {
@@ -58,6 +93,12 @@ func (t *Table[V]) Insert(pfx netip.Prefix, val V) {
 		return
 	}
 
+	// the hook needs the old value, insert by update
+	if t.onChange != nil {
+		t.Update(pfx, func(V, bool) V { return val })
+		return
+	}
+
 	// canonicalize prefix
 	pfx = pfx.Masked()
 
@@ -70,6 +111,48 @@ func (t *Table[V]) Insert(pfx netip.Prefix, val V) {
 
 	// true insert, update size
 	t.sizeUpdate(is4, 1)
+	t.screenInsert(pfx)
+}
+
+// InsertCopy is like [Table.Insert], but stores copyFn(val) instead of val,
+// e.g. for slice or map payloads that must not alias the caller's value.
+//
+// If copyFn is nil, val is cloned if V implements the [Cloner] interface,
+// otherwise it is just copied as in [Table.Insert].
+func (t *Table[V]) InsertCopy(pfx netip.Prefix, val V, copyFn func(V) V) {
+	if !pfx.IsValid() {
+		return
+	}
+
+	if copyFn == nil {
+		copyFn = cloneOrCopyValue[V]
+	}
+
+	t.Insert(pfx, copyFn(val))
+}
+
+// InsertDedup is like [Table.Insert], but reports whether the table changed.
+// If pfx is already present with an equal value, the stored value is not
+// overwritten and false is returned, e.g. for idempotent loaders.
+//
+// For payloads not comparable with == use [Table.InsertDedupFunc].
+func InsertDedup[V comparable](t *Table[V], pfx netip.Prefix, val V) bool {
+	return t.InsertDedupFunc(pfx, val, func(a, b V) bool { return a == b })
+}
+
+// InsertDedupFunc is like [InsertDedup], but the values are compared with eq.
+func (t *Table[V]) InsertDedupFunc(pfx netip.Prefix, val V, eq func(V, V) bool) bool {
+	if !pfx.IsValid() {
+		return false
+	}
+
+	// no mutation at all for an equal value, not even an update hook
+	if old, ok := t.Get(pfx); ok && eq(old, val) {
+		return false
+	}
+
+	t.Insert(pfx, val)
+	return true
 }
 
 // Update or set the value at pfx with a callback function.
@@ -83,6 +166,32 @@ func (t *Table[V]) Update(pfx netip.Prefix, cb func(val V, ok bool) V) (newVal V
 		return zero
 	}
 
+	if t.onChange == nil {
+		return t.update(pfx, cb)
+	}
+
+	// record the old value for the hook
+	var oldVal V
+	var exists bool
+
+	newVal = t.update(pfx, func(val V, ok bool) V {
+		oldVal, exists = val, ok
+		return cb(val, ok)
+	})
+
+	op := ChangeInsert
+	if exists {
+		op = ChangeUpdate
+	}
+	t.notify(op, pfx.Masked(), oldVal, newVal)
+
+	return newVal
+}
+
+// update, see Update, pfx must be valid.
+func (t *Table[V]) update(pfx netip.Prefix, cb func(val V, ok bool) V) (newVal V) {
+	var zero V
+
 	// canonicalize prefix
 	pfx = pfx.Masked()
 
@@ -105,6 +214,7 @@ func (t *Table[V]) Update(pfx netip.Prefix, cb func(val V, ok bool) V) (newVal V
 			newVal, exists := n.prefixes.UpdateAt(pfxToIdx(octet, lastBits), cb)
 			if !exists {
 				t.sizeUpdate(is4, 1)
+				t.screenInsert(pfx)
 			}
 			return newVal
 		}
@@ -117,6 +227,7 @@ func (t *Table[V]) Update(pfx netip.Prefix, cb func(val V, ok bool) V) (newVal V
 			newVal := cb(zero, false)
 			n.children.InsertAt(addr, &leaf[V]{pfx, newVal})
 			t.sizeUpdate(is4, 1)
+			t.screenInsert(pfx)
 			return newVal
 		}
 
@@ -159,6 +270,8 @@ func (t *Table[V]) GetAndDelete(pfx netip.Prefix) (val V, ok bool) {
 }
 
 func (t *Table[V]) getAndDelete(pfx netip.Prefix) (val V, ok bool) {
+	var zero V
+
 	if !pfx.IsValid() {
 		return val, false
 	}
@@ -196,6 +309,8 @@ func (t *Table[V]) getAndDelete(pfx netip.Prefix) (val V, ok bool) {
 
 			t.sizeUpdate(is4, -1)
 			n.purgeAndCompress(stack[:depth], octets, is4)
+			t.screenDelete(pfx)
+			t.notify(ChangeDelete, pfx, val, zero)
 			return val, ok
 		}
 
@@ -221,6 +336,8 @@ func (t *Table[V]) getAndDelete(pfx netip.Prefix) (val V, ok bool) {
 
 			t.sizeUpdate(is4, -1)
 			n.purgeAndCompress(stack[:depth], octets, is4)
+			t.screenDelete(pfx)
+			t.notify(ChangeDelete, pfx, k.value, zero)
 
 			return k.value, true
 		}
@@ -229,6 +346,105 @@ func (t *Table[V]) getAndDelete(pfx netip.Prefix) (val V, ok bool) {
 	panic("unreachable")
 }
 
+// DeleteAll deletes a batch of prefixes, e.g. for mass withdrawals.
+// Invalid and missing prefixes are ignored.
+//
+// The resulting table is the same as deleting each prefix individually,
+// but the purging and path compression of the trie nodes is deferred
+// to a single pass after all deletions. Returns the number of deleted
+// prefixes and the number of trie nodes freed by the compaction.
+func (t *Table[V]) DeleteAll(pfxs []netip.Prefix) (deleted int, nodesFreed int) {
+	var zero V
+
+	// only deletions leaving a compressible node behind need the compaction pass
+	var dirty4, dirty6 []netip.Prefix
+
+	for _, pfx := range pfxs {
+		if !pfx.IsValid() {
+			continue
+		}
+
+		// canonicalize prefix
+		pfx = pfx.Masked()
+
+		val, ok, dirty := t.deleteWithoutCompress(pfx)
+		if !ok {
+			continue
+		}
+
+		deleted++
+		t.screenDelete(pfx)
+		t.notify(ChangeDelete, pfx, val, zero)
+
+		if !dirty {
+			continue
+		}
+
+		if pfx.Addr().Is4() {
+			dirty4 = append(dirty4, pfx)
+		} else {
+			dirty6 = append(dirty6, pfx)
+		}
+	}
+
+	slices.SortFunc(dirty4, cmpPrefix)
+	slices.SortFunc(dirty6, cmpPrefix)
+
+	nodesFreed += t.root4.compactRec(dirty4, 0, true)
+	nodesFreed += t.root6.compactRec(dirty6, 0, false)
+
+	return deleted, nodesFreed
+}
+
+// deleteWithoutCompress deletes the canonical prefix, but leaves
+// the purge and path compression of the trie to the caller.
+// Returns the deleted value and reports whether the prefix was deleted
+// and whether the node of the deleted prefix must be purged or compressed.
+func (t *Table[V]) deleteWithoutCompress(pfx netip.Prefix) (val V, ok, dirty bool) {
+	ip := pfx.Addr()
+	is4 := ip.Is4()
+	bits := pfx.Bits()
+
+	n := t.rootNodeByVersion(is4)
+
+	lastIdx, lastBits := lastOctetIdxAndBits(bits)
+
+	octets := ipAsOctets(ip, is4)
+	octets = octets[:lastIdx+1]
+
+	for depth, octet := range octets {
+		if depth == lastIdx {
+			if val, ok = n.prefixes.DeleteAt(pfxToIdx(octet, lastBits)); !ok {
+				return val, false, false
+			}
+
+			t.sizeUpdate(is4, -1)
+			return val, true, depth > 0 && n.isCompressible()
+		}
+
+		addr := uint(octet)
+		if !n.children.Test(addr) {
+			return val, false, false
+		}
+
+		switch k := n.children.MustGet(addr).(type) {
+		case *node[V]:
+			n = k
+		case *leaf[V]:
+			if k.prefix != pfx {
+				return val, false, false
+			}
+
+			n.children.DeleteAt(addr)
+
+			t.sizeUpdate(is4, -1)
+			return k.value, true, depth > 0 && n.isCompressible()
+		}
+	}
+
+	panic("unreachable")
+}
+
 // Get returns the associated payload for prefix and true, or false if
 // prefix is not set in the routing table.
 func (t *Table[V]) Get(pfx netip.Prefix) (val V, ok bool) {
@@ -283,6 +499,208 @@ LOOP:
 	return zero, false
 }
 
+// GetOr is like [Table.Get], but returns def if pfx is not set.
+func (t *Table[V]) GetOr(pfx netip.Prefix, def V) V {
+	if val, ok := t.Get(pfx); ok {
+		return val
+	}
+	return def
+}
+
+// DefaultRoute4 returns the payload of the IPv4 default route 0.0.0.0/0
+// and true, or false if the default route is not set in the routing table.
+//
+// The default route is always stored as the first prefix in the root node,
+// it matches every IPv4 address and is a supernet of every IPv4 prefix.
+func (t *Table[V]) DefaultRoute4() (val V, ok bool) {
+	return t.root4.prefixes.Get(pfxToIdx(0, 0))
+}
+
+// DefaultRoute6 returns the payload of the IPv6 default route ::/0
+// and true, or false if the default route is not set in the routing table.
+//
+// The default route is always stored as the first prefix in the root node,
+// it matches every IPv6 address and is a supernet of every IPv6 prefix.
+func (t *Table[V]) DefaultRoute6() (val V, ok bool) {
+	return t.root6.prefixes.Get(pfxToIdx(0, 0))
+}
+
+// SwapValues exchanges the payloads of the prefixes a and b.
+// It returns false and leaves the table unchanged
+// if either of the prefixes is not set in the routing table.
+func (t *Table[V]) SwapValues(a, b netip.Prefix) bool {
+	pa := t.valuePtr(a)
+	if pa == nil {
+		return false
+	}
+
+	pb := t.valuePtr(b)
+	if pb == nil {
+		return false
+	}
+
+	*pa, *pb = *pb, *pa
+
+	if t.onChange != nil {
+		t.notify(ChangeUpdate, a.Masked(), *pb, *pa)
+		t.notify(ChangeUpdate, b.Masked(), *pa, *pb)
+	}
+
+	return true
+}
+
+// Deaggregate replaces the stored prefix pfx with its two more-specific
+// halves carrying the same value, e.g. a /24 is split into two /25s.
+// It returns false and leaves the table unchanged, if pfx is not set in the
+// routing table or is already a host route.
+//
+// Lookups for all addresses are unchanged after deaggregation, an already
+// stored half keeps its own value.
+func (t *Table[V]) Deaggregate(pfx netip.Prefix) bool {
+	if !pfx.IsValid() {
+		return false
+	}
+
+	// canonicalize the prefix
+	pfx = pfx.Masked()
+
+	if pfx.Bits() == pfx.Addr().BitLen() {
+		return false
+	}
+
+	val, ok := t.GetAndDelete(pfx)
+	if !ok {
+		return false
+	}
+
+	lo, _ := pfx.Addr().Prefix(pfx.Bits() + 1)
+	hi, _ := lastAddr(pfx).Prefix(pfx.Bits() + 1)
+
+	// an already stored half keeps its value, no update event for the hook
+	for _, half := range [2]netip.Prefix{lo, hi} {
+		if _, ok := t.Get(half); !ok {
+			t.Insert(half, val)
+		}
+	}
+
+	return true
+}
+
+// AggregateSiblings performs one pass of pairwise sibling merges, two adjacent
+// halves with equal values, as reported by eq, are replaced by their parent
+// prefix, e.g. two /25s are merged into one /24. It returns the number of
+// merges. This is the inverse of [Table.Deaggregate].
+//
+// Lookups for all addresses are unchanged, a stored parent is completely
+// shadowed by both halves and gets overwritten. Call it repeatedly until
+// it returns 0 to reach the fixpoint.
+func (t *Table[V]) AggregateSiblings(eq func(V, V) bool) int {
+	var parents []netip.Prefix
+
+	t.All()(func(pfx netip.Prefix, val V) bool {
+		if pfx.Bits() == 0 {
+			return true
+		}
+
+		// only the lower half starts the pair
+		parent, _ := pfx.Addr().Prefix(pfx.Bits() - 1)
+		if parent.Addr() != pfx.Addr() {
+			return true
+		}
+
+		sibling, _ := lastAddr(parent).Prefix(pfx.Bits())
+		if sval, ok := t.Get(sibling); ok && eq(val, sval) {
+			parents = append(parents, parent)
+		}
+		return true
+	})
+
+	// longest parents first, merged parents may invalidate shorter pairs
+	slices.SortFunc(parents, func(a, b netip.Prefix) int {
+		return b.Bits() - a.Bits()
+	})
+
+	merges := 0
+	for _, parent := range parents {
+		lo, _ := parent.Addr().Prefix(parent.Bits() + 1)
+		hi, _ := lastAddr(parent).Prefix(parent.Bits() + 1)
+
+		// recheck, values may have changed by previous merges
+		loVal, ok := t.Get(lo)
+		if !ok {
+			continue
+		}
+		hiVal, ok := t.Get(hi)
+		if !ok || !eq(loVal, hiVal) {
+			continue
+		}
+
+		t.Delete(lo)
+		t.Delete(hi)
+		t.Insert(parent, loVal)
+		merges++
+	}
+
+	return merges
+}
+
+// valuePtr returns a pointer to the payload slot for prefix,
+// or nil if prefix is not set in the routing table.
+//
+// The pointer is only valid until the next mutation of the table.
+func (t *Table[V]) valuePtr(pfx netip.Prefix) *V {
+	if !pfx.IsValid() {
+		return nil
+	}
+
+	// canonicalize the prefix
+	pfx = pfx.Masked()
+
+	// values derived from pfx
+	ip := pfx.Addr()
+	is4 := ip.Is4()
+	bits := pfx.Bits()
+
+	n := t.rootNodeByVersion(is4)
+
+	lastIdx, lastBits := lastOctetIdxAndBits(bits)
+
+	octets := ipAsOctets(ip, is4)
+	octets = octets[:lastIdx+1]
+
+	// find the trie node
+	for depth, octet := range octets {
+		if depth == lastIdx {
+			idx := pfxToIdx(octet, lastBits)
+			if !n.prefixes.Test(idx) {
+				return nil
+			}
+			return &n.prefixes.Items[n.prefixes.Rank0(idx)]
+		}
+
+		addr := uint(octet)
+		if !n.children.Test(addr) {
+			return nil
+		}
+
+		// get the child: node or leaf
+		switch k := n.children.MustGet(addr).(type) {
+		case *node[V]:
+			// descend down to next trie level
+			n = k
+			continue
+		case *leaf[V]:
+			// reached a path compressed prefix, stop traversing
+			if k.prefix == pfx {
+				return &k.value
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
 // Contains does a route lookup for IP and
 // returns true if any route matched.
 //
@@ -294,6 +712,11 @@ func (t *Table[V]) Contains(ip netip.Addr) bool {
 		return false
 	}
 
+	// optional screen, reject unoccupied buckets without trie descent
+	if !t.screenTest(ip) {
+		return false
+	}
+
 	is4 := ip.Is4()
 	n := t.rootNodeByVersion(is4)
 
@@ -324,6 +747,83 @@ func (t *Table[V]) Contains(ip netip.Addr) bool {
 	panic("unreachable")
 }
 
+// ContainsBytes is similar to [Table.Contains], but accepts the IP address
+// in wire form as 4 or 16 byte slice, avoiding the [netip.Addr] construction
+// in the hot path of packet processors. The address family is dispatched
+// by the slice length, a 16 byte IPv4-mapped address is an IPv6 address.
+// For any other length ContainsBytes returns false.
+func (t *Table[V]) ContainsBytes(ip []byte) bool {
+	var is4 bool
+	switch len(ip) {
+	case 4:
+		is4 = true
+	case 16:
+		is4 = false
+	default:
+		return false
+	}
+
+	// optional screen, reject unoccupied buckets without trie descent
+	if t.screen != nil && !t.screen.testBytes(ip) {
+		return false
+	}
+
+	n := t.rootNodeByVersion(is4)
+
+	for _, octet := range ip {
+		addr := uint(octet)
+
+		// contains: any lpm match good enough, no backtracking needed
+		if n.prefixes.Len() != 0 && n.lpmTest(hostIndex(addr)) {
+			return true
+		}
+
+		if !n.children.Test(addr) {
+			return false
+		}
+
+		// get node or leaf for octet
+		switch k := n.children.MustGet(addr).(type) {
+		case *node[V]:
+			n = k
+			continue
+		case *leaf[V]:
+			return prefixContainsBytes(k.prefix, ip)
+		}
+	}
+
+	panic("unreachable")
+}
+
+// prefixContainsBytes reports whether the IP in wire form is in pfx,
+// the address family of pfx and ip must match.
+func prefixContainsBytes(pfx netip.Prefix, ip []byte) bool {
+	a16 := pfx.Addr().As16()
+
+	// the IPv4 address is in the last 4 bytes
+	pfxOctets := a16[:]
+	if len(ip) == 4 {
+		pfxOctets = a16[12:]
+	}
+
+	bits := pfx.Bits()
+	for i := range ip {
+		switch {
+		case bits >= 8:
+			if ip[i] != pfxOctets[i] {
+				return false
+			}
+			bits -= 8
+		case bits > 0:
+			return ip[i]&netMask(bits) == pfxOctets[i]
+		default:
+			return true
+		}
+	}
+
+	return true
+}
+
 // Lookup does a route lookup (longest prefix match) for IP and
 // returns the associated value and true, or false if no route matched.
 func (t *Table[V]) Lookup(ip netip.Addr) (val V, ok bool) {
@@ -334,6 +834,9 @@ func (t *Table[V]) Lookup(ip netip.Addr) (val V, ok bool) {
 	is4 := ip.Is4()
 	n := t.rootNodeByVersion(is4)
 
+	// the descent is manually inlined in the hot path,
+	// keep in sync with node.lookup
+
 	octets := ipAsOctets(ip, is4)
 
 	// stack of the traversed nodes for fast backtracking, if needed
@@ -354,7 +857,12 @@ LOOP:
 
 		// go down in tight loop to last octet
 		if !n.children.Test(addr) {
-			// no more nodes below octet
+			// fast path at the root, e.g. leaf firewalls with just a default route
+			if depth == 0 && n.isDefaultOnly() {
+				return n.prefixes.Items[0], true
+			}
+
+			// no more nodes below octet
 			break LOOP
 		}
 
@@ -392,6 +900,101 @@ LOOP:
 	return val, false
 }
 
+// LookupOr is like [Table.Lookup], but returns def if no route matched,
+// e.g. to fall back to a default policy.
+func (t *Table[V]) LookupOr(ip netip.Addr, def V) V {
+	if val, ok := t.Lookup(ip); ok {
+		return val
+	}
+	return def
+}
+
+// lookup, longest prefix match for ip in the trie below the root node n,
+// ip must be valid and is4 must match the address family of ip.
+// Returns also the prefix length of the matched prefix.
+func (n *node[V]) lookup(ip netip.Addr, is4 bool) (val V, pfxLen int, ok bool) {
+	octets := ipAsOctets(ip, is4)
+
+	// stack of the traversed nodes for fast backtracking, if needed
+	stack := [maxTreeDepth]*node[V]{}
+
+	// run variable, used after for loop
+	var depth int
+	var octet byte
+	var addr uint
+
+LOOP:
+	// find leaf node
+	for depth, octet = range octets {
+		addr = uint(octet)
+
+		// push current node on stack for fast backtracking
+		stack[depth] = n
+
+		// go down in tight loop to last octet
+		if !n.children.Test(addr) {
+			// fast path at the root, e.g. leaf firewalls with just a default route
+			if depth == 0 && n.isDefaultOnly() {
+				return n.prefixes.Items[0], 0, true
+			}
+
+			// no more nodes below octet
+			break LOOP
+		}
+
+		// get the child: node or leaf
+		switch k := n.children.MustGet(addr).(type) {
+		case *node[V]:
+			// descend down to next trie level
+			n = k
+			continue
+		case *leaf[V]:
+			// reached a path compressed prefix, stop traversing
+			if k.prefix.Contains(ip) {
+				return k.value, k.prefix.Bits(), true
+			}
+			break LOOP
+		}
+	}
+
+	// start backtracking, unwind the stack, bounds check eliminated
+	for ; depth >= 0 && depth < len(stack) && depth < len(octets); depth-- {
+		n = stack[depth]
+
+		// longest prefix match, skip if node has no prefixes
+		if n.prefixes.Len() != 0 {
+			idx := hostIndex(uint(octets[depth]))
+			// lpmGet(idx), manually inlined
+			// --------------------------------------------------------------
+			if topIdx, ok := n.prefixes.IntersectionTop(lpmLookupTbl[idx]); ok {
+				// calculate the pfxLen from depth and top idx
+				pfxLen = depth*strideLen + int(baseIdxLookupTbl[topIdx].pfxLen)
+				return n.prefixes.MustGet(topIdx), pfxLen, true
+			}
+			// --------------------------------------------------------------
+		}
+	}
+
+	return val, 0, false
+}
+
+// LookupBatch does a route lookup for each address in addrs and stores the
+// results in the parallel output slices, vals[i], oks[i] = t.Lookup(addrs[i]).
+// The batch is allocation-free, e.g. for packet bursts into preallocated
+// output slices.
+//
+// LookupBatch panics if the lengths of the slices are not equal.
+func (t *Table[V]) LookupBatch(addrs []netip.Addr, vals []V, oks []bool) {
+	if len(vals) != len(addrs) || len(oks) != len(addrs) {
+		panic(fmt.Sprintf("bart: LookupBatch length mismatch, addrs: %d, vals: %d, oks: %d",
+			len(addrs), len(vals), len(oks)))
+	}
+
+	for i, ip := range addrs {
+		vals[i], oks[i] = t.Lookup(ip)
+	}
+}
+
 // LookupPrefix does a route lookup (longest prefix match) for pfx and
 // returns the associated value and true, or false if no route matched.
 func (t *Table[V]) LookupPrefix(pfx netip.Prefix) (val V, ok bool) {
@@ -411,6 +1014,168 @@ func (t *Table[V]) LookupPrefixLPM(pfx netip.Prefix) (lpm netip.Prefix, val V, o
 	return t.lookupPrefixLPM(pfx, true)
 }
 
+// CommonMatch returns the most specific stored prefix covering both
+// addresses a and b, with its value, e.g. to find out whether two hosts
+// share a route and which one. The result ok is false if no stored prefix
+// covers both addresses, or if a and b are invalid or of different
+// address families.
+//
+// The lpm is looked up for the common leading bits of a and b, the
+// trie is descended only down to the divergence point.
+func (t *Table[V]) CommonMatch(a, b netip.Addr) (lpm netip.Prefix, val V, ok bool) {
+	if !a.IsValid() || !b.IsValid() || a.Is4() != b.Is4() {
+		return lpm, val, false
+	}
+
+	common, _ := a.Prefix(commonBits(a, b))
+
+	return t.lookupPrefixLPM(common, true)
+}
+
+// LookupShorterThan returns the longest prefix match for ip among the stored
+// prefixes with a length strictly less than maxBits, e.g. the covering route
+// ignoring host-specific entries below a threshold. If maxBits exceeds the
+// bit length of ip, it is the same as [Table.LookupPrefixLPM] for the host prefix.
+//
+// The recorded match depth is capped during descent, the lookup costs
+// about the same as LookupPrefixLPM.
+func (t *Table[V]) LookupShorterThan(ip netip.Addr, maxBits int) (lpm netip.Prefix, val V, ok bool) {
+	if !ip.IsValid() || maxBits <= 0 {
+		return lpm, val, false
+	}
+
+	maxBits = min(maxBits, ip.BitLen()+1)
+	capped, _ := ip.Prefix(maxBits - 1)
+
+	return t.lookupPrefixLPM(capped, true)
+}
+
+// NearestByPrefix returns an iterator over the k stored prefixes closest to
+// pfx, e.g. for "did you mean one of these nearby blocks?" suggestions
+// when no exact or covering match exists. The distance is the length of the
+// common leading bits of pfx and the stored prefix, limited by both prefix
+// lengths. Closer prefixes are yielded first, for equal distance in natural
+// CIDR sort order. Only prefixes of the same address family are considered,
+// fewer than k prefixes are yielded if the table is smaller.
+//
+// The search starts at pfx and expands outward, level by level
+// only the sibling subtrie of the previous level is visited.
+func (t *Table[V]) NearestByPrefix(pfx netip.Prefix, k int) func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		if !pfx.IsValid() || k <= 0 {
+			return
+		}
+
+		// canonicalize the prefix
+		pfx = pfx.Masked()
+
+		is4 := pfx.Addr().Is4()
+		if is4 && t.size4 == 0 || !is4 && t.size6 == 0 {
+			return
+		}
+
+		// IPv4 bits are in the last 4 bytes of the 16 byte representation
+		offset := 0
+		if is4 {
+			offset = 96
+		}
+		pfx16 := pfx.Addr().As16()
+
+		n := 0
+		emit := func(p netip.Prefix, v V) bool {
+			n++
+			return yield(p, v) && n < k
+		}
+
+		// distance pfx.Bits(), all subnets of pfx
+		stop := false
+		t.Subnets(pfx)(func(p netip.Prefix, v V) bool {
+			if !emit(p, v) {
+				stop = true
+				return false
+			}
+			return true
+		})
+
+		// expand outward, the prefixes with exactly l common bits
+		for l := pfx.Bits() - 1; l >= 0 && !stop; l-- {
+			base, _ := pfx.Addr().Prefix(l)
+
+			// base itself sorts before all its subnets
+			if v, ok := t.Get(base); ok && !emit(base, v) {
+				return
+			}
+
+			// the sibling half of base, not visited on the previous level
+			a16 := base.Addr().As16()
+			if !bitAt(pfx16, offset+l) {
+				setBitAt(&a16, offset+l)
+			}
+
+			ip := netip.AddrFrom16(a16)
+			if is4 {
+				ip = ip.Unmap()
+			}
+
+			t.Subnets(netip.PrefixFrom(ip, l+1))(func(p netip.Prefix, v V) bool {
+				if !emit(p, v) {
+					stop = true
+					return false
+				}
+				return true
+			})
+		}
+	}
+}
+
+// commonBits returns the number of common leading bits of a and b,
+// both addresses must be valid and of the same address family.
+func commonBits(a, b netip.Addr) int {
+	a16 := a.As16()
+	b16 := b.As16()
+
+	n := 0
+	for i := range a16 {
+		if x := a16[i] ^ b16[i]; x != 0 {
+			n += bits.LeadingZeros8(x)
+			break
+		}
+		n += 8
+	}
+
+	// the IPv4 address is in the last 4 bytes
+	if a.Is4() {
+		n -= 96
+	}
+
+	return n
+}
+
+// LookupPrefixLPM2 is similar to [Table.LookupPrefixLPM], but it returns
+// up to the two most specific covering prefixes with their values,
+// e.g. the primary and the backup route for failover decisions.
+//
+// The number of found prefixes is returned in n, 0, 1 or 2.
+// If n is 1, only best and bestVal are valid.
+func (t *Table[V]) LookupPrefixLPM2(pfx netip.Prefix) (best, second netip.Prefix, bestVal, secondVal V, n int) {
+	// the supernets are yielded from longest to shortest prefix
+	t.Supernets(pfx)(func(p netip.Prefix, v V) bool {
+		if n == 0 {
+			best, bestVal = p, v
+			n++
+			return true
+		}
+
+		second, secondVal = p, v
+		n++
+
+		// stop, got two matches
+		return false
+	})
+
+	return best, second, bestVal, secondVal, n
+}
+
 func (t *Table[V]) lookupPrefixLPM(pfx netip.Prefix, withLPM bool) (lpm netip.Prefix, val V, ok bool) {
 	if !pfx.IsValid() {
 		return lpm, val, false
@@ -641,117 +1406,1117 @@ func (t *Table[V]) Subnets(pfx netip.Prefix) func(yield func(netip.Prefix, V) bo
 	}
 }
 
-// OverlapsPrefix reports whether any IP in pfx is matched by a route in the table or vice versa.
-func (t *Table[V]) OverlapsPrefix(pfx netip.Prefix) bool {
-	if !pfx.IsValid() {
-		return false
+// MinimalCover returns an iterator over the minimal set of stored prefixes
+// covering all prefixes in pfxs, in natural CIDR sort order. For each input
+// prefix the shortest covering stored prefix is selected, each cover is
+// reported only once.
+//
+// The input prefixes without any covering stored prefix are returned
+// as uncovered, in natural CIDR sort order. Invalid input prefixes are ignored.
+//
+// The sorted inputs are resolved in a single descent of the trie, each node
+// on the paths of the inputs is visited once for all inputs sharing it.
+// The cost is O(k log k) for sorting the k inputs plus this descent.
+func (t *Table[V]) MinimalCover(pfxs []netip.Prefix) (cover func(yield func(netip.Prefix) bool), uncovered []netip.Prefix) {
+	sorted := make([]netip.Prefix, 0, len(pfxs))
+	for _, pfx := range pfxs {
+		if pfx.IsValid() {
+			sorted = append(sorted, pfx.Masked())
+		}
 	}
+	slices.SortFunc(sorted, cmpPrefix)
+	sorted = slices.Compact(sorted)
 
-	// canonicalize the prefix
-	pfx = pfx.Masked()
+	// IPv4 before IPv6 in CIDR sort order
+	split := len(sorted)
+	if i := slices.IndexFunc(sorted, func(p netip.Prefix) bool { return p.Addr().Is6() }); i >= 0 {
+		split = i
+	}
 
-	is4 := pfx.Addr().Is4()
-	n := t.rootNodeByVersion(is4)
+	var covers []netip.Prefix
+	t.root4.minimalCoverRec(sorted[:split], zeroPath, 0, true, &covers, &uncovered)
+	t.root6.minimalCoverRec(sorted[split:], zeroPath, 0, false, &covers, &uncovered)
 
-	return n.overlapsPrefixAtDepth(pfx, 0)
-}
+	// the shortest covers are disjoint, but found in trie order
+	slices.SortFunc(covers, cmpPrefix)
+	covers = slices.Compact(covers)
+	slices.SortFunc(uncovered, cmpPrefix)
 
-// Overlaps reports whether any IP in the table is matched by a route in the
-// other table or vice versa.
-func (t *Table[V]) Overlaps(o *Table[V]) bool {
-	return t.Overlaps4(o) || t.Overlaps6(o)
+	cover = func(yield func(netip.Prefix) bool) {
+		for _, pfx := range covers {
+			if !yield(pfx) {
+				return
+			}
+		}
+	}
+
+	return cover, uncovered
 }
 
-// Overlaps4 reports whether any IPv4 in the table matches a route in the
-// other table or vice versa.
-func (t *Table[V]) Overlaps4(o *Table[V]) bool {
-	if t.size4 == 0 || o.size4 == 0 {
-		return false
+// GroupBySupernet returns an iterator over the top-level prefixes of the table,
+// the prefixes without any covering stored prefix, each together with
+// an iterator over its covered subnets, e.g. for hierarchical IPAM displays.
+//
+// The top-level prefixes are in natural CIDR sort order, IPv4 before IPv6,
+// the subnets of each group as in [Table.Subnets], but without the top-level
+// prefix itself. A top-level prefix without subnets forms a group of its own
+// with an empty subnet iterator.
+func (t *Table[V]) GroupBySupernet() func(yield func(netip.Prefix, func(yield func(netip.Prefix, V) bool)) bool) {
+	return func(yield func(netip.Prefix, func(yield func(netip.Prefix, V) bool)) bool) {
+		var top netip.Prefix
+
+		t.AllSorted()(func(pfx netip.Prefix, _ V) bool {
+			// in CIDR sort order all subnets of top follow top
+			if top.IsValid() && top.Bits() <= pfx.Bits() && top.Contains(pfx.Addr()) {
+				return true
+			}
+
+			top = pfx
+			return yield(top, t.subnetsWithout(top))
+		})
 	}
-	return t.root4.overlaps(&o.root4, 0)
 }
 
-// Overlaps6 reports whether any IPv6 in the table matches a route in the
-// other table or vice versa.
-func (t *Table[V]) Overlaps6(o *Table[V]) bool {
-	if t.size6 == 0 || o.size6 == 0 {
-		return false
+// subnetsWithout returns an iterator over all CIDRs covered by pfx, without pfx itself.
+func (t *Table[V]) subnetsWithout(pfx netip.Prefix) func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		t.Subnets(pfx)(func(p netip.Prefix, v V) bool {
+			if p == pfx {
+				return true
+			}
+			return yield(p, v)
+		})
 	}
-	return t.root6.overlaps(&o.root6, 0)
 }
 
-// Union combines two tables, changing the receiver table.
-// If there are duplicate entries, the payload of type V is shallow copied from the other table.
-// If type V implements the [Cloner] interface, the values are cloned, see also [Table.Clone].
-func (t *Table[V]) Union(o *Table[V]) {
-	dup4 := t.root4.unionRec(&o.root4, 0)
-	dup6 := t.root6.unionRec(&o.root6, 0)
+// CountWhere returns the number of entries covered by pfx, pfx itself
+// included, satisfying keep, e.g. how many /24s under 10.0.0.0/8 point
+// at a given next-hop. If keep is nil, all covered entries are counted.
+//
+// The subtree is restricted and the predicate is tested in a single
+// descent, without collecting the entries.
+func (t *Table[V]) CountWhere(pfx netip.Prefix, keep func(netip.Prefix, V) bool) int {
+	count := 0
+
+	t.Subnets(pfx)(func(p netip.Prefix, v V) bool {
+		if keep == nil || keep(p, v) {
+			count++
+		}
+		return true
+	})
 
-	t.size4 += o.size4 - dup4
-	t.size6 += o.size6 - dup6
+	return count
 }
 
-// Cloner, if implemented by payload of type V the values are deeply copied
-// during [Table.Clone] and [Table.Union].
-type Cloner[V any] interface {
-	Clone() V
+// StrictSubnets is like [Table.Subnets], but pfx itself is excluded,
+// only the strictly more-specific CIDRs are yielded.
+// The iteration is in natural CIDR sort order.
+func (t *Table[V]) StrictSubnets(pfx netip.Prefix) func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		// canonicalize the prefix
+		pfx = pfx.Masked()
+
+		t.Subnets(pfx)(func(p netip.Prefix, v V) bool {
+			if p == pfx {
+				return true
+			}
+			return yield(p, v)
+		})
+	}
 }
 
-// Clone returns a copy of the routing table.
-// The payload of type V is shallow copied, but if type V implements the [Cloner] interface,
-// the values are cloned.
-func (t *Table[V]) Clone() *Table[V] {
-	if t == nil {
-		return nil
+// StrictSupernets is like [Table.Supernets], but pfx itself is excluded,
+// only the strictly less-specific CIDRs are yielded.
+// The iteration is in reverse CIDR sort order.
+func (t *Table[V]) StrictSupernets(pfx netip.Prefix) func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		// canonicalize the prefix
+		pfx = pfx.Masked()
+
+		t.Supernets(pfx)(func(p netip.Prefix, v V) bool {
+			if p == pfx {
+				return true
+			}
+			return yield(p, v)
+		})
 	}
+}
 
-	c := new(Table[V])
+// WouldOrphan returns an iterator over the stored prefixes, that rely on
+// the stored pfx as their closest covering route and would fall back to
+// a different or no covering route if pfx were deleted, e.g. for dependency
+// analysis before withdrawing an aggregate. The iteration is in natural
+// CIDR sort order. If pfx is not stored, nothing is yielded. No mutation occurs.
+//
+// Subnets covered by a more specific stored prefix below pfx are not
+// affected by a delete of pfx and are skipped.
+func (t *Table[V]) WouldOrphan(pfx netip.Prefix) func(yield func(netip.Prefix) bool) {
+	return func(yield func(netip.Prefix) bool) {
+		if _, ok := t.Get(pfx); !ok {
+			return
+		}
 
-	c.root4 = *t.root4.cloneRec()
-	c.root6 = *t.root6.cloneRec()
+		// the last yielded subnet, nested subnets follow in CIDR sort order
+		var top netip.Prefix
 
-	c.size4 = t.size4
-	c.size6 = t.size6
+		t.StrictSubnets(pfx)(func(sub netip.Prefix, _ V) bool {
+			if top.IsValid() && top.Overlaps(sub) {
+				return true
+			}
+			top = sub
 
-	return c
+			return yield(sub)
+		})
+	}
 }
 
-func (t *Table[V]) sizeUpdate(is4 bool, n int) {
-	if is4 {
-		t.size4 += n
-		return
+// SubnetsWhere returns an iterator over all CIDRs covered by pfx,
+// whose values satisfy keep. The iteration is in natural CIDR sort order.
+//
+// The value predicate can't prune any subtrees, but the filtering is done
+// in the same pass without collecting an intermediate slice.
+func (t *Table[V]) SubnetsWhere(pfx netip.Prefix, keep func(V) bool) func(yield func(netip.Prefix, V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		t.Subnets(pfx)(func(p netip.Prefix, v V) bool {
+			if !keep(v) {
+				return true
+			}
+			return yield(p, v)
+		})
 	}
-	t.size6 += n
 }
 
-// Size returns the prefix count.
-func (t *Table[V]) Size() int {
-	return t.size4 + t.size6
-}
+// ShadowedBy returns an iterator over the stored subnets of pfx, that would
+// become redundant if pfx with val were inserted, e.g. "adding this /16 lets
+// you drop these five /24s". It is a pure query, the table is not changed.
+//
+// A subnet is redundant, if its value and the values of all stored prefixes
+// between pfx and the subnet are equal to val as reported by eq. All yielded
+// subnets can be deleted together after inserting pfx, without changing any
+// lookup result. The exact match pfx itself is not yielded. The iteration
+// is in natural CIDR sort order.
+func (t *Table[V]) ShadowedBy(pfx netip.Prefix, val V, eq func(V, V) bool) func(yield func(netip.Prefix) bool) {
+	return func(yield func(netip.Prefix) bool) {
+		if !pfx.IsValid() {
+			return
+		}
 
-// Size4 returns the IPv4 prefix count.
-func (t *Table[V]) Size4() int {
-	return t.size4
-}
+		// canonicalize the prefix
+		pfx = pfx.Masked()
 
-// Size6 returns the IPv6 prefix count.
-func (t *Table[V]) Size6() int {
-	return t.size6
-}
+		type ancestor struct {
+			pfx       netip.Prefix
+			redundant bool
+		}
 
-// All returns an iterator over key-value pairs from Table. The iteration order
-// is not specified and is not guaranteed to be the same from one call to the
-// next.
-func (t *Table[V]) All() func(yield func(pfx netip.Prefix, val V) bool) {
-	return func(yield func(netip.Prefix, V) bool) {
-		_ = t.root4.allRec(zeroPath, 0, true, yield) && t.root6.allRec(zeroPath, 0, false, yield)
+		// stack of the covering subnets, CIDR sort order visits supernets first
+		var stack []ancestor
+
+		t.Subnets(pfx)(func(p netip.Prefix, v V) bool {
+			if p == pfx {
+				return true
+			}
+
+			for len(stack) > 0 && !stack[len(stack)-1].pfx.Contains(p.Addr()) {
+				stack = stack[:len(stack)-1]
+			}
+
+			redundant := eq(v, val)
+			if len(stack) > 0 {
+				redundant = redundant && stack[len(stack)-1].redundant
+			}
+
+			stack = append(stack, ancestor{p, redundant})
+
+			if redundant {
+				return yield(p)
+			}
+			return true
+		})
 	}
 }
 
-// All4, like [Table.All] but only for the v4 routing table.
-func (t *Table[V]) All4() func(yield func(pfx netip.Prefix, val V) bool) {
+// RangeByNumeric returns an iterator over all entries, whose numeric field,
+// extracted from the value by get, lies in the inclusive range [lo, hi],
+// e.g. all routes with a MED between lo and hi.
+//
+// The table is walked once in natural CIDR sort order, matches are yielded lazily.
+func (t *Table[V]) RangeByNumeric(get func(V) int64, lo, hi int64) func(yield func(netip.Prefix, V) bool) {
 	return func(yield func(netip.Prefix, V) bool) {
-		_ = t.root4.allRec(zeroPath, 0, true, yield)
+		t.AllSorted()(func(pfx netip.Prefix, val V) bool {
+			if n := get(val); n < lo || n > hi {
+				return true
+			}
+			return yield(pfx, val)
+		})
+	}
+}
+
+// OverlapsPrefix reports whether any IP in pfx is matched by a route in the table or vice versa.
+func (t *Table[V]) OverlapsPrefix(pfx netip.Prefix) bool {
+	if !pfx.IsValid() {
+		return false
+	}
+
+	is4 := pfx.Addr().Is4()
+
+	// fast path, empty address family
+	if is4 && t.size4 == 0 || !is4 && t.size6 == 0 {
+		return false
+	}
+
+	// canonicalize the prefix
+	pfx = pfx.Masked()
+
+	n := t.rootNodeByVersion(is4)
+
+	return n.overlapsPrefixAtDepth(pfx, 0)
+}
+
+// OverlappingPrefixes returns the candidates overlapping the table,
+// as reported by [Table.OverlapsPrefix], in the order of the input,
+// e.g. for batch rule validators. Invalid candidates are skipped.
+//
+// The candidates are returned unchanged, non-canonical prefixes are
+// not masked. An address family without any entry is skipped
+// without trie traversal.
+func (t *Table[V]) OverlappingPrefixes(candidates []netip.Prefix) []netip.Prefix {
+	var result []netip.Prefix
+
+	for _, pfx := range candidates {
+		if !pfx.IsValid() {
+			continue
+		}
+
+		is4 := pfx.Addr().Is4()
+		if is4 && t.size4 == 0 || !is4 && t.size6 == 0 {
+			continue
+		}
+
+		if t.rootNodeByVersion(is4).overlapsPrefixAtDepth(pfx.Masked(), 0) {
+			result = append(result, pfx)
+		}
+	}
+
+	return result
+}
+
+// OverlapEquivalent reports whether t and other agree in
+// [Table.OverlapsPrefix] for every probe, e.g. as regression harness
+// when refactoring the internals. Invalid probes are skipped,
+// a nil other is treated as an empty table.
+//
+// Each probe is masked only once, an address family empty in both
+// tables and identical root nodes are decided without trie traversal.
+func (t *Table[V]) OverlapEquivalent(other *Table[V], probes []netip.Prefix) bool {
+	if other == nil {
+		other = new(Table[V])
+	}
+
+	for _, pfx := range probes {
+		if !pfx.IsValid() {
+			continue
+		}
+
+		is4 := pfx.Addr().Is4()
+
+		tn := t.rootNodeByVersion(is4)
+		on := other.rootNodeByVersion(is4)
+
+		// same trie, same answer
+		if tn == on {
+			continue
+		}
+
+		tEmpty := is4 && t.size4 == 0 || !is4 && t.size6 == 0
+		oEmpty := is4 && other.size4 == 0 || !is4 && other.size6 == 0
+
+		if tEmpty && oEmpty {
+			continue
+		}
+
+		pfx = pfx.Masked()
+
+		tOK := !tEmpty && tn.overlapsPrefixAtDepth(pfx, 0)
+		oOK := !oEmpty && on.overlapsPrefixAtDepth(pfx, 0)
+
+		if tOK != oOK {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EachOverlap calls fn for every entry overlapping pfx, the covering
+// supernets and the covered subnets, until fn returns false.
+// It is the enumerating form of [Table.OverlapsPrefix].
+//
+// The entries are visited in natural CIDR sort order, the supernets
+// from shortest to longest, followed by the exact match, if any,
+// and the subnets. The exact match is visited only once.
+func (t *Table[V]) EachOverlap(pfx netip.Prefix, fn func(netip.Prefix, V) bool) {
+	if !pfx.IsValid() {
+		return
+	}
+
+	// canonicalize the prefix
+	pfx = pfx.Masked()
+
+	type entry struct {
+		pfx netip.Prefix
+		val V
+	}
+
+	// supernets are in reverse CIDR sort order, at most 129 of them
+	var supers []entry
+	t.Supernets(pfx)(func(p netip.Prefix, v V) bool {
+		// exact match is visited with the subnets
+		if p != pfx {
+			supers = append(supers, entry{p, v})
+		}
+		return true
+	})
+
+	for i := len(supers) - 1; i >= 0; i-- {
+		if !fn(supers[i].pfx, supers[i].val) {
+			return
+		}
+	}
+
+	t.Subnets(pfx)(fn)
+}
+
+// Overlaps reports whether any IP in the table is matched by a route in the
+// other table or vice versa.
+func (t *Table[V]) Overlaps(o *Table[V]) bool {
+	return t.Overlaps4(o) || t.Overlaps6(o)
+}
+
+// Overlaps4 reports whether any IPv4 in the table matches a route in the
+// other table or vice versa.
+func (t *Table[V]) Overlaps4(o *Table[V]) bool {
+	if t.size4 == 0 || o.size4 == 0 {
+		return false
+	}
+	return t.root4.overlaps(&o.root4, 0)
+}
+
+// Overlaps6 reports whether any IPv6 in the table matches a route in the
+// other table or vice versa.
+func (t *Table[V]) Overlaps6(o *Table[V]) bool {
+	if t.size6 == 0 || o.size6 == 0 {
+		return false
+	}
+	return t.root6.overlaps(&o.root6, 0)
+}
+
+// Union combines two tables, changing the receiver table.
+// If there are duplicate entries, the payload of type V is shallow copied from the other table.
+// If type V implements the [Cloner] interface, the values are cloned, see also [Table.Clone].
+func (t *Table[V]) Union(o *Table[V]) {
+	// fast path, nothing to add
+	if o.size4 == 0 && o.size6 == 0 {
+		return
+	}
+
+	// the hook needs single inserts
+	if t.onChange != nil {
+		o.AllSorted()(func(pfx netip.Prefix, val V) bool {
+			t.Insert(pfx, cloneOrCopyValue(val))
+			return true
+		})
+		return
+	}
+
+	// skip the recursion for an empty address family
+	if o.size4 != 0 {
+		dup4 := t.root4.unionRec(&o.root4, 0)
+		t.size4 += o.size4 - dup4
+	}
+
+	if o.size6 != 0 {
+		dup6 := t.root6.unionRec(&o.root6, 0)
+		t.size6 += o.size6 - dup6
+	}
+
+	// duplicate prefixes must not be counted twice, recount the screen
+	t.screenRebuild()
+}
+
+// ErrUnionConflict is returned by [Table.UnionStrict] if both tables
+// contain the same prefix with unequal values.
+var ErrUnionConflict = errors.New("bart: conflicting values for duplicate prefix")
+
+// UnionStrict returns a new table with the combined entries of t and o,
+// the receiver and o are not changed.
+//
+// Duplicate prefixes must have equal values as reported by eq, otherwise
+// no table is returned, but an error wrapping [ErrUnionConflict] with
+// the first conflicting prefix in CIDR sort order and the number of conflicts.
+func (t *Table[V]) UnionStrict(o *Table[V], eq func(V, V) bool) (*Table[V], error) {
+	var first netip.Prefix
+	var conflicts int
+
+	o.AllSorted()(func(pfx netip.Prefix, oVal V) bool {
+		if tVal, ok := t.Get(pfx); ok && !eq(tVal, oVal) {
+			if conflicts == 0 {
+				first = pfx
+			}
+			conflicts++
+		}
+		return true
+	})
+
+	if conflicts != 0 {
+		return nil, fmt.Errorf("%w: %s, %d conflicts total", ErrUnionConflict, first, conflicts)
+	}
+
+	c := t.Clone()
+	c.Union(o)
+
+	return c, nil
+}
+
+// Versioned is an interface used by [Table.UnionVersioned],
+// the value reports its version or generation, e.g. a feed timestamp.
+type Versioned interface {
+	Version() uint64
+}
+
+// UnionVersioned returns a new table with the combined entries of t and other,
+// the receiver and other are not changed. It is already non-mutating, there
+// is no separate persistent variant.
+//
+// For duplicate prefixes the value with the higher version wins, if V
+// implements the [Versioned] interface. On equal versions, or if the values
+// don't implement Versioned, the value from other wins, as with [Table.Union].
+// The values from other are cloned if V implements the [Cloner] interface.
+func (t *Table[V]) UnionVersioned(other *Table[V]) *Table[V] {
+	c := t.Clone()
+	if c == nil {
+		c = new(Table[V])
+	}
+
+	if other == nil {
+		return c
+	}
+
+	other.All()(func(pfx netip.Prefix, val V) bool {
+		c.Update(pfx, func(old V, ok bool) V {
+			if ok && newerVersion(old, val) {
+				return old
+			}
+			return cloneOrCopyValue(val)
+		})
+		return true
+	})
+
+	return c
+}
+
+// newerVersion reports whether a has a higher version than b,
+// false if the values don't implement the [Versioned] interface.
+func newerVersion[V any](a, b V) bool {
+	va, okA := any(a).(Versioned)
+	vb, okB := any(b).(Versioned)
+
+	return okA && okB && va.Version() > vb.Version()
+}
+
+// UnionAll returns a new table with the combined entries of all tables,
+// the input tables are not changed. Nil tables are skipped.
+//
+// The result is equal to folding [Table.Union] from left to right,
+// for duplicate prefixes the last writer wins.
+func UnionAll[V any](tables ...*Table[V]) *Table[V] {
+	t, _ := UnionAllFunc(nil, tables...)
+	return t
+}
+
+// UnionAllFunc is like [UnionAll], but for duplicate prefixes the value
+// is computed by combine(oldVal, newVal), where newVal is the value from the table
+// later in the argument list. If combine is nil, the last writer wins.
+//
+// All tables are merged node by node in a single multi-way rec-descent,
+// not by repeated unions. The number of duplicate prefixes over all
+// tables is also returned.
+func UnionAllFunc[V any](combine func(oldVal, newVal V) V, tables ...*Table[V]) (t *Table[V], duplicates int) {
+	t = new(Table[V])
+
+	var roots4, roots6 []any
+	var size4, size6 int
+
+	for _, o := range tables {
+		if o == nil {
+			continue
+		}
+
+		// skip the empty address families
+		if o.size4 != 0 {
+			roots4 = append(roots4, &o.root4)
+			size4 += o.size4
+		}
+		if o.size6 != 0 {
+			roots6 = append(roots6, &o.root6)
+			size6 += o.size6
+		}
+	}
+
+	dup4 := t.root4.unionAllRec(roots4, zeroPath, 0, true, combine)
+	dup6 := t.root6.unionAllRec(roots6, zeroPath, 0, false, combine)
+
+	t.size4 = size4 - dup4
+	t.size6 = size6 - dup6
+
+	return t, dup4 + dup6
+}
+
+// Shard partitions the entries of t into n new tables by a stable hash
+// of the prefix, e.g. for parallel processing by n workers.
+// The receiver is not changed, n less than 1 is treated as 1.
+//
+// Each entry is in exactly one shard and the same prefix is always
+// assigned to the same shard index, the [UnionAll] of the shards
+// is equal to t. Note that a covering prefix and its subnets are
+// in general in different shards, lookups in a single shard
+// are not meaningful.
+func (t *Table[V]) Shard(n int) []*Table[V] {
+	n = max(n, 1)
+
+	shards := make([]*Table[V], n)
+	for i := range shards {
+		shards[i] = new(Table[V])
+	}
+
+	t.All()(func(pfx netip.Prefix, val V) bool {
+		i := shardHash(pfx) % uint64(n)
+		shards[i].Insert(pfx, cloneOrCopyValue(val))
+		return true
+	})
+
+	return shards
+}
+
+// shardHash, FNV-1a hash of address and prefix length,
+// stable across processes and releases.
+func shardHash(pfx netip.Prefix) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+
+	h := uint64(offset64)
+	for _, b := range pfx.Addr().As16() {
+		h ^= uint64(b)
+		h *= prime64
+	}
+
+	h ^= uint64(pfx.Bits())
+	h *= prime64
+
+	return h
+}
+
+// Clusters returns an iterator over new tables, each with a maximal group of
+// entries connected by overlap, e.g. to analyze independent policy regions
+// of an ACL separately. The receiver is not changed.
+//
+// Prefixes either nest or are disjoint, a cluster is a stored prefix without
+// any stored supernet together with all its stored subnets. Entries without
+// any overlap form singleton clusters, the [UnionAll] of the clusters is
+// equal to t. The clusters are yielded in natural CIDR sort order of their
+// covering prefixes, the values are cloned if V implements the [Cloner] interface.
+func (t *Table[V]) Clusters() func(yield func(*Table[V]) bool) {
+	return func(yield func(*Table[V]) bool) {
+		var cluster *Table[V]
+
+		// the covering prefix of the current cluster
+		var top netip.Prefix
+
+		stop := false
+		t.AllSorted()(func(pfx netip.Prefix, val V) bool {
+			// subnets follow their covering prefix in CIDR sort order
+			if cluster == nil || !top.Overlaps(pfx) {
+				if cluster != nil && !yield(cluster) {
+					stop = true
+					return false
+				}
+				cluster = new(Table[V])
+				top = pfx
+			}
+
+			cluster.Insert(pfx, cloneOrCopyValue(val))
+			return true
+		})
+
+		if stop || cluster == nil {
+			return
+		}
+
+		yield(cluster)
+	}
+}
+
+// Cloner, if implemented by payload of type V the values are deeply copied
+// during [Table.Clone] and [Table.Union].
+type Cloner[V any] interface {
+	Clone() V
+}
+
+// Clone returns a copy of the routing table.
+// The payload of type V is shallow copied, but if type V implements the [Cloner] interface,
+// the values are cloned.
+func (t *Table[V]) Clone() *Table[V] {
+	return t.ClonePartial(nil)
+}
+
+// ClonePartial returns a copy of the routing table, each payload is copied
+// by the clone function. This gives the caller full control how deep the
+// values are copied, e.g. deep copy a mutable slice but share an immutable
+// field, instead of the all-or-nothing [Cloner] interface.
+//
+// If clone is nil, ClonePartial is equal to [Table.Clone].
+func (t *Table[V]) ClonePartial(clone func(V) V) *Table[V] {
+	if t == nil {
+		return nil
+	}
+
+	if clone == nil {
+		clone = cloneOrCopyValue[V]
+	}
+
+	c := new(Table[V])
+
+	c.root4 = *t.root4.cloneRecFunc(clone)
+	c.root6 = *t.root6.cloneRecFunc(clone)
+
+	t.cloneState(c)
+
+	return c
+}
+
+// cloneState copies the state besides the trie from t to c,
+// the sizes, the screen, the strict mode and the metadata.
+func (t *Table[V]) cloneState(c *Table[V]) {
+	c.size4 = t.size4
+	c.size6 = t.size6
+
+	c.screen = t.screen.clone()
+	c.strict = t.strict
+
+	if t.meta != nil {
+		c.meta = make(map[string]any, len(t.meta))
+		for k, v := range t.meta {
+			c.meta[k] = v
+		}
+	}
+}
+
+// CloneWithContext is like [Table.ClonePartial], but the clone function
+// gets the ctx and may fail, e.g. to pull fresh data per route.
+//
+// On the first error of clone or if ctx is done, the clone is aborted,
+// no further nodes are copied, and the error is returned with a nil table,
+// no partial table is exposed.
+func (t *Table[V]) CloneWithContext(ctx context.Context, clone func(context.Context, V) (V, error)) (*Table[V], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if t == nil {
+		return nil, nil
+	}
+
+	fn := func(v V) (V, error) {
+		if err := ctx.Err(); err != nil {
+			var zero V
+			return zero, err
+		}
+		return clone(ctx, v)
+	}
+
+	root4, err := t.root4.cloneRecFuncErr(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	root6, err := t.root6.cloneRecFuncErr(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Table[V])
+
+	c.root4 = *root4
+	c.root6 = *root6
+
+	t.cloneState(c)
+
+	return c, nil
+}
+
+// SetMeta attaches the metadata val under key to the table itself,
+// e.g. the source, generation timestamp or ASN of the routing table.
+// A nil val removes the key.
+//
+// The metadata is not related to any prefix, it is shallow copied
+// by [Table.Clone] but is not part of the text or JSON serialization.
+func (t *Table[V]) SetMeta(key string, val any) {
+	if val == nil {
+		delete(t.meta, key)
+		return
+	}
+
+	if t.meta == nil {
+		t.meta = make(map[string]any)
+	}
+
+	t.meta[key] = val
+}
+
+// Meta returns the table metadata for key and true,
+// or nil and false if key is not set, see [Table.SetMeta].
+func (t *Table[V]) Meta(key string) (val any, ok bool) {
+	val, ok = t.meta[key]
+	return val, ok
+}
+
+// Transform returns a new table with the entries of t, rewritten by fn.
+//
+// For each entry of t, fn is called with prefix and value and returns
+// the new prefix, the new value of type W and true, or false to drop the entry.
+// The new prefix may be of any length and address family.
+//
+// The entries of t are visited in CIDR sort order. If fn maps different
+// entries to the same prefix, the last writer wins, the value of
+// the entry last in CIDR sort order of t is stored.
+func Transform[V, W any](t *Table[V], fn func(netip.Prefix, V) (netip.Prefix, W, bool)) *Table[W] {
+	if t == nil {
+		return nil
+	}
+
+	w := new(Table[W])
+
+	t.AllSorted()(func(pfx netip.Prefix, val V) bool {
+		if newPfx, newVal, ok := fn(pfx, val); ok {
+			w.Insert(newPfx, newVal)
+		}
+		return true
+	})
+
+	return w
+}
+
+// ClampMaxLen returns a new table, where every entry longer than maxBits is
+// replaced by its covering prefix of length maxBits, e.g. for a summarized
+// export with no routes longer than /24. The receiver is not changed.
+// The bound maxBits applies to both address families, a negative
+// maxBits is treated as 0.
+//
+// The entries are visited in CIDR sort order, if a clamped prefix collides
+// with an already stored prefix, the value is computed by combine(oldVal, newVal).
+// If combine is nil, the last writer wins.
+//
+// The lookups in the clamped table are coarser by design, addresses not
+// covered by t may be covered by the clamped prefixes.
+func (t *Table[V]) ClampMaxLen(maxBits int, combine func(oldVal, newVal V) V) *Table[V] {
+	maxBits = max(maxBits, 0)
+
+	c := new(Table[V])
+
+	t.AllSorted()(func(pfx netip.Prefix, val V) bool {
+		if pfx.Bits() > maxBits {
+			pfx, _ = pfx.Addr().Prefix(maxBits)
+		}
+
+		val = cloneOrCopyValue(val)
+
+		c.Update(pfx, func(old V, ok bool) V {
+			if !ok || combine == nil {
+				return val
+			}
+			return combine(old, val)
+		})
+		return true
+	})
+
+	return c
+}
+
+// ErrShiftMismatch is returned by [Table.Shift] if the from and to
+// prefixes are invalid or differ in address family or length.
+var ErrShiftMismatch = errors.New("bart: shift prefixes differ in family or length")
+
+// ErrShiftCollision is returned by [Table.Shift] if a relocated entry
+// collides with an existing entry of the table.
+var ErrShiftCollision = errors.New("bart: shifted prefix collides with existing entry")
+
+// Shift returns a new table, where all entries under from are relocated
+// to the equivalent positions under to, same length and same host portion,
+// e.g. after a network renumbering. The receiver is not changed.
+//
+// Entries outside of from, including the supernets of from, are copied unchanged.
+// The prefixes from and to must be of the same address family and length,
+// otherwise an error wrapping [ErrShiftMismatch] is returned. If a relocated
+// entry collides with an existing entry under to, no table is returned but an
+// error wrapping [ErrShiftCollision] with the first colliding prefix.
+func (t *Table[V]) Shift(from, to netip.Prefix) (*Table[V], error) {
+	if !from.IsValid() || !to.IsValid() || from.Addr().Is4() != to.Addr().Is4() || from.Bits() != to.Bits() {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrShiftMismatch, from, to)
+	}
+
+	// canonicalize the prefixes
+	from = from.Masked()
+	to = to.Masked()
+
+	c := t.Clone()
+	if from == to {
+		return c, nil
+	}
+
+	// same length but not equal, from and to are disjoint
+	var moved []netip.Prefix
+	var vals []V
+
+	t.Subnets(from)(func(pfx netip.Prefix, val V) bool {
+		moved = append(moved, pfx)
+		vals = append(vals, val)
+		return true
+	})
+
+	for _, pfx := range moved {
+		c.Delete(pfx)
+	}
+
+	for i, pfx := range moved {
+		newPfx := shiftPrefix(pfx, to)
+		if _, ok := c.Get(newPfx); ok {
+			return nil, fmt.Errorf("%w: %s -> %s", ErrShiftCollision, pfx, newPfx)
+		}
+		c.Insert(newPfx, cloneOrCopyValue(vals[i]))
+	}
+
+	return c, nil
+}
+
+// shiftPrefix replaces the leading to.Bits() bits of pfx with the bits of to.
+// pfx must be in canonical form, of the same address family as to
+// and not shorter than to.
+func shiftPrefix(pfx, to netip.Prefix) netip.Prefix {
+	bits := to.Bits()
+	is4 := to.Addr().Is4()
+	if is4 {
+		bits += 96
+	}
+
+	a16 := pfx.Addr().As16()
+	t16 := to.Addr().As16()
+
+	for i := range a16 {
+		switch {
+		case bits >= (i+1)*8:
+			a16[i] = t16[i]
+		case bits <= i*8:
+			// host portion, unchanged
+		default:
+			m := netMask(bits - i*8)
+			a16[i] = t16[i]&m | a16[i]&^m
+		}
+	}
+
+	ip := netip.AddrFrom16(a16)
+	if is4 {
+		ip = ip.Unmap()
+	}
+
+	return netip.PrefixFrom(ip, pfx.Bits())
+}
+
+func (t *Table[V]) sizeUpdate(is4 bool, n int) {
+	if is4 {
+		t.size4 += n
+		return
+	}
+	t.size6 += n
+}
+
+// Size returns the prefix count.
+func (t *Table[V]) Size() int {
+	return t.size4 + t.size6
+}
+
+// Size4 returns the IPv4 prefix count.
+func (t *Table[V]) Size4() int {
+	return t.size4
+}
+
+// Size6 returns the IPv6 prefix count.
+func (t *Table[V]) Size6() int {
+	return t.size6
+}
+
+// IsEmpty reports whether the table has no prefixes, in constant time.
+// A nil table is empty.
+func (t *Table[V]) IsEmpty() bool {
+	if t == nil {
+		return true
+	}
+	return t.root4.isEmpty() && t.root6.isEmpty()
+}
+
+// Any4 reports whether the table has any IPv4 prefix, in constant time.
+func (t *Table[V]) Any4() bool {
+	if t == nil {
+		return false
+	}
+	return !t.root4.isEmpty()
+}
+
+// Any6 reports whether the table has any IPv6 prefix, in constant time.
+func (t *Table[V]) Any6() bool {
+	if t == nil {
+		return false
+	}
+	return !t.root6.isEmpty()
+}
+
+// Depth returns the maximum number of trie levels for IPv4 and IPv6,
+// the number of nodes on the longest path from the root.
+// An empty trie has depth 0, the root node alone has depth 1.
+//
+// With a stride of 8 bits, IPv4 reaches at most depth 4 and IPv6 at most
+// depth 16. Deep IPv6 tries are natural, dense regions with long prefixes
+// can't be path compressed.
+func (t *Table[V]) Depth() (maxDepth4, maxDepth6 int) {
+	maxDepth4, _, _ = t.root4.depthStatsRec(1)
+	maxDepth6, _, _ = t.root6.depthStatsRec(1)
+	return
+}
+
+// Imbalance returns the ratio of the maximum trie depth to the average
+// depth of the stored prefixes over both address families, see [Table.Depth].
+//
+// The ratio is 1.0 if all prefixes are stored at the deepest level and grows,
+// if a few prefixes force deep paths while most prefixes are stored near
+// the root. An empty table returns 0.
+func (t *Table[V]) Imbalance() float64 {
+	md4, ds4, n4 := t.root4.depthStatsRec(1)
+	md6, ds6, n6 := t.root6.depthStatsRec(1)
+
+	if n4+n6 == 0 {
+		return 0
+	}
+
+	avg := float64(ds4+ds6) / float64(n4+n6)
+
+	return float64(max(md4, md6)) / avg
+}
+
+// RootFamilyCounts returns the number of trie nodes for IPv4 and IPv6.
+// An empty root node is not counted.
+func (t *Table[V]) RootFamilyCounts() (nodes4, nodes6 int) {
+	return t.root4.nodeStatsRec().nodes, t.root6.nodeStatsRec().nodes
+}
+
+// DistSummary is an overview of the prefix distribution of a table,
+// see [Table.DistributionSummary].
+type DistSummary struct {
+	// Size4 and Size6 are the number of prefixes per address family.
+	Size4, Size6 int
+
+	// MeanBits4 and MeanBits6 are the mean prefix lengths, 0 for an empty family.
+	MeanBits4, MeanBits6 float64
+
+	// TopBucket4 is the most populated /8 and TopCount4 its number of prefixes,
+	// prefixes shorter than /8 are counted in the /8 of their address.
+	// For equal counts the lowest bucket wins, invalid for an empty family.
+	TopBucket4 netip.Prefix
+	TopCount4  int
+
+	// TopBucket6 and TopCount6, as TopBucket4 and TopCount4 but for the IPv6 /16 buckets.
+	TopBucket6 netip.Prefix
+	TopCount6  int
+
+	// Overlapping is the number of prefixes covering or covered by another prefix in the table.
+	Overlapping int
+}
+
+// DistributionSummary returns an overview of the prefix distribution,
+// e.g. for operators eyeballing an unfamiliar feed.
+// Everything is computed in a single sorted traversal of the trie.
+func (t *Table[V]) DistributionSummary() DistSummary {
+	var s DistSummary
+	var bits4, bits6 int
+
+	// current bucket and its count, buckets are contiguous in CIDR sort order
+	var bucket netip.Prefix
+	var count int
+
+	flushBucket := func() {
+		switch {
+		case !bucket.IsValid():
+		case bucket.Addr().Is4() && count > s.TopCount4:
+			s.TopBucket4, s.TopCount4 = bucket, count
+		case bucket.Addr().Is6() && count > s.TopCount6:
+			s.TopBucket6, s.TopCount6 = bucket, count
+		}
+	}
+
+	// the covering prefixes, counted as with OverlapCount
+	stack := make(overlapStack, 0, 129)
+
+	t.AllSorted()(func(pfx netip.Prefix, _ V) bool {
+		is4 := pfx.Addr().Is4()
+
+		if is4 {
+			s.Size4++
+			bits4 += pfx.Bits()
+		} else {
+			s.Size6++
+			bits6 += pfx.Bits()
+		}
+
+		bucketBits := 16
+		if is4 {
+			bucketBits = 8
+		}
+
+		if b := netip.PrefixFrom(pfx.Addr(), bucketBits).Masked(); b != bucket {
+			flushBucket()
+			bucket, count = b, 0
+		}
+		count++
+
+		s.Overlapping += stack.push(pfx)
+		return true
+	})
+
+	flushBucket()
+
+	if s.Size4 > 0 {
+		s.MeanBits4 = float64(bits4) / float64(s.Size4)
+	}
+	if s.Size6 > 0 {
+		s.MeanBits6 = float64(bits6) / float64(s.Size6)
+	}
+
+	return s
+}
+
+// All returns an iterator over key-value pairs from Table. The iteration order
+// is not specified and is not guaranteed to be the same from one call to the
+// next.
+func (t *Table[V]) All() func(yield func(pfx netip.Prefix, val V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		_ = t.root4.allRec(zeroPath, 0, true, yield) && t.root6.allRec(zeroPath, 0, false, yield)
+	}
+}
+
+// All4, like [Table.All] but only for the v4 routing table.
+func (t *Table[V]) All4() func(yield func(pfx netip.Prefix, val V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		_ = t.root4.allRec(zeroPath, 0, true, yield)
 	}
 }
 
@@ -762,7 +2527,11 @@ func (t *Table[V]) All6() func(yield func(pfx netip.Prefix, val V) bool) {
 	}
 }
 
-// AllSorted returns an iterator over key-value pairs from Table2 in natural CIDR sort order.
+// AllSorted returns an iterator over key-value pairs from Table in natural CIDR sort order.
+//
+// The entries are not collected and sorted, the iterator merges the prefixes
+// and children of each trie node on the fly. The memory is bounded by
+// the trie depth, not by the number of entries, even for huge tables.
 func (t *Table[V]) AllSorted() func(yield func(pfx netip.Prefix, val V) bool) {
 	return func(yield func(netip.Prefix, V) bool) {
 		_ = t.root4.allRecSorted(zeroPath, 0, true, yield) &&
@@ -784,6 +2553,143 @@ func (t *Table[V]) AllSorted6() func(yield func(pfx netip.Prefix, val V) bool) {
 	}
 }
 
+// AllSortedReverse returns an iterator over key-value pairs from Table in
+// reverse natural CIDR sort order, the exact reverse of [Table.AllSorted].
+// IPv6 before IPv4, from the highest and most-specific entries down.
+//
+// As for AllSorted, the entries are not collected, the trie is traversed
+// in reverse order.
+func (t *Table[V]) AllSortedReverse() func(yield func(pfx netip.Prefix, val V) bool) {
+	return func(yield func(netip.Prefix, V) bool) {
+		_ = t.root6.allRecSortedReverse(zeroPath, 0, false, yield) &&
+			t.root4.allRecSortedReverse(zeroPath, 0, true, yield)
+	}
+}
+
+// Entry is a prefix and its value, see [Table.ToSlice].
+type Entry[V any] struct {
+	Prefix netip.Prefix
+	Value  V
+}
+
+// ToSlice returns all entries in natural CIDR sort order, as [Table.AllSorted].
+// The slice is allocated once with the known size of the table,
+// without any append growth.
+func (t *Table[V]) ToSlice() []Entry[V] {
+	if t == nil {
+		return nil
+	}
+
+	entries := make([]Entry[V], 0, t.Size())
+
+	t.AllSorted()(func(pfx netip.Prefix, val V) bool {
+		entries = append(entries, Entry[V]{pfx, val})
+		return true
+	})
+
+	return entries
+}
+
+// Hosts returns an iterator over all host addresses of the entries with
+// at most maxHostBits host bits, each paired with the entry value,
+// e.g. for generating per host rules for a handful of small prefixes.
+// Entries with more host bits are skipped, so a /8 never explodes.
+//
+// The bound is the number of host bits, it guards both IP versions
+// alike, e.g. maxHostBits 4 allows IPv4 /28 and IPv6 /124 entries with
+// at most 16 hosts each and skips an IPv6 /32.
+//
+// The entries are visited in natural CIDR sort order and the hosts in
+// ascending order. Hosts of nested entries are yielded once per entry.
+func (t *Table[V]) Hosts(maxHostBits int) func(yield func(netip.Addr, V) bool) {
+	return func(yield func(netip.Addr, V) bool) {
+		t.AllSorted()(func(pfx netip.Prefix, val V) bool {
+			if pfx.Addr().BitLen()-pfx.Bits() > maxHostBits {
+				return true
+			}
+
+			last := lastAddr(pfx)
+			for ip := pfx.Addr(); ; ip = ip.Next() {
+				if !yield(ip, val) {
+					return false
+				}
+				if ip == last {
+					return true
+				}
+			}
+		})
+	}
+}
+
+// Neighbors returns the stored predecessor and successor of pfx
+// in natural CIDR sort order, IPv4 before IPv6.
+// The pfx itself does not have to be present in the table, it is never
+// returned as its own neighbor.
+//
+// The neighbors are found by a descent along the path of pfx with
+// backtracking to the nearest sorted siblings, in O(depth).
+//
+// okPrev or okNext is false, if there is no predecessor or successor.
+func (t *Table[V]) Neighbors(pfx netip.Prefix) (prev, next netip.Prefix, okPrev, okNext bool) {
+	if !pfx.IsValid() {
+		return
+	}
+
+	// canonicalize the prefix
+	pfx = pfx.Masked()
+
+	is4 := pfx.Addr().Is4()
+	n := t.rootNodeByVersion(is4)
+	octets := ipAsOctets(pfx.Addr(), is4)
+
+	prev, okPrev = n.predecessorRec(zeroPath, 0, is4, pfx, octets)
+	next, okNext = n.successorRec(zeroPath, 0, is4, pfx, octets)
+
+	// the successor of the last IPv4 prefix is the first IPv6 prefix
+	if is4 && !okNext {
+		next, okNext = t.root6.firstAfter(zeroPath, 0, false, -1)
+	}
+
+	// the predecessor of the first IPv6 prefix is the last IPv4 prefix
+	if !is4 && !okPrev {
+		prev, okPrev = t.root4.lastBefore(zeroPath, 0, true, math.MaxInt)
+	}
+
+	return prev, next, okPrev, okNext
+}
+
+// TouchedBuckets returns an iterator over all distinct prefixes of length bits,
+// the buckets, that contain at least one prefix from the table.
+// The buckets are yielded in natural CIDR sort order, IPv4 before IPv6.
+//
+// Prefixes shorter than bits are not contained in a bucket and are skipped.
+// If bits exceeds the address length of an IP version, e.g. 48 for IPv4,
+// no buckets are yielded for this IP version.
+func (t *Table[V]) TouchedBuckets(bits int) func(yield func(netip.Prefix) bool) {
+	return func(yield func(netip.Prefix) bool) {
+		if bits < 0 {
+			return
+		}
+
+		var last netip.Prefix
+
+		t.AllSorted()(func(pfx netip.Prefix, _ V) bool {
+			if bits > pfx.Addr().BitLen() || pfx.Bits() < bits {
+				return true
+			}
+
+			// in CIDR sort order equal buckets are consecutive
+			bucket, _ := pfx.Addr().Prefix(bits)
+			if bucket == last {
+				return true
+			}
+
+			last = bucket
+			return yield(bucket)
+		})
+	}
+}
+
 // lastOctetIdxAndBits, get last significant octet Idx and significant bits
 //
 // lastIdx:
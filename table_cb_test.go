@@ -5,6 +5,7 @@ package bart
 
 import (
 	"fmt"
+	"math"
 	"net/netip"
 	"reflect"
 	"slices"
@@ -347,6 +348,110 @@ func TestAllSorted(t *testing.T) {
 			t.Fatalf("All differs with slices.SortFunc")
 		}
 	})
+
+	t.Run("AllSorted with premature exit", func(t *testing.T) {
+		t.Parallel()
+		expect := make([]netip.Prefix, 0, n)
+		got := make([]netip.Prefix, 0, n)
+
+		rtbl := new(Table[int])
+		for _, item := range pfxs {
+			rtbl.Insert(item.pfx, item.val)
+			expect = append(expect, item.pfx)
+		}
+
+		slices.SortFunc(expect, cmpPrefix)
+
+		// the streamed head must already be in final sort order
+		rtbl.AllSorted()(func(pfx netip.Prefix, _ int) bool {
+			got = append(got, pfx)
+			return len(got) < 100
+		})
+
+		if !reflect.DeepEqual(got, expect[:100]) {
+			t.Fatalf("AllSorted with early exit differs with slices.SortFunc")
+		}
+	})
+}
+
+func TestAllSortedReverse(t *testing.T) {
+	t.Parallel()
+
+	rtbl := new(Table[int])
+	rtbl.AllSortedReverse()(func(pfx netip.Prefix, _ int) bool {
+		t.Errorf("AllSortedReverse, empty table, unexpected %s", pfx)
+		return true
+	})
+
+	for _, item := range randomPrefixes(10_000) {
+		rtbl.Insert(item.pfx, item.val)
+	}
+
+	// default routes and path compressed leaves
+	rtbl.Insert(mpp("0.0.0.0/0"), 0)
+	rtbl.Insert(mpp("::/0"), 0)
+	rtbl.Insert(mpp("255.255.255.255/32"), 0)
+
+	var expect []Entry[int]
+	rtbl.AllSorted()(func(pfx netip.Prefix, val int) bool {
+		expect = append(expect, Entry[int]{pfx, val})
+		return true
+	})
+	slices.Reverse(expect)
+
+	var got []Entry[int]
+	rtbl.AllSortedReverse()(func(pfx netip.Prefix, val int) bool {
+		got = append(got, Entry[int]{pfx, val})
+		return true
+	})
+
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("AllSortedReverse differs with reversed AllSorted")
+	}
+
+	// premature exit, the streamed head must already be in final order
+	got = got[:0]
+	rtbl.AllSortedReverse()(func(pfx netip.Prefix, val int) bool {
+		got = append(got, Entry[int]{pfx, val})
+		return len(got) < 100
+	})
+
+	if !reflect.DeepEqual(got, expect[:100]) {
+		t.Fatalf("AllSortedReverse with early exit differs with reversed AllSorted")
+	}
+}
+
+func TestToSlice(t *testing.T) {
+	t.Parallel()
+
+	var nilTbl *Table[int]
+	if got := nilTbl.ToSlice(); got != nil {
+		t.Errorf("ToSlice of nil table, expected nil, got %v", got)
+	}
+
+	rtbl := new(Table[int])
+	if got := rtbl.ToSlice(); len(got) != 0 {
+		t.Errorf("ToSlice of empty table, expected empty slice, got %v", got)
+	}
+
+	for _, item := range randomPrefixes(10_000) {
+		rtbl.Insert(item.pfx, item.val)
+	}
+
+	var expect []Entry[int]
+	rtbl.AllSorted()(func(pfx netip.Prefix, val int) bool {
+		expect = append(expect, Entry[int]{pfx, val})
+		return true
+	})
+
+	got := rtbl.ToSlice()
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("ToSlice differs with AllSorted")
+	}
+
+	if len(got) != cap(got) || len(got) != rtbl.Size() {
+		t.Errorf("ToSlice, expected len == cap == Size, got len %d, cap %d, Size %d", len(got), cap(got), rtbl.Size())
+	}
 }
 
 func BenchmarkAll(b *testing.B) {
@@ -374,6 +479,13 @@ func BenchmarkAll(b *testing.B) {
 			})
 		}
 	})
+
+	b.Run("ToSlice", func(b *testing.B) {
+		b.ResetTimer()
+		for range b.N {
+			intSink = len(rtbl.ToSlice())
+		}
+	})
 }
 
 func BenchmarkSubnetsCB(b *testing.B) {
@@ -413,3 +525,1020 @@ func BenchmarkSupernetsCB(b *testing.B) {
 		}
 	})
 }
+
+func TestTouchedBuckets(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for i, s := range []string{
+		"0.0.0.0/0",
+		"10.0.0.0/8",
+		"10.1.2.0/24",
+		"10.1.2.128/25",
+		"10.1.3.7/32",
+		"192.168.1.0/24",
+		"2001:db8::/32",
+		"2001:db8:1:2::/64",
+		"2001:db8:1:3::1/128",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	tests := []struct {
+		bits int
+		want []netip.Prefix
+	}{
+		{
+			bits: -1,
+			want: nil,
+		},
+		{
+			bits: 24,
+			want: []netip.Prefix{mpp("10.1.2.0/24"), mpp("10.1.3.0/24"), mpp("192.168.1.0/24"), mpp("2001:d00::/24")},
+		},
+		{
+			bits: 48,
+			want: []netip.Prefix{mpp("2001:db8:1::/48")},
+		},
+		{
+			bits: 64,
+			want: []netip.Prefix{mpp("2001:db8:1:2::/64"), mpp("2001:db8:1:3::/64")},
+		},
+		{
+			bits: 129,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var got []netip.Prefix
+		rt.TouchedBuckets(tt.bits)(func(pfx netip.Prefix) bool {
+			got = append(got, pfx)
+			return true
+		})
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TouchedBuckets(%d), expected %v, got %v", tt.bits, tt.want, got)
+		}
+	}
+
+	// compare with buckets from All
+	pfxs := randomPrefixes(10_000)
+	rt = new(Table[int])
+	for _, item := range pfxs {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	want := map[netip.Prefix]bool{}
+	for _, item := range pfxs {
+		if item.pfx.Bits() >= 16 {
+			bucket, _ := item.pfx.Addr().Prefix(16)
+			want[bucket] = true
+		}
+	}
+
+	got := map[netip.Prefix]bool{}
+	rt.TouchedBuckets(16)(func(pfx netip.Prefix) bool {
+		if got[pfx] {
+			t.Fatalf("TouchedBuckets(16), duplicate bucket %s", pfx)
+		}
+		got[pfx] = true
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TouchedBuckets(16), expected %d buckets, got %d", len(want), len(got))
+	}
+}
+
+func TestSubnetsWhereCB(t *testing.T) {
+	t.Parallel()
+
+	pfxs := gimmeRandomPrefixes(10_000)
+
+	rtbl := new(Table[int])
+	for i, pfx := range pfxs {
+		rtbl.Insert(pfx, i)
+	}
+
+	even := func(v int) bool { return v%2 == 0 }
+
+	for _, tt := range randomPrefixes(200) {
+		var want []netip.Prefix
+		rtbl.Subnets(tt.pfx)(func(p netip.Prefix, v int) bool {
+			if even(v) {
+				want = append(want, p)
+			}
+			return true
+		})
+
+		var got []netip.Prefix
+		rtbl.SubnetsWhere(tt.pfx, even)(func(p netip.Prefix, v int) bool {
+			if !even(v) {
+				t.Fatalf("SubnetsWhere(%s), value %d does not satisfy predicate", tt.pfx, v)
+			}
+			got = append(got, p)
+			return true
+		})
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("SubnetsWhere(%q) = %v, want %v", tt.pfx, got, want)
+		}
+	}
+
+	// premature exit
+	count := 0
+	rtbl.SubnetsWhere(mpp("0.0.0.0/0"), even)(func(netip.Prefix, int) bool {
+		count++
+		return count < 10
+	})
+
+	if count != 10 {
+		t.Errorf("SubnetsWhere with premature exit, expected 10 items, got %d", count)
+	}
+}
+
+func TestStrictSubnetsSupernetsCB(t *testing.T) {
+	t.Parallel()
+
+	rtbl := new(Table[int])
+	for i, s := range []string{"10.0.0.0/8", "10.0.0.0/16", "10.0.0.0/24", "10.0.1.0/24", "2001:db8::/32"} {
+		rtbl.Insert(mpp(s), i)
+	}
+
+	collect := func(seq func(yield func(netip.Prefix, int) bool)) []netip.Prefix {
+		var got []netip.Prefix
+		seq(func(p netip.Prefix, _ int) bool {
+			got = append(got, p)
+			return true
+		})
+		return got
+	}
+
+	tests := []struct {
+		pfx       netip.Prefix
+		subnets   []netip.Prefix
+		supernets []netip.Prefix
+	}{
+		{
+			pfx:       mpp("10.0.0.0/16"),
+			subnets:   []netip.Prefix{mpp("10.0.0.0/24"), mpp("10.0.1.0/24")},
+			supernets: []netip.Prefix{mpp("10.0.0.0/8")},
+		},
+		{
+			// not stored, nothing to exclude
+			pfx:       mpp("10.0.0.0/23"),
+			subnets:   []netip.Prefix{mpp("10.0.0.0/24"), mpp("10.0.1.0/24")},
+			supernets: []netip.Prefix{mpp("10.0.0.0/16"), mpp("10.0.0.0/8")},
+		},
+		{
+			// non canonical
+			pfx:       netip.MustParsePrefix("10.0.0.1/24"),
+			subnets:   nil,
+			supernets: []netip.Prefix{mpp("10.0.0.0/16"), mpp("10.0.0.0/8")},
+		},
+		{
+			pfx:       mpp("2001:db8::/32"),
+			subnets:   nil,
+			supernets: nil,
+		},
+		{
+			pfx:       netip.Prefix{},
+			subnets:   nil,
+			supernets: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := collect(rtbl.StrictSubnets(tt.pfx)); !reflect.DeepEqual(got, tt.subnets) {
+			t.Errorf("StrictSubnets(%s) = %v, want %v", tt.pfx, got, tt.subnets)
+		}
+		if got := collect(rtbl.StrictSupernets(tt.pfx)); !reflect.DeepEqual(got, tt.supernets) {
+			t.Errorf("StrictSupernets(%s) = %v, want %v", tt.pfx, got, tt.supernets)
+		}
+	}
+
+	// compare with Subnets and Supernets
+	for i, pfx := range gimmeRandomPrefixes(10_000) {
+		rtbl.Insert(pfx, i)
+	}
+
+	for _, tt := range randomPrefixes(200) {
+		strict := collect(rtbl.StrictSubnets(tt.pfx))
+		all := collect(rtbl.Subnets(tt.pfx))
+		if _, ok := rtbl.Get(tt.pfx); ok {
+			all = all[1:]
+		}
+		if !slices.Equal(strict, all) {
+			t.Fatalf("StrictSubnets(%s) = %v, want %v", tt.pfx, strict, all)
+		}
+
+		strict = collect(rtbl.StrictSupernets(tt.pfx))
+		all = collect(rtbl.Supernets(tt.pfx))
+		if _, ok := rtbl.Get(tt.pfx); ok {
+			all = all[1:]
+		}
+		if !slices.Equal(strict, all) {
+			t.Fatalf("StrictSupernets(%s) = %v, want %v", tt.pfx, strict, all)
+		}
+	}
+}
+
+func TestWouldOrphanCB(t *testing.T) {
+	t.Parallel()
+
+	rtbl := new(Table[int])
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.1.0/24",
+		"10.2.0.0/16",
+		"10.3.3.0/24",
+		"11.0.0.0/8",
+		"2001:db8::/32",
+	} {
+		rtbl.Insert(mpp(s), i)
+	}
+
+	collect := func(seq func(func(netip.Prefix) bool)) []netip.Prefix {
+		var result []netip.Prefix
+		seq(func(p netip.Prefix) bool {
+			result = append(result, p)
+			return true
+		})
+		return result
+	}
+
+	tests := []struct {
+		pfx  netip.Prefix
+		want []netip.Prefix
+	}{
+		{mpp("10.0.0.0/8"), []netip.Prefix{mpp("10.1.0.0/16"), mpp("10.2.0.0/16"), mpp("10.3.3.0/24")}},
+		{netip.MustParsePrefix("10.1.2.3/16"), []netip.Prefix{mpp("10.1.1.0/24")}},
+		{mpp("10.1.1.0/24"), nil},
+		{mpp("10.3.0.0/16"), nil}, // not stored
+		{mpp("2001:db8::/32"), nil},
+		{netip.Prefix{}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := collect(rtbl.WouldOrphan(tt.pfx)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("WouldOrphan(%s) = %v, want %v", tt.pfx, got, tt.want)
+		}
+	}
+
+	// compare with the closest strict supernet of each subnet
+	rtbl = new(Table[int])
+	for i, pfx := range gimmeRandomPrefixes(10_000) {
+		rtbl.Insert(pfx, i)
+	}
+
+	rtbl.All()(func(pfx netip.Prefix, _ int) bool {
+		var want []netip.Prefix
+		rtbl.StrictSubnets(pfx)(func(sub netip.Prefix, _ int) bool {
+			rtbl.StrictSupernets(sub)(func(parent netip.Prefix, _ int) bool {
+				if parent == pfx {
+					want = append(want, sub)
+				}
+				return false
+			})
+			return true
+		})
+
+		if got := collect(rtbl.WouldOrphan(pfx)); !slices.Equal(got, want) {
+			t.Fatalf("WouldOrphan(%s) = %v, want %v", pfx, got, want)
+		}
+		return true
+	})
+}
+
+func TestCountWhereCB(t *testing.T) {
+	t.Parallel()
+
+	rtbl := new(Table[int])
+	rtbl.Insert(mpp("10.0.0.0/8"), 1)
+	rtbl.Insert(mpp("10.0.0.0/24"), 2)
+	rtbl.Insert(mpp("10.0.1.0/24"), 2)
+	rtbl.Insert(mpp("10.0.2.0/24"), 3)
+	rtbl.Insert(mpp("10.0.2.0/25"), 2)
+	rtbl.Insert(mpp("11.0.0.0/24"), 2)
+
+	is24 := func(p netip.Prefix, v int) bool { return p.Bits() == 24 && v == 2 }
+
+	tests := []struct {
+		pfx  netip.Prefix
+		keep func(netip.Prefix, int) bool
+		want int
+	}{
+		{mpp("10.0.0.0/8"), is24, 2},
+		{mpp("10.0.0.0/8"), nil, 5},
+		{mpp("0.0.0.0/0"), is24, 3},
+		{mpp("10.0.2.0/24"), nil, 2},
+		{mpp("12.0.0.0/8"), nil, 0},
+		{netip.Prefix{}, nil, 0},
+	}
+
+	for _, tt := range tests {
+		if got := rtbl.CountWhere(tt.pfx, tt.keep); got != tt.want {
+			t.Errorf("CountWhere(%s) = %d, want %d", tt.pfx, got, tt.want)
+		}
+	}
+
+	// compare with collecting Subnets and filtering
+	for i, pfx := range gimmeRandomPrefixes(10_000) {
+		rtbl.Insert(pfx, i)
+	}
+
+	even := func(_ netip.Prefix, v int) bool { return v%2 == 0 }
+
+	for _, tt := range randomPrefixes(200) {
+		var want int
+		rtbl.Subnets(tt.pfx)(func(p netip.Prefix, v int) bool {
+			if even(p, v) {
+				want++
+			}
+			return true
+		})
+
+		if got := rtbl.CountWhere(tt.pfx, even); got != want {
+			t.Fatalf("CountWhere(%s) = %d, want %d", tt.pfx, got, want)
+		}
+	}
+}
+
+func TestEachOverlapCB(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for i, s := range []string{
+		"0.0.0.0/0",
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.0/24",
+		"10.1.2.128/25",
+		"10.1.3.0/24",
+		"10.2.0.0/16",
+		"2001:db8::/32",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	var got []netip.Prefix
+	rt.EachOverlap(mpp("10.1.0.0/16"), func(p netip.Prefix, _ int) bool {
+		got = append(got, p)
+		return true
+	})
+
+	want := []netip.Prefix{
+		mpp("0.0.0.0/0"),
+		mpp("10.0.0.0/8"),
+		mpp("10.1.0.0/16"),
+		mpp("10.1.2.0/24"),
+		mpp("10.1.2.128/25"),
+		mpp("10.1.3.0/24"),
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EachOverlap(10.1.0.0/16) = %v, want %v", got, want)
+	}
+
+	// invalid prefix
+	rt.EachOverlap(netip.Prefix{}, func(netip.Prefix, int) bool {
+		t.Errorf("EachOverlap(invalid), unexpected call")
+		return true
+	})
+
+	// premature exit
+	count := 0
+	rt.EachOverlap(mpp("10.1.0.0/16"), func(netip.Prefix, int) bool {
+		count++
+		return count < 3
+	})
+
+	if count != 3 {
+		t.Errorf("EachOverlap with premature exit, expected 3 items, got %d", count)
+	}
+
+	// compare with gold, all overlapping prefixes in CIDR sort order
+	pfxs := randomPrefixes(10_000)
+	rtbl := new(Table[int])
+	for _, item := range pfxs {
+		rtbl.Insert(item.pfx, item.val)
+	}
+
+	for _, tt := range randomPrefixes(200) {
+		var want []netip.Prefix
+		for _, item := range pfxs {
+			if item.pfx.Overlaps(tt.pfx) {
+				want = append(want, item.pfx)
+			}
+		}
+		slices.SortFunc(want, cmpPrefix)
+
+		var got []netip.Prefix
+		rtbl.EachOverlap(tt.pfx, func(p netip.Prefix, _ int) bool {
+			got = append(got, p)
+			return true
+		})
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("EachOverlap(%s) = %v, want %v", tt.pfx, got, want)
+		}
+	}
+}
+
+func TestMinimalCoverCB(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"192.168.1.0/24",
+		"2001:db8::/32",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	cover, uncovered := rt.MinimalCover([]netip.Prefix{
+		mpp("192.168.1.0/25"),
+		mpp("10.1.2.0/24"),
+		mpp("10.200.0.0/16"),
+		mpp("11.0.0.0/24"),
+		mpp("2001:db8:1::/48"),
+		mpp("10.1.0.0/16"),
+		mpp("2001:db9::/48"),
+		mpp("192.168.0.0/16"),
+		{},
+	})
+
+	var got []netip.Prefix
+	cover(func(p netip.Prefix) bool {
+		got = append(got, p)
+		return true
+	})
+
+	wantCover := []netip.Prefix{
+		mpp("10.0.0.0/8"),
+		mpp("192.168.0.0/16"),
+		mpp("2001:db8::/32"),
+	}
+	wantUncovered := []netip.Prefix{
+		mpp("11.0.0.0/24"),
+		mpp("2001:db9::/48"),
+	}
+
+	if !reflect.DeepEqual(got, wantCover) {
+		t.Errorf("MinimalCover, cover = %v, want %v", got, wantCover)
+	}
+	if !reflect.DeepEqual(uncovered, wantUncovered) {
+		t.Errorf("MinimalCover, uncovered = %v, want %v", uncovered, wantUncovered)
+	}
+
+	// premature exit
+	count := 0
+	cover(func(netip.Prefix) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("MinimalCover with premature exit, expected 1 item, got %d", count)
+	}
+
+	// compare with gold, every input is covered by exactly one cover or uncovered
+	pfxs := randomPrefixes(1_000)
+	rtbl := new(Table[int])
+	for _, item := range pfxs {
+		rtbl.Insert(item.pfx, item.val)
+	}
+
+	var probes []netip.Prefix
+	for _, item := range randomPrefixes(1_000) {
+		probes = append(probes, item.pfx)
+	}
+
+	cover, uncovered = rtbl.MinimalCover(probes)
+
+	var covers []netip.Prefix
+	cover(func(p netip.Prefix) bool {
+		covers = append(covers, p)
+		return true
+	})
+
+	for _, probe := range probes {
+		wantCovered := false
+		for _, item := range pfxs {
+			if item.pfx.Bits() <= probe.Bits() && item.pfx.Contains(probe.Addr()) {
+				wantCovered = true
+				break
+			}
+		}
+
+		n := 0
+		for _, c := range covers {
+			if c.Bits() <= probe.Bits() && c.Contains(probe.Addr()) {
+				n++
+			}
+		}
+
+		if wantCovered && n != 1 {
+			t.Fatalf("MinimalCover, %s covered by %d covers, expected 1", probe, n)
+		}
+		if !wantCovered && (n != 0 || !slices.Contains(uncovered, probe)) {
+			t.Fatalf("MinimalCover, %s expected as uncovered", probe)
+		}
+	}
+
+	// minimal, every cover is needed
+	for _, c := range covers {
+		needed := false
+		for _, probe := range probes {
+			if c.Bits() <= probe.Bits() && c.Contains(probe.Addr()) {
+				needed = true
+				break
+			}
+		}
+		if !needed {
+			t.Fatalf("MinimalCover, cover %s not needed", c)
+		}
+
+		// shortest, no stored supernet of the cover
+		rtbl.StrictSupernets(c)(func(p netip.Prefix, _ int) bool {
+			t.Fatalf("MinimalCover, cover %s is not the shortest, covered by %s", c, p)
+			return false
+		})
+	}
+
+	if !slices.IsSortedFunc(covers, cmpPrefix) || !slices.IsSortedFunc(uncovered, cmpPrefix) {
+		t.Errorf("MinimalCover, expected covers and uncovered in CIDR sort order")
+	}
+}
+
+func TestGroupBySupernetCB(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.GroupBySupernet()(func(netip.Prefix, func(yield func(netip.Prefix, int) bool)) bool {
+		t.Errorf("GroupBySupernet, empty table, unexpected group")
+		return true
+	})
+
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.0/24",
+		"10.2.0.0/16",
+		"11.0.0.0/8",
+		"192.168.0.0/16",
+		"192.168.1.0/24",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	want := map[netip.Prefix][]netip.Prefix{
+		mpp("10.0.0.0/8"):     {mpp("10.1.0.0/16"), mpp("10.1.2.0/24"), mpp("10.2.0.0/16")},
+		mpp("11.0.0.0/8"):     nil,
+		mpp("192.168.0.0/16"): {mpp("192.168.1.0/24")},
+		mpp("2001:db8::/32"):  {mpp("2001:db8:1::/48")},
+	}
+	wantTops := []netip.Prefix{
+		mpp("10.0.0.0/8"),
+		mpp("11.0.0.0/8"),
+		mpp("192.168.0.0/16"),
+		mpp("2001:db8::/32"),
+	}
+
+	var gotTops []netip.Prefix
+	rt.GroupBySupernet()(func(top netip.Prefix, subnets func(yield func(netip.Prefix, int) bool)) bool {
+		gotTops = append(gotTops, top)
+
+		var got []netip.Prefix
+		subnets(func(p netip.Prefix, _ int) bool {
+			got = append(got, p)
+			return true
+		})
+
+		if !reflect.DeepEqual(got, want[top]) {
+			t.Errorf("GroupBySupernet, group %s = %v, want %v", top, got, want[top])
+		}
+		return true
+	})
+
+	if !reflect.DeepEqual(gotTops, wantTops) {
+		t.Errorf("GroupBySupernet, top-level = %v, want %v", gotTops, wantTops)
+	}
+
+	// premature exit
+	count := 0
+	rt.GroupBySupernet()(func(netip.Prefix, func(yield func(netip.Prefix, int) bool)) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("GroupBySupernet with premature exit, expected 2 groups, got %d", count)
+	}
+
+	// random, every entry is in exactly one group
+	rtbl := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rtbl.Insert(item.pfx, item.val)
+	}
+
+	seen := map[netip.Prefix]int{}
+	rtbl.GroupBySupernet()(func(top netip.Prefix, subnets func(yield func(netip.Prefix, int) bool)) bool {
+		if _, _, _, _, n := rtbl.LookupPrefixLPM2(top); n > 1 {
+			t.Fatalf("GroupBySupernet, top-level %s has a covering prefix", top)
+		}
+		seen[top]++
+		subnets(func(p netip.Prefix, _ int) bool {
+			seen[p]++
+			return true
+		})
+		return true
+	})
+
+	if len(seen) != rtbl.Size() {
+		t.Fatalf("GroupBySupernet, expected %d entries, got %d", rtbl.Size(), len(seen))
+	}
+	for pfx, n := range seen {
+		if n != 1 {
+			t.Fatalf("GroupBySupernet, %s seen %d times", pfx, n)
+		}
+	}
+}
+
+func TestHostsCB(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 8)
+	rt.Insert(mpp("10.1.2.0/30"), 30)
+	rt.Insert(mpp("10.1.2.2/31"), 31)
+	rt.Insert(mpp("192.168.1.1/32"), 32)
+	rt.Insert(mpp("2001:db8::/126"), 126)
+	rt.Insert(mpp("2001::/16"), 16)
+
+	type hostVal struct {
+		ip  netip.Addr
+		val int
+	}
+
+	var got []hostVal
+	rt.Hosts(2)(func(ip netip.Addr, val int) bool {
+		got = append(got, hostVal{ip, val})
+		return true
+	})
+
+	want := []hostVal{
+		{mpa("10.1.2.0"), 30},
+		{mpa("10.1.2.1"), 30},
+		{mpa("10.1.2.2"), 30},
+		{mpa("10.1.2.3"), 30},
+		{mpa("10.1.2.2"), 31},
+		{mpa("10.1.2.3"), 31},
+		{mpa("192.168.1.1"), 32},
+		{mpa("2001:db8::"), 126},
+		{mpa("2001:db8::1"), 126},
+		{mpa("2001:db8::2"), 126},
+		{mpa("2001:db8::3"), 126},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Hosts(2) = %v, want %v", got, want)
+	}
+
+	// only host routes
+	count := 0
+	rt.Hosts(0)(func(netip.Addr, int) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Errorf("Hosts(0), expected 1 host, got %d", count)
+	}
+
+	// the host bits bound both IP versions alike
+	rt6 := new(Table[int])
+	rt6.Insert(mpp("10.0.0.0/28"), 28)
+	rt6.Insert(mpp("2001:db8::/124"), 124)
+	rt6.Insert(mpp("2001:db8::/32"), 32)
+
+	for _, tt := range []struct {
+		maxHostBits int
+		want        int
+	}{
+		{4, 16 + 16},
+		{3, 0},
+		{28, 16 + 16},
+	} {
+		count = 0
+		rt6.Hosts(tt.maxHostBits)(func(ip netip.Addr, val int) bool {
+			if val == 32 {
+				t.Fatalf("Hosts(%d), short IPv6 entry not skipped, got %s", tt.maxHostBits, ip)
+			}
+			count++
+			return true
+		})
+		if count != tt.want {
+			t.Errorf("Hosts(%d), expected %d hosts, got %d", tt.maxHostBits, tt.want, count)
+		}
+	}
+
+	// end of address space
+	rt.Insert(mpp("255.255.255.254/31"), 31)
+	var last netip.Addr
+	rt.Hosts(1)(func(ip netip.Addr, _ int) bool {
+		if ip.Is4() {
+			last = ip
+		}
+		return true
+	})
+	if last != mpa("255.255.255.255") {
+		t.Errorf("Hosts(1), expected last host 255.255.255.255, got %s", last)
+	}
+
+	// premature exit
+	count = 0
+	rt.Hosts(2)(func(netip.Addr, int) bool {
+		count++
+		return count < 5
+	})
+	if count != 5 {
+		t.Errorf("Hosts with premature exit, expected 5 hosts, got %d", count)
+	}
+}
+
+func TestRangeByNumericCB(t *testing.T) {
+	t.Parallel()
+
+	type route struct {
+		nextHop string
+		med     int64
+	}
+
+	rt := new(Table[route])
+	rt.Insert(mpp("10.0.0.0/8"), route{"a", 100})
+	rt.Insert(mpp("10.1.0.0/16"), route{"b", 50})
+	rt.Insert(mpp("192.168.0.0/16"), route{"c", 200})
+	rt.Insert(mpp("2001:db8::/32"), route{"d", 150})
+	rt.Insert(mpp("2001:db9::/32"), route{"e", -1})
+
+	med := func(r route) int64 { return r.med }
+
+	tests := []struct {
+		lo, hi int64
+		want   []netip.Prefix
+	}{
+		{100, 200, []netip.Prefix{mpp("10.0.0.0/8"), mpp("192.168.0.0/16"), mpp("2001:db8::/32")}},
+		{50, 50, []netip.Prefix{mpp("10.1.0.0/16")}},
+		{-10, 0, []netip.Prefix{mpp("2001:db9::/32")}},
+		{201, 1000, nil},
+		{200, 100, nil},
+	}
+
+	for _, tt := range tests {
+		var got []netip.Prefix
+		rt.RangeByNumeric(med, tt.lo, tt.hi)(func(pfx netip.Prefix, r route) bool {
+			if r.med < tt.lo || r.med > tt.hi {
+				t.Errorf("RangeByNumeric(%d, %d), unexpected value %d", tt.lo, tt.hi, r.med)
+			}
+			got = append(got, pfx)
+			return true
+		})
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RangeByNumeric(%d, %d) = %v, want %v", tt.lo, tt.hi, got, tt.want)
+		}
+	}
+
+	// premature exit
+	count := 0
+	rt.RangeByNumeric(med, math.MinInt64, math.MaxInt64)(func(netip.Prefix, route) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("RangeByNumeric with premature exit, expected 2 items, got %d", count)
+	}
+}
+
+func TestShadowedByCB(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[string])
+	rt.Insert(mpp("10.0.0.0/16"), "a")
+	rt.Insert(mpp("10.0.1.0/24"), "a")
+	rt.Insert(mpp("10.0.2.0/24"), "a")
+	rt.Insert(mpp("10.0.3.0/24"), "b")
+	rt.Insert(mpp("10.0.4.0/22"), "b")
+	rt.Insert(mpp("10.0.5.0/24"), "a") // closer supernet 10.0.4.0/22 with different value
+	rt.Insert(mpp("10.0.128.0/17"), "a")
+	rt.Insert(mpp("10.0.129.0/24"), "a")
+	rt.Insert(mpp("10.1.0.0/24"), "a") // not covered
+	rt.Insert(mpp("2001:db8::/32"), "a")
+
+	eq := func(a, b string) bool { return a == b }
+
+	tests := []struct {
+		pfx  netip.Prefix
+		val  string
+		want []netip.Prefix
+	}{
+		{
+			pfx: mpp("10.0.0.0/8"),
+			val: "a",
+			want: []netip.Prefix{
+				mpp("10.0.0.0/16"),
+				mpp("10.0.1.0/24"),
+				mpp("10.0.2.0/24"),
+				mpp("10.0.128.0/17"),
+				mpp("10.0.129.0/24"),
+				mpp("10.1.0.0/24"),
+			},
+		},
+		{
+			// exact match is not yielded
+			pfx: mpp("10.0.0.0/16"),
+			val: "a",
+			want: []netip.Prefix{
+				mpp("10.0.1.0/24"),
+				mpp("10.0.2.0/24"),
+				mpp("10.0.128.0/17"),
+				mpp("10.0.129.0/24"),
+			},
+		},
+		{
+			pfx:  mpp("10.0.0.0/8"),
+			val:  "b",
+			want: nil,
+		},
+		{
+			pfx:  mpp("10.0.0.0/21"),
+			val:  "b",
+			want: []netip.Prefix{mpp("10.0.3.0/24"), mpp("10.0.4.0/22")},
+		},
+		{
+			// non canonical prefix
+			pfx:  netip.MustParsePrefix("2001:db8::1/16"),
+			val:  "a",
+			want: []netip.Prefix{mpp("2001:db8::/32")},
+		},
+		{
+			pfx:  netip.Prefix{},
+			val:  "a",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var got []netip.Prefix
+		rt.ShadowedBy(tt.pfx, tt.val, eq)(func(pfx netip.Prefix) bool {
+			got = append(got, pfx)
+			return true
+		})
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ShadowedBy(%s, %q) = %v, want %v", tt.pfx, tt.val, got, tt.want)
+		}
+	}
+
+	// pure query, insert pfx and delete all shadowed, lookups unchanged
+	probes := []netip.Addr{}
+	for _, item := range randomPrefixes4(1_000) {
+		probes = append(probes, item.pfx.Addr())
+	}
+	for _, s := range []string{"10.0.0.0", "10.0.1.1", "10.0.5.5", "10.0.129.9", "10.1.0.1", "10.255.0.0"} {
+		probes = append(probes, mpa(s))
+	}
+
+	want := map[netip.Addr]string{}
+	clone := rt.Clone()
+	clone.Insert(mpp("10.0.0.0/8"), "a")
+	for _, ip := range probes {
+		want[ip], _ = clone.Lookup(ip)
+	}
+
+	if rt.Size() != 10 {
+		t.Fatalf("ShadowedBy changed the table, size %d", rt.Size())
+	}
+
+	var shadowed []netip.Prefix
+	clone.ShadowedBy(mpp("10.0.0.0/8"), "a", eq)(func(pfx netip.Prefix) bool {
+		shadowed = append(shadowed, pfx)
+		return true
+	})
+	for _, pfx := range shadowed {
+		clone.Delete(pfx)
+	}
+
+	for _, ip := range probes {
+		if got, _ := clone.Lookup(ip); got != want[ip] {
+			t.Errorf("Lookup(%s) after deleting shadowed = %q, want %q", ip, got, want[ip])
+		}
+	}
+
+	// premature exit
+	count := 0
+	rt.ShadowedBy(mpp("10.0.0.0/8"), "a", eq)(func(netip.Prefix) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("ShadowedBy with premature exit, expected 2 items, got %d", count)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+
+	if _, _, okPrev, okNext := rt.Neighbors(mpp("10.0.0.0/8")); okPrev || okNext {
+		t.Errorf("empty table, Neighbors, expected (false, false), got (%v, %v)", okPrev, okNext)
+	}
+
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.0.0.0/16",
+		"10.1.0.0/16",
+		"192.168.0.0/16",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	tests := []struct {
+		pfx            netip.Prefix
+		prev, next     netip.Prefix
+		okPrev, okNext bool
+	}{
+		{mpp("0.0.0.0/0"), netip.Prefix{}, mpp("10.0.0.0/8"), false, true},
+		{mpp("10.0.0.0/8"), netip.Prefix{}, mpp("10.0.0.0/16"), false, true},
+		{mpp("10.0.0.0/16"), mpp("10.0.0.0/8"), mpp("10.1.0.0/16"), true, true},
+		{mpp("10.0.0.0/12"), mpp("10.0.0.0/8"), mpp("10.0.0.0/16"), true, true},
+		{mpp("10.0.1.0/24"), mpp("10.0.0.0/16"), mpp("10.1.0.0/16"), true, true},
+		{mpp("192.168.0.0/16"), mpp("10.1.0.0/16"), mpp("2001:db8::/32"), true, true},
+		{mpp("::/0"), mpp("192.168.0.0/16"), mpp("2001:db8::/32"), true, true},
+		{mpp("2001:db8:1::/48"), mpp("2001:db8::/32"), netip.Prefix{}, true, false},
+		{mpp("fe80::/10"), mpp("2001:db8:1::/48"), netip.Prefix{}, true, false},
+		{netip.Prefix{}, netip.Prefix{}, netip.Prefix{}, false, false},
+	}
+
+	for _, tt := range tests {
+		prev, next, okPrev, okNext := rt.Neighbors(tt.pfx)
+		if prev != tt.prev || next != tt.next || okPrev != tt.okPrev || okNext != tt.okNext {
+			t.Errorf("Neighbors(%s), expected (%s, %s, %v, %v), got (%s, %s, %v, %v)",
+				tt.pfx, tt.prev, tt.next, tt.okPrev, tt.okNext, prev, next, okPrev, okNext)
+		}
+	}
+}
+
+func TestNeighborsCompare(t *testing.T) {
+	t.Parallel()
+
+	for range 3 {
+		rt := new(Table[int])
+		for _, item := range randomPrefixes(1_000) {
+			rt.Insert(item.pfx, item.val)
+		}
+
+		var sorted []netip.Prefix
+		rt.AllSorted()(func(pfx netip.Prefix, _ int) bool {
+			sorted = append(sorted, pfx)
+			return true
+		})
+
+		// probe with stored and random prefixes
+		probes := slices.Clone(sorted[:100])
+		for _, item := range randomPrefixes(1_000) {
+			probes = append(probes, item.pfx)
+		}
+
+		for _, pfx := range probes {
+			// brute force, binary search in the sorted slice
+			i, found := slices.BinarySearchFunc(sorted, pfx, cmpPrefix)
+
+			var wantPrev, wantNext netip.Prefix
+			if i > 0 {
+				wantPrev = sorted[i-1]
+			}
+			if found {
+				i++
+			}
+			if i < len(sorted) {
+				wantNext = sorted[i]
+			}
+
+			prev, next, okPrev, okNext := rt.Neighbors(pfx)
+			if prev != wantPrev || next != wantNext || okPrev != wantPrev.IsValid() || okNext != wantNext.IsValid() {
+				t.Fatalf("Neighbors(%s), expected (%s, %s), got (%s, %s, %v, %v)",
+					pfx, wantPrev, wantNext, prev, next, okPrev, okNext)
+			}
+		}
+	}
+}
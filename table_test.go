@@ -10,11 +10,16 @@
 package bart
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"net/netip"
 	"reflect"
 	"runtime"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -679,6 +684,56 @@ func TestContainsCompare(t *testing.T) {
 	}
 }
 
+func TestContainsBytes(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	// path compressed leaves, host routes and partial last octets
+	for _, s := range []string{"192.168.1.1/32", "172.16.0.128/25", "2001:db8::1/128", "2001:db8:2::/47"} {
+		rt.Insert(mpp(s), 1)
+	}
+
+	screened := rt.Clone()
+	screened.EnableTopLevelScreen()
+
+	probes := []netip.Addr{
+		mpa("192.168.1.1"),
+		mpa("192.168.1.2"),
+		mpa("172.16.0.200"),
+		mpa("172.16.0.100"),
+		mpa("2001:db8::1"),
+		mpa("2001:db8::2"),
+		mpa("2001:db8:3:1::"),
+		mpa("::ffff:192.168.1.1"),
+	}
+	for range 10_000 {
+		probes = append(probes, randomAddr())
+	}
+
+	for _, a := range probes {
+		want := rt.Contains(a)
+
+		if got := rt.ContainsBytes(a.AsSlice()); got != want {
+			t.Fatalf("ContainsBytes(%q) = %v, want %v", a, got, want)
+		}
+
+		if got := screened.ContainsBytes(a.AsSlice()); got != want {
+			t.Fatalf("screened ContainsBytes(%q) = %v, want %v", a, got, want)
+		}
+	}
+
+	// invalid length
+	for _, ip := range [][]byte{nil, {}, {10, 0, 0}, {10, 0, 0, 0, 0}} {
+		if rt.ContainsBytes(ip) {
+			t.Errorf("ContainsBytes(%v), invalid length, expected false", ip)
+		}
+	}
+}
+
 func TestLookupCompare(t *testing.T) {
 	// Create large route tables repeatedly, and compare Table's
 	// behavior to a naive and slow but correct implementation.
@@ -724,6 +779,63 @@ func TestLookupCompare(t *testing.T) {
 	}
 }
 
+func TestLookupBatch(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	addrs := make([]netip.Addr, 1_000)
+	for i := range addrs {
+		addrs[i] = randomAddr()
+	}
+	addrs[0] = netip.Addr{}
+
+	vals := make([]int, len(addrs))
+	oks := make([]bool, len(addrs))
+
+	rt.LookupBatch(addrs, vals, oks)
+
+	for i, a := range addrs {
+		wantVal, wantOK := rt.Lookup(a)
+		if !getsEqual(vals[i], oks[i], wantVal, wantOK) {
+			t.Fatalf("LookupBatch[%d](%q) = (%v, %v), want (%v, %v)", i, a, vals[i], oks[i], wantVal, wantOK)
+		}
+	}
+
+	// empty batch
+	rt.LookupBatch(nil, nil, nil)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("LookupBatch with length mismatch, expected panic")
+		}
+	}()
+	rt.LookupBatch(addrs, vals[:1], oks)
+}
+
+func TestLookupBatchAllocs(t *testing.T) {
+	// AllocsPerRun must not be called in parallel tests
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	addrs := make([]netip.Addr, 1_000)
+	for i := range addrs {
+		addrs[i] = randomAddr()
+	}
+
+	vals := make([]int, len(addrs))
+	oks := make([]bool, len(addrs))
+
+	if allocs := testing.AllocsPerRun(100, func() { rt.LookupBatch(addrs, vals, oks) }); allocs != 0 {
+		t.Errorf("LookupBatch, got %v allocs/op, want 0", allocs)
+	}
+}
+
 func TestLookupPrefixUnmasked(t *testing.T) {
 	// test that the pfx must not be masked on input for LookupPrefix
 	t.Parallel()
@@ -874,220 +986,942 @@ func TestLookupPrefixLPMCompare(t *testing.T) {
 	}
 }
 
-func TestInsertShuffled(t *testing.T) {
-	// The order in which you insert prefixes into a route table
-	// should not matter, as long as you're inserting the same set of
-	// routes.
+func TestCommonMatch(t *testing.T) {
 	t.Parallel()
 
-	pfxs := randomPrefixes(1000)
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("10.1.0.0/16"), 2)
+	rt.Insert(mpp("10.1.1.0/24"), 3)
+	rt.Insert(mpp("10.1.1.1/32"), 4)
+	rt.Insert(mpp("2001:db8::/32"), 5)
 
-	for range 10 {
-		pfxs2 := append([]goldTableItem[int](nil), pfxs...)
-		rand.Shuffle(len(pfxs2), func(i, j int) { pfxs2[i], pfxs2[j] = pfxs2[j], pfxs2[i] })
+	tests := []struct {
+		a, b netip.Addr
+		lpm  netip.Prefix
+		val  int
+		ok   bool
+	}{
+		{mpa("10.1.1.1"), mpa("10.1.1.1"), mpp("10.1.1.1/32"), 4, true},
+		{mpa("10.1.1.1"), mpa("10.1.1.2"), mpp("10.1.1.0/24"), 3, true},
+		{mpa("10.1.1.1"), mpa("10.1.2.1"), mpp("10.1.0.0/16"), 2, true},
+		{mpa("10.1.1.1"), mpa("10.2.1.1"), mpp("10.0.0.0/8"), 1, true},
+		{mpa("10.1.1.1"), mpa("11.1.1.1"), netip.Prefix{}, 0, false},
+		{mpa("2001:db8::1"), mpa("2001:db8:ffff::1"), mpp("2001:db8::/32"), 5, true},
+		{mpa("2001:db8::1"), mpa("2001:db9::1"), netip.Prefix{}, 0, false},
+		{mpa("10.1.1.1"), mpa("::ffff:10.1.1.1"), netip.Prefix{}, 0, false},
+		{mpa("10.1.1.1"), netip.Addr{}, netip.Prefix{}, 0, false},
+	}
 
-		addrs := make([]netip.Addr, 0, 10_000)
-		for range 10_000 {
-			addrs = append(addrs, randomAddr())
+	for _, tt := range tests {
+		lpm, val, ok := rt.CommonMatch(tt.a, tt.b)
+		if lpm != tt.lpm || val != tt.val || ok != tt.ok {
+			t.Errorf("CommonMatch(%s, %s) = (%s, %d, %v), want (%s, %d, %v)",
+				tt.a, tt.b, lpm, val, ok, tt.lpm, tt.val, tt.ok)
 		}
+	}
 
-		rt1 := new(Table[int])
-		rt2 := new(Table[int])
+	// compare with the longest supernet of a containing b
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
 
-		for _, pfx := range pfxs {
-			rt1.Insert(pfx.pfx, pfx.val)
-		}
-		for _, pfx := range pfxs2 {
-			rt2.Insert(pfx.pfx, pfx.val)
-		}
+	for range 10_000 {
+		a := randomAddr()
 
-		for _, a := range addrs {
-			val1, ok1 := rt1.Lookup(a)
-			val2, ok2 := rt2.Lookup(a)
+		// a close neighbor, random addresses diverge at the first bits
+		b16 := a.As16()
+		b16[prng.IntN(16)] ^= byte(prng.IntN(256))
+		b := netip.AddrFrom16(b16)
+		if a.Is4() {
+			b = b.Unmap()
+		}
 
-			if !getsEqual(val1, ok1, val2, ok2) {
-				t.Fatalf("Lookup(%q) = (%v, %v), want (%v, %v)", a, val2, ok2, val1, ok1)
+		var want netip.Prefix
+		var wantOK bool
+		rt.Supernets(netip.PrefixFrom(a, a.BitLen()))(func(p netip.Prefix, _ int) bool {
+			if p.Contains(b) {
+				want, wantOK = p, true
+				return false
 			}
+			return true
+		})
+
+		got, _, ok := rt.CommonMatch(a, b)
+		if got != want || ok != wantOK {
+			t.Fatalf("CommonMatch(%s, %s) = (%s, %v), want (%s, %v)", a, b, got, ok, want, wantOK)
 		}
 	}
 }
 
-func TestDeleteCompare(t *testing.T) {
-	// Create large route tables repeatedly, delete half of their
-	// prefixes, and compare Table's behavior to a naive and slow but
-	// correct implementation.
+func TestLookupOrGetOr(t *testing.T) {
 	t.Parallel()
 
-	const (
-		numPrefixes  = 10_000 // total prefixes to insert (test deletes 50% of them)
-		numPerFamily = numPrefixes / 2
-		deleteCut    = numPerFamily / 2
-		numProbes    = 10_000 // random addr lookups to do
-	)
-
-	// We have to do this little dance instead of just using allPrefixes,
-	// because we want pfxs and toDelete to be non-overlapping sets.
-	all4, all6 := randomPrefixes4(numPerFamily), randomPrefixes6(numPerFamily)
-
-	pfxs := append([]goldTableItem[int](nil), all4[:deleteCut]...)
-	pfxs = append(pfxs, all6[:deleteCut]...)
-
-	toDelete := append([]goldTableItem[int](nil), all4[deleteCut:]...)
-	toDelete = append(toDelete, all6[deleteCut:]...)
-
-	fast := new(Table[int])
-	gold := new(goldTable[int]).insertMany(pfxs)
+	rt := new(Table[string])
+	rt.Insert(mpp("10.0.0.0/8"), "allow")
+	rt.Insert(mpp("2001:db8::/32"), "allow6")
 
-	for _, pfx := range pfxs {
-		fast.Insert(pfx.pfx, pfx.val)
+	tests := []struct {
+		ip   netip.Addr
+		want string
+	}{
+		{mpa("10.1.2.3"), "allow"},
+		{mpa("11.1.2.3"), "deny"},
+		{mpa("2001:db8::1"), "allow6"},
+		{mpa("2001:db9::1"), "deny"},
+		{netip.Addr{}, "deny"},
 	}
 
-	for _, pfx := range toDelete {
-		fast.Insert(pfx.pfx, pfx.val)
-	}
-	for _, pfx := range toDelete {
-		fast.Delete(pfx.pfx)
+	for _, tt := range tests {
+		if got := rt.LookupOr(tt.ip, "deny"); got != tt.want {
+			t.Errorf("LookupOr(%s), got %q, want %q", tt.ip, got, tt.want)
+		}
 	}
 
-	seenVals4 := map[int]bool{}
-	seenVals6 := map[int]bool{}
-
-	for range numProbes {
-		a := randomAddr()
-
-		goldVal, goldOK := gold.lookup(a)
-		fastVal, fastOK := fast.Lookup(a)
-
-		if !getsEqual(goldVal, goldOK, fastVal, fastOK) {
-			t.Fatalf("Lookup(%q) = (%v, %v), want (%v, %v)", a, fastVal, fastOK, goldVal, goldOK)
-		}
+	pfxTests := []struct {
+		pfx  netip.Prefix
+		want string
+	}{
+		{mpp("10.0.0.0/8"), "allow"},
+		{netip.MustParsePrefix("10.1.2.3/8"), "allow"},
+		{mpp("10.0.0.0/9"), "deny"},
+		{mpp("2001:db8::/32"), "allow6"},
+		{netip.Prefix{}, "deny"},
+	}
 
-		if a.Is6() {
-			seenVals6[fastVal] = true
-		} else {
-			seenVals4[fastVal] = true
+	for _, tt := range pfxTests {
+		if got := rt.GetOr(tt.pfx, "deny"); got != tt.want {
+			t.Errorf("GetOr(%s), got %q, want %q", tt.pfx, got, tt.want)
 		}
 	}
-	// Empirically, 10k probes into 5k v4 prefixes and 5k v6 prefixes results in
-	// ~1k distinct values for v4 and ~300 for v6. distinct routes. This sanity
-	// check that we didn't just return a single route for everything should be
-	// very generous indeed.
-	if cnt := len(seenVals4); cnt < 10 {
-		t.Fatalf("saw %d distinct v4 route results, statistically expected ~1000", cnt)
+
+	// a stored zero value is not replaced by def
+	rt.Insert(mpp("192.168.0.0/16"), "")
+	if got := rt.LookupOr(mpa("192.168.1.1"), "deny"); got != "" {
+		t.Errorf("LookupOr, stored zero value, got %q, want %q", got, "")
 	}
-	if cnt := len(seenVals6); cnt < 10 {
-		t.Fatalf("saw %d distinct v6 route results, statistically expected ~300", cnt)
+	if got := rt.GetOr(mpp("192.168.0.0/16"), "deny"); got != "" {
+		t.Errorf("GetOr, stored zero value, got %q, want %q", got, "")
 	}
 }
 
-func TestDeleteShuffled(t *testing.T) {
-	// The order in which you delete prefixes from a route table
-	// should not matter, as long as you're deleting the same set of
-	// routes.
+func TestNearestByPrefix(t *testing.T) {
 	t.Parallel()
 
-	const (
-		numPrefixes  = 10_000 // prefixes to insert (test deletes 50% of them)
-		numPerFamily = numPrefixes / 2
-		deleteCut    = numPerFamily / 2
-		numProbes    = 10_000 // random addr lookups to do
-	)
-
-	// We have to do this little dance instead of just using allPrefixes,
-	// because we want pfxs and toDelete to be non-overlapping sets.
-	all4, all6 := randomPrefixes4(numPerFamily), randomPrefixes6(numPerFamily)
-
-	pfxs := append([]goldTableItem[int](nil), all4[:deleteCut]...)
-	pfxs = append(pfxs, all6[:deleteCut]...)
+	rt := new(Table[int])
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.0/24",
+		"10.1.3.0/24",
+		"10.2.0.0/16",
+		"192.168.0.0/16",
+		"2001:db8::/32",
+	} {
+		rt.Insert(mpp(s), i)
+	}
 
-	toDelete := append([]goldTableItem[int](nil), all4[deleteCut:]...)
-	toDelete = append(toDelete, all6[deleteCut:]...)
+	collect := func(pfx netip.Prefix, k int) []netip.Prefix {
+		var result []netip.Prefix
+		rt.NearestByPrefix(pfx, k)(func(p netip.Prefix, _ int) bool {
+			result = append(result, p)
+			return true
+		})
+		return result
+	}
 
-	rt1 := new(Table[int])
-	for _, pfx := range pfxs {
-		rt1.Insert(pfx.pfx, pfx.val)
+	tests := []struct {
+		pfx  netip.Prefix
+		k    int
+		want []netip.Prefix
+	}{
+		{mpp("10.1.2.0/24"), 1, []netip.Prefix{mpp("10.1.2.0/24")}},
+		{mpp("10.1.2.0/24"), 3, []netip.Prefix{mpp("10.1.2.0/24"), mpp("10.1.3.0/24"), mpp("10.1.0.0/16")}},
+		{mpp("10.1.4.0/24"), 3, []netip.Prefix{mpp("10.1.2.0/24"), mpp("10.1.3.0/24"), mpp("10.1.0.0/16")}},
+		{mpp("10.3.0.0/16"), 2, []netip.Prefix{mpp("10.2.0.0/16"), mpp("10.1.0.0/16")}},
+		{mpp("11.0.0.0/8"), 1, []netip.Prefix{mpp("10.0.0.0/8")}},
+		{mpp("10.1.2.0/24"), 100, []netip.Prefix{
+			mpp("10.1.2.0/24"), mpp("10.1.3.0/24"), mpp("10.1.0.0/16"), mpp("10.2.0.0/16"),
+			mpp("10.0.0.0/8"), mpp("192.168.0.0/16"),
+		}},
+		{mpp("2001:db9::/32"), 5, []netip.Prefix{mpp("2001:db8::/32")}},
+		{mpp("10.1.2.0/24"), 0, nil},
+		{netip.Prefix{}, 1, nil},
 	}
-	for _, pfx := range toDelete {
-		rt1.Insert(pfx.pfx, pfx.val)
+
+	for _, tt := range tests {
+		if got := collect(tt.pfx, tt.k); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NearestByPrefix(%s, %d) = %v, want %v", tt.pfx, tt.k, got, tt.want)
+		}
 	}
-	for _, pfx := range toDelete {
-		rt1.Delete(pfx.pfx)
+
+	// compare with sorting all prefixes by distance
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		rt.Insert(item.pfx, item.val)
 	}
 
-	for range 10 {
-		pfxs2 := append([]goldTableItem[int](nil), pfxs...)
-		toDelete2 := append([]goldTableItem[int](nil), toDelete...)
-		rand.Shuffle(len(toDelete2), func(i, j int) { toDelete2[i], toDelete2[j] = toDelete2[j], toDelete2[i] })
-		rt2 := new(Table[int])
-		for _, pfx := range pfxs2 {
-			rt2.Insert(pfx.pfx, pfx.val)
-		}
-		for _, pfx := range toDelete2 {
-			rt2.Insert(pfx.pfx, pfx.val)
+	all := rt.ToSlice()
+
+	for _, probe := range randomPrefixes(100) {
+		pfx := probe.pfx
+
+		dist := func(p netip.Prefix) int {
+			return min(commonBits(pfx.Addr(), p.Addr()), pfx.Bits(), p.Bits())
 		}
-		for _, pfx := range toDelete2 {
-			rt2.Delete(pfx.pfx)
+
+		var want []netip.Prefix
+		for _, e := range all {
+			if e.Prefix.Addr().Is4() == pfx.Addr().Is4() {
+				want = append(want, e.Prefix)
+			}
 		}
 
-		// Diffing a deep tree of tables gives cmp.Diff a nervous breakdown, so
-		// test for equivalence statistically with random probes instead.
-		for range numProbes {
-			a := randomAddr()
-			val1, ok1 := rt1.Lookup(a)
-			val2, ok2 := rt2.Lookup(a)
-			if !getsEqual(val1, ok1, val2, ok2) {
-				t.Errorf("Lookup(%q) = (%v, %v), want (%v, %v)", a, val2, ok2, val1, ok1)
+		slices.SortStableFunc(want, func(a, b netip.Prefix) int {
+			if da, db := dist(a), dist(b); da != db {
+				return db - da
 			}
+			return cmpPrefix(a, b)
+		})
+
+		k := prng.IntN(20) + 1
+		want = want[:min(k, len(want))]
+
+		if got := collect(pfx, k); !slices.Equal(got, want) {
+			t.Fatalf("NearestByPrefix(%s, %d) = %v, want %v", pfx, k, got, want)
 		}
 	}
 }
 
-func TestDeleteIsReverseOfInsert(t *testing.T) {
+func TestLookupShorterThan(t *testing.T) {
 	t.Parallel()
-	// Insert N prefixes, then delete those same prefixes in reverse
-	// order. Each deletion should exactly undo the internal structure
-	// changes that each insert did.
-	const N = 10_000
 
-	tbl := new(Table[int])
-	want := tbl.dumpString()
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("10.1.0.0/16"), 2)
+	rt.Insert(mpp("10.1.1.1/32"), 3)
+	rt.Insert(mpp("2001:db8::1/128"), 4)
 
-	prefixes := randomPrefixes(N)
+	tests := []struct {
+		ip      netip.Addr
+		maxBits int
+		lpm     netip.Prefix
+		val     int
+		ok      bool
+	}{
+		{mpa("10.1.1.1"), 33, mpp("10.1.1.1/32"), 3, true},
+		{mpa("10.1.1.1"), 99, mpp("10.1.1.1/32"), 3, true},
+		{mpa("10.1.1.1"), 32, mpp("10.1.0.0/16"), 2, true},
+		{mpa("10.1.1.1"), 17, mpp("10.1.0.0/16"), 2, true},
+		{mpa("10.1.1.1"), 16, mpp("10.0.0.0/8"), 1, true},
+		{mpa("10.1.1.1"), 8, netip.Prefix{}, 0, false},
+		{mpa("10.1.1.1"), 0, netip.Prefix{}, 0, false},
+		{mpa("10.1.1.1"), -1, netip.Prefix{}, 0, false},
+		{mpa("2001:db8::1"), 128, netip.Prefix{}, 0, false},
+		{mpa("2001:db8::1"), 129, mpp("2001:db8::1/128"), 4, true},
+		{netip.Addr{}, 32, netip.Prefix{}, 0, false},
+	}
 
-	defer func() {
-		if t.Failed() {
-			t.Logf("the prefixes that fail the test: %v\n", prefixes)
+	for _, tt := range tests {
+		lpm, val, ok := rt.LookupShorterThan(tt.ip, tt.maxBits)
+		if lpm != tt.lpm || val != tt.val || ok != tt.ok {
+			t.Errorf("LookupShorterThan(%s, %d) = (%s, %d, %v), want (%s, %d, %v)",
+				tt.ip, tt.maxBits, lpm, val, ok, tt.lpm, tt.val, tt.ok)
 		}
-	}()
-
-	for _, p := range prefixes {
-		tbl.Insert(p.pfx, p.val)
 	}
 
-	for i := len(prefixes) - 1; i >= 0; i-- {
-		tbl.Delete(prefixes[i].pfx)
+	// compare with the supernets filtered by length
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
 	}
-	if got := tbl.dumpString(); got != want {
-		t.Fatalf("after delete, mismatch:\n\n got: %s\n\nwant: %s", got, want)
+
+	for range 10_000 {
+		ip := randomAddr()
+		maxBits := prng.IntN(ip.BitLen() + 2)
+
+		var want netip.Prefix
+		var wantVal int
+		var wantOK bool
+		rt.Supernets(netip.PrefixFrom(ip, ip.BitLen()))(func(p netip.Prefix, v int) bool {
+			if p.Bits() < maxBits {
+				want, wantVal, wantOK = p, v, true
+				return false
+			}
+			return true
+		})
+
+		got, val, ok := rt.LookupShorterThan(ip, maxBits)
+		if got != want || val != wantVal || ok != wantOK {
+			t.Fatalf("LookupShorterThan(%s, %d) = (%s, %d, %v), want (%s, %d, %v)",
+				ip, maxBits, got, val, ok, want, wantVal, wantOK)
+		}
 	}
 }
 
-func TestGetAndDelete(t *testing.T) {
+func TestLookupPrefixLPM2(t *testing.T) {
 	t.Parallel()
-	// Insert N prefixes, then delete those same prefixes in shuffled
-	// order.
-	const N = 10_000
 
-	tbl := new(Table[int])
-	prefixes := randomPrefixes(N)
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 8)
+	rt.Insert(mpp("10.1.0.0/16"), 16)
+	rt.Insert(mpp("10.1.2.0/24"), 24)
 
-	// insert the prefixes
-	for _, p := range prefixes {
-		tbl.Insert(p.pfx, p.val)
+	tests := []struct {
+		pfx    netip.Prefix
+		best   netip.Prefix
+		second netip.Prefix
+		n      int
+	}{
+		{mpp("10.1.2.3/32"), mpp("10.1.2.0/24"), mpp("10.1.0.0/16"), 2},
+		{mpp("10.1.2.0/24"), mpp("10.1.2.0/24"), mpp("10.1.0.0/16"), 2},
+		{mpp("10.1.3.0/24"), mpp("10.1.0.0/16"), mpp("10.0.0.0/8"), 2},
+		{mpp("10.2.0.0/16"), mpp("10.0.0.0/8"), netip.Prefix{}, 1},
+		{mpp("11.0.0.0/8"), netip.Prefix{}, netip.Prefix{}, 0},
+		{netip.Prefix{}, netip.Prefix{}, netip.Prefix{}, 0},
 	}
 
-	// shuffle the prefixes
-	rand.Shuffle(N, func(i, j int) {
+	for _, tt := range tests {
+		best, second, bestVal, secondVal, n := rt.LookupPrefixLPM2(tt.pfx)
+		if best != tt.best || second != tt.second || n != tt.n {
+			t.Errorf("LookupPrefixLPM2(%s), expected (%s, %s, %d), got (%s, %s, %d)",
+				tt.pfx, tt.best, tt.second, tt.n, best, second, n)
+		}
+
+		if n > 0 && bestVal != best.Bits() {
+			t.Errorf("LookupPrefixLPM2(%s), bestVal expected %d, got %d", tt.pfx, best.Bits(), bestVal)
+		}
+		if n > 1 && secondVal != second.Bits() {
+			t.Errorf("LookupPrefixLPM2(%s), secondVal expected %d, got %d", tt.pfx, second.Bits(), secondVal)
+		}
+	}
+
+	// compare with gold
+	pfxs := randomPrefixes(10_000)
+	fast := new(Table[int])
+	gold := new(goldTable[int]).insertMany(pfxs)
+
+	for _, item := range pfxs {
+		fast.Insert(item.pfx, item.val)
+	}
+
+	for _, pfx := range randomPrefixes(1_000) {
+		want := gold.supernets(pfx.pfx)
+		best, second, _, _, n := fast.LookupPrefixLPM2(pfx.pfx)
+
+		if n != min(len(want), 2) {
+			t.Fatalf("LookupPrefixLPM2(%s), expected n=%d, got %d", pfx.pfx, min(len(want), 2), n)
+		}
+		if n > 0 && best != want[0] {
+			t.Fatalf("LookupPrefixLPM2(%s), expected best %s, got %s", pfx.pfx, want[0], best)
+		}
+		if n > 1 && second != want[1] {
+			t.Fatalf("LookupPrefixLPM2(%s), expected second %s, got %s", pfx.pfx, want[1], second)
+		}
+	}
+}
+
+func TestIsEmptyAny(t *testing.T) {
+	t.Parallel()
+
+	var nilTbl *Table[int]
+	if !nilTbl.IsEmpty() || nilTbl.Any4() || nilTbl.Any6() {
+		t.Errorf("nil table, expected IsEmpty and no Any4/Any6")
+	}
+
+	rt := new(Table[int])
+	if !rt.IsEmpty() || rt.Any4() || rt.Any6() {
+		t.Errorf("zero table, expected IsEmpty and no Any4/Any6")
+	}
+
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	if rt.IsEmpty() || !rt.Any4() || rt.Any6() {
+		t.Errorf("IPv4 only, IsEmpty: %v, Any4: %v, Any6: %v", rt.IsEmpty(), rt.Any4(), rt.Any6())
+	}
+
+	rt.Insert(mpp("2001:db8::/32"), 2)
+	if rt.IsEmpty() || !rt.Any4() || !rt.Any6() {
+		t.Errorf("IPv4 and IPv6, IsEmpty: %v, Any4: %v, Any6: %v", rt.IsEmpty(), rt.Any4(), rt.Any6())
+	}
+
+	rt.Delete(mpp("10.0.0.0/8"))
+	rt.Delete(mpp("2001:db8::/32"))
+	if !rt.IsEmpty() || rt.Any4() || rt.Any6() {
+		t.Errorf("after delete, expected IsEmpty and no Any4/Any6")
+	}
+
+	// consistent with Size after random inserts and deletes
+	pfxs := randomPrefixes(1_000)
+	for _, item := range pfxs {
+		rt.Insert(item.pfx, item.val)
+	}
+	for _, item := range pfxs {
+		if rt.IsEmpty() != (rt.Size() == 0) || rt.Any4() != (rt.Size4() > 0) || rt.Any6() != (rt.Size6() > 0) {
+			t.Fatalf("IsEmpty/Any4/Any6 inconsistent with Size4: %d, Size6: %d", rt.Size4(), rt.Size6())
+		}
+		rt.Delete(item.pfx)
+	}
+	if !rt.IsEmpty() {
+		t.Errorf("after deleting all, expected IsEmpty")
+	}
+}
+
+func TestFamily(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		x   any
+		is4 bool
+		ok  bool
+	}{
+		{mpa("10.0.0.1"), true, true},
+		{mpa("2001:db8::1"), false, true},
+		{mpa("::ffff:10.0.0.1"), false, true},
+		{mpp("10.0.0.0/8"), true, true},
+		{mpp("2001:db8::/32"), false, true},
+		{mpp("::ffff:10.0.0.0/104"), false, true},
+		{netip.Addr{}, false, false},
+		{netip.Prefix{}, false, false},
+		{"10.0.0.1", false, false},
+		{nil, false, false},
+	}
+
+	for _, tt := range tests {
+		is4, ok := Family(tt.x)
+		if is4 != tt.is4 || ok != tt.ok {
+			t.Errorf("Family(%v) = (%v, %v), want (%v, %v)", tt.x, is4, ok, tt.is4, tt.ok)
+		}
+	}
+
+	// consistent with the table dispatch
+	rt := new(Table[int])
+	rt.Insert(mpp("::ffff:10.0.0.0/104"), 1)
+	if is4, _ := Family(mpp("::ffff:10.0.0.0/104")); is4 != (rt.Size4() == 1) {
+		t.Errorf("Family, IPv4-mapped prefix, inconsistent with Size4")
+	}
+}
+
+func TestRootFamilyCounts(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if n4, n6 := rt.RootFamilyCounts(); n4 != 0 || n6 != 0 {
+		t.Errorf("RootFamilyCounts, empty table, got (%d, %d), want (0, 0)", n4, n6)
+	}
+
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	if n4, n6 := rt.RootFamilyCounts(); n4 != 1 || n6 != 0 {
+		t.Errorf("RootFamilyCounts, got (%d, %d), want (1, 0)", n4, n6)
+	}
+
+	// 10.1.0.0/16 and 10.1.1.0/24 share the first octet, a new node is created
+	rt.Insert(mpp("10.1.0.0/16"), 2)
+	rt.Insert(mpp("10.1.1.0/24"), 3)
+	rt.Insert(mpp("2001:db8::/32"), 4)
+	if n4, n6 := rt.RootFamilyCounts(); n4 != 2 || n6 != 1 {
+		t.Errorf("RootFamilyCounts, got (%d, %d), want (2, 1)", n4, n6)
+	}
+
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	n4, n6 := rt.RootFamilyCounts()
+	if n4 != rt.root4.nodeStatsRec().nodes || n6 != rt.root6.nodeStatsRec().nodes {
+		t.Errorf("RootFamilyCounts, got (%d, %d), inconsistent with nodeStatsRec", n4, n6)
+	}
+}
+
+func TestDepthAndImbalance(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if d4, d6 := rt.Depth(); d4 != 0 || d6 != 0 {
+		t.Errorf("empty table, Depth, expected (0, 0), got (%d, %d)", d4, d6)
+	}
+	if got := rt.Imbalance(); got != 0 {
+		t.Errorf("empty table, Imbalance, expected 0, got %v", got)
+	}
+
+	tests := []struct {
+		pfx       string
+		d4, d6    int
+		imbalance float64
+	}{
+		{"10.0.0.0/8", 1, 0, 1.0},
+		{"10.1.2.0/24", 1, 0, 1.0}, // path compressed leaf in root node
+		{"10.1.3.0/24", 3, 0, 3.0 / (7.0 / 3.0)},
+		{"2001:db8::1/128", 3, 1, 3.0 / 2.0},
+		{"2001:db8::2/128", 3, 16, 16.0 / (39.0 / 5.0)}, // IPv6 naturally reaches depth 16
+	}
+
+	for _, tt := range tests {
+		rt.Insert(mpp(tt.pfx), 1)
+
+		if d4, d6 := rt.Depth(); d4 != tt.d4 || d6 != tt.d6 {
+			t.Errorf("Insert(%s), Depth, expected (%d, %d), got (%d, %d)", tt.pfx, tt.d4, tt.d6, d4, d6)
+		}
+
+		if got := rt.Imbalance(); math.Abs(got-tt.imbalance) > 1e-9 {
+			t.Errorf("Insert(%s), Imbalance, expected %v, got %v", tt.pfx, tt.imbalance, got)
+		}
+	}
+}
+
+func TestDistributionSummary(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if got := rt.DistributionSummary(); got != (DistSummary{}) {
+		t.Errorf("empty table, DistributionSummary, expected zero value, got %+v", got)
+	}
+
+	for i, s := range []string{
+		"0.0.0.0/0",
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.2.0.0/16",
+		"11.0.0.0/8",
+		"192.168.0.0/24",
+		"2001:db8::/32",
+		"2001:db9::/32",
+		"fe80::/10",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	want := DistSummary{
+		Size4:       6,
+		Size6:       3,
+		MeanBits4:   float64(0+8+16+16+8+24) / 6,
+		MeanBits6:   float64(32+32+10) / 3,
+		TopBucket4:  mpp("10.0.0.0/8"),
+		TopCount4:   3,
+		TopBucket6:  mpp("2001::/16"),
+		TopCount6:   2,
+		Overlapping: 6, // 0.0.0.0/0 with all IPv4 prefixes
+	}
+
+	if got := rt.DistributionSummary(); got != want {
+		t.Errorf("DistributionSummary\ngot:  %+v\nwant: %+v", got, want)
+	}
+
+	// overlapping entries, compared with brute force
+	for range 3 {
+		rt := new(Table[int])
+		pfxs := randomPrefixes(500)
+		for _, item := range pfxs {
+			rt.Insert(item.pfx, item.val)
+		}
+
+		wantOverlapping := 0
+		rt.All()(func(pfx netip.Prefix, _ int) bool {
+			// supernets and subnets include pfx itself
+			n := 0
+			count := func(netip.Prefix, int) bool { n++; return true }
+			rt.Supernets(pfx)(count)
+			rt.Subnets(pfx)(count)
+
+			if n > 2 {
+				wantOverlapping++
+			}
+			return true
+		})
+
+		if got := rt.DistributionSummary(); got.Overlapping != wantOverlapping || got.Size4+got.Size6 != rt.Size() {
+			t.Errorf("DistributionSummary, random, got %+v, want overlapping %d, size %d", got, wantOverlapping, rt.Size())
+		}
+	}
+}
+
+func TestDistributionSummaryOverlapCount(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 10, 100, 1_000, 10_000} {
+		rt := new(Table[int])
+		for _, item := range randomPrefixes(n) {
+			rt.Insert(item.pfx, item.val)
+		}
+
+		if got, want := rt.DistributionSummary().Overlapping, rt.OverlapCount(); got != want {
+			t.Errorf("DistributionSummary, %d prefixes, Overlapping %d, OverlapCount %d", n, got, want)
+		}
+	}
+}
+
+func TestInsertDedup(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	pfx := mpp("10.0.0.0/8")
+
+	if !InsertDedup(rt, pfx, 1) {
+		t.Errorf("InsertDedup, new prefix, expected true")
+	}
+	if InsertDedup(rt, pfx, 1) {
+		t.Errorf("InsertDedup, same prefix and value, expected false")
+	}
+	if !InsertDedup(rt, pfx, 2) {
+		t.Errorf("InsertDedup, changed value, expected true")
+	}
+	if v, _ := rt.Get(pfx); v != 2 || rt.Size() != 1 {
+		t.Errorf("InsertDedup, expected value 2 and size 1, got %d and %d", v, rt.Size())
+	}
+	if InsertDedup(rt, netip.Prefix{}, 1) {
+		t.Errorf("InsertDedup, invalid prefix, expected false")
+	}
+
+	// non comparable payload
+	rs := new(Table[[]string])
+	eq := slices.Equal[[]string]
+	stored := []string{"a", "b"}
+
+	if !rs.InsertDedupFunc(pfx, stored, eq) {
+		t.Errorf("InsertDedupFunc, new prefix, expected true")
+	}
+	if rs.InsertDedupFunc(pfx, []string{"a", "b"}, eq) {
+		t.Errorf("InsertDedupFunc, equal value, expected false")
+	}
+	// not overwritten
+	if v, _ := rs.Get(pfx); &v[0] != &stored[0] {
+		t.Errorf("InsertDedupFunc, equal value, expected stored value unchanged")
+	}
+	if !rs.InsertDedupFunc(pfx, []string{"c"}, eq) {
+		t.Errorf("InsertDedupFunc, changed value, expected true")
+	}
+}
+
+func TestInsertCopy(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[[]byte])
+	pfx := mpp("10.0.0.0/8")
+
+	val := []byte("foo")
+	rt.InsertCopy(pfx, val, slices.Clone[[]byte])
+
+	// change the callers slice
+	val[0] = 'b'
+
+	if got, _ := rt.Get(pfx); string(got) != "foo" {
+		t.Errorf("InsertCopy, stored value aliases caller value, got %q", got)
+	}
+
+	// nil copyFn, Cloner
+	rtc := new(Table[*MyInt])
+	myInt := MyInt(42)
+	rtc.InsertCopy(pfx, &myInt, nil)
+
+	myInt = 43
+	if got, _ := rtc.Get(pfx); *got != 42 {
+		t.Errorf("InsertCopy with nil copyFn, expected cloned value 42, got %d", *got)
+	}
+
+	// invalid prefix, copyFn must not be called
+	rt.InsertCopy(netip.Prefix{}, val, func([]byte) []byte {
+		t.Errorf("InsertCopy with invalid prefix, copyFn called")
+		return nil
+	})
+}
+
+func TestInsertShuffled(t *testing.T) {
+	// The order in which you insert prefixes into a route table
+	// should not matter, as long as you're inserting the same set of
+	// routes.
+	t.Parallel()
+
+	pfxs := randomPrefixes(1000)
+
+	for range 10 {
+		pfxs2 := append([]goldTableItem[int](nil), pfxs...)
+		rand.Shuffle(len(pfxs2), func(i, j int) { pfxs2[i], pfxs2[j] = pfxs2[j], pfxs2[i] })
+
+		addrs := make([]netip.Addr, 0, 10_000)
+		for range 10_000 {
+			addrs = append(addrs, randomAddr())
+		}
+
+		rt1 := new(Table[int])
+		rt2 := new(Table[int])
+
+		for _, pfx := range pfxs {
+			rt1.Insert(pfx.pfx, pfx.val)
+		}
+		for _, pfx := range pfxs2 {
+			rt2.Insert(pfx.pfx, pfx.val)
+		}
+
+		for _, a := range addrs {
+			val1, ok1 := rt1.Lookup(a)
+			val2, ok2 := rt2.Lookup(a)
+
+			if !getsEqual(val1, ok1, val2, ok2) {
+				t.Fatalf("Lookup(%q) = (%v, %v), want (%v, %v)", a, val2, ok2, val1, ok1)
+			}
+		}
+	}
+}
+
+func TestDeleteCompare(t *testing.T) {
+	// Create large route tables repeatedly, delete half of their
+	// prefixes, and compare Table's behavior to a naive and slow but
+	// correct implementation.
+	t.Parallel()
+
+	const (
+		numPrefixes  = 10_000 // total prefixes to insert (test deletes 50% of them)
+		numPerFamily = numPrefixes / 2
+		deleteCut    = numPerFamily / 2
+		numProbes    = 10_000 // random addr lookups to do
+	)
+
+	// We have to do this little dance instead of just using allPrefixes,
+	// because we want pfxs and toDelete to be non-overlapping sets.
+	all4, all6 := randomPrefixes4(numPerFamily), randomPrefixes6(numPerFamily)
+
+	pfxs := append([]goldTableItem[int](nil), all4[:deleteCut]...)
+	pfxs = append(pfxs, all6[:deleteCut]...)
+
+	toDelete := append([]goldTableItem[int](nil), all4[deleteCut:]...)
+	toDelete = append(toDelete, all6[deleteCut:]...)
+
+	fast := new(Table[int])
+	gold := new(goldTable[int]).insertMany(pfxs)
+
+	for _, pfx := range pfxs {
+		fast.Insert(pfx.pfx, pfx.val)
+	}
+
+	for _, pfx := range toDelete {
+		fast.Insert(pfx.pfx, pfx.val)
+	}
+	for _, pfx := range toDelete {
+		fast.Delete(pfx.pfx)
+	}
+
+	seenVals4 := map[int]bool{}
+	seenVals6 := map[int]bool{}
+
+	for range numProbes {
+		a := randomAddr()
+
+		goldVal, goldOK := gold.lookup(a)
+		fastVal, fastOK := fast.Lookup(a)
+
+		if !getsEqual(goldVal, goldOK, fastVal, fastOK) {
+			t.Fatalf("Lookup(%q) = (%v, %v), want (%v, %v)", a, fastVal, fastOK, goldVal, goldOK)
+		}
+
+		if a.Is6() {
+			seenVals6[fastVal] = true
+		} else {
+			seenVals4[fastVal] = true
+		}
+	}
+	// Empirically, 10k probes into 5k v4 prefixes and 5k v6 prefixes results in
+	// ~1k distinct values for v4 and ~300 for v6. distinct routes. This sanity
+	// check that we didn't just return a single route for everything should be
+	// very generous indeed.
+	if cnt := len(seenVals4); cnt < 10 {
+		t.Fatalf("saw %d distinct v4 route results, statistically expected ~1000", cnt)
+	}
+	if cnt := len(seenVals6); cnt < 10 {
+		t.Fatalf("saw %d distinct v6 route results, statistically expected ~300", cnt)
+	}
+}
+
+func TestDeleteShuffled(t *testing.T) {
+	// The order in which you delete prefixes from a route table
+	// should not matter, as long as you're deleting the same set of
+	// routes.
+	t.Parallel()
+
+	const (
+		numPrefixes  = 10_000 // prefixes to insert (test deletes 50% of them)
+		numPerFamily = numPrefixes / 2
+		deleteCut    = numPerFamily / 2
+		numProbes    = 10_000 // random addr lookups to do
+	)
+
+	// We have to do this little dance instead of just using allPrefixes,
+	// because we want pfxs and toDelete to be non-overlapping sets.
+	all4, all6 := randomPrefixes4(numPerFamily), randomPrefixes6(numPerFamily)
+
+	pfxs := append([]goldTableItem[int](nil), all4[:deleteCut]...)
+	pfxs = append(pfxs, all6[:deleteCut]...)
+
+	toDelete := append([]goldTableItem[int](nil), all4[deleteCut:]...)
+	toDelete = append(toDelete, all6[deleteCut:]...)
+
+	rt1 := new(Table[int])
+	for _, pfx := range pfxs {
+		rt1.Insert(pfx.pfx, pfx.val)
+	}
+	for _, pfx := range toDelete {
+		rt1.Insert(pfx.pfx, pfx.val)
+	}
+	for _, pfx := range toDelete {
+		rt1.Delete(pfx.pfx)
+	}
+
+	for range 10 {
+		pfxs2 := append([]goldTableItem[int](nil), pfxs...)
+		toDelete2 := append([]goldTableItem[int](nil), toDelete...)
+		rand.Shuffle(len(toDelete2), func(i, j int) { toDelete2[i], toDelete2[j] = toDelete2[j], toDelete2[i] })
+		rt2 := new(Table[int])
+		for _, pfx := range pfxs2 {
+			rt2.Insert(pfx.pfx, pfx.val)
+		}
+		for _, pfx := range toDelete2 {
+			rt2.Insert(pfx.pfx, pfx.val)
+		}
+		for _, pfx := range toDelete2 {
+			rt2.Delete(pfx.pfx)
+		}
+
+		// Diffing a deep tree of tables gives cmp.Diff a nervous breakdown, so
+		// test for equivalence statistically with random probes instead.
+		for range numProbes {
+			a := randomAddr()
+			val1, ok1 := rt1.Lookup(a)
+			val2, ok2 := rt2.Lookup(a)
+			if !getsEqual(val1, ok1, val2, ok2) {
+				t.Errorf("Lookup(%q) = (%v, %v), want (%v, %v)", a, val2, ok2, val1, ok1)
+			}
+		}
+	}
+}
+
+func TestDeleteIsReverseOfInsert(t *testing.T) {
+	t.Parallel()
+	// Insert N prefixes, then delete those same prefixes in reverse
+	// order. Each deletion should exactly undo the internal structure
+	// changes that each insert did.
+	const N = 10_000
+
+	tbl := new(Table[int])
+	want := tbl.dumpString()
+
+	prefixes := randomPrefixes(N)
+
+	defer func() {
+		if t.Failed() {
+			t.Logf("the prefixes that fail the test: %v\n", prefixes)
+		}
+	}()
+
+	for _, p := range prefixes {
+		tbl.Insert(p.pfx, p.val)
+	}
+
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		tbl.Delete(prefixes[i].pfx)
+	}
+	if got := tbl.dumpString(); got != want {
+		t.Fatalf("after delete, mismatch:\n\n got: %s\n\nwant: %s", got, want)
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if deleted, freed := rt.DeleteAll(nil); deleted != 0 || freed != 0 {
+		t.Errorf("DeleteAll(nil), expected (0, 0), got (%d, %d)", deleted, freed)
+	}
+
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.0/24",
+		"10.1.3.0/24",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	deleted, freed := rt.DeleteAll([]netip.Prefix{
+		netip.MustParsePrefix("10.1.2.1/24"), // non canonical
+		mpp("10.1.3.0/24"),
+		mpp("10.1.3.0/24"), // duplicate
+		mpp("10.9.0.0/16"), // missing
+		{},                 // invalid
+		mpp("2001:db8:1::/48"),
+	})
+
+	if deleted != 3 {
+		t.Errorf("DeleteAll, expected 3 deleted, got %d", deleted)
+	}
+	if freed == 0 {
+		t.Errorf("DeleteAll, expected freed nodes, got 0")
+	}
+	if rt.Size() != 3 {
+		t.Errorf("DeleteAll, expected size 3, got %d", rt.Size())
+	}
+
+	// delete everything, only the empty root nodes are left
+	rt.DeleteAll([]netip.Prefix{mpp("10.0.0.0/8"), mpp("10.1.0.0/16"), mpp("2001:db8::/32")})
+	if got, want := rt.dumpString(), new(Table[int]).dumpString(); got != want {
+		t.Errorf("DeleteAll everything, expected empty table, got:\n%s", got)
+	}
+}
+
+func TestDeleteAllCompare(t *testing.T) {
+	t.Parallel()
+
+	for range 10 {
+		pfxs := randomPrefixes(10_000)
+
+		batch := new(Table[int])
+		for _, item := range pfxs {
+			batch.Insert(item.pfx, item.val)
+		}
+		single := batch.Clone()
+
+		var del []netip.Prefix
+		for i, item := range pfxs {
+			if i%3 != 0 {
+				del = append(del, item.pfx)
+			}
+		}
+
+		for _, pfx := range del {
+			single.Delete(pfx)
+		}
+
+		deleted, _ := batch.DeleteAll(del)
+		if deleted != len(del) {
+			t.Fatalf("DeleteAll, expected %d deleted, got %d", len(del), deleted)
+		}
+
+		if got, want := batch.String(), single.String(); got != want {
+			t.Fatalf("DeleteAll, prefix set differs from single deletes")
+		}
+
+		if got, want := batch.dumpString(), single.dumpString(); got != want {
+			t.Fatalf("DeleteAll, trie differs from single deletes")
+		}
+	}
+}
+
+func TestGetAndDelete(t *testing.T) {
+	t.Parallel()
+	// Insert N prefixes, then delete those same prefixes in shuffled
+	// order.
+	const N = 10_000
+
+	tbl := new(Table[int])
+	prefixes := randomPrefixes(N)
+
+	// insert the prefixes
+	for _, p := range prefixes {
+		tbl.Insert(p.pfx, p.val)
+	}
+
+	// shuffle the prefixes
+	rand.Shuffle(N, func(i, j int) {
 		prefixes[i], prefixes[j] = prefixes[j], prefixes[i]
 	})
 
@@ -1095,102 +1929,421 @@ func TestGetAndDelete(t *testing.T) {
 		want, _ := tbl.Get(p.pfx)
 		val, ok := tbl.GetAndDelete(p.pfx)
 
-		if !ok {
-			t.Errorf("GetAndDelete, expected true, got %v", ok)
-		}
+		if !ok {
+			t.Errorf("GetAndDelete, expected true, got %v", ok)
+		}
+
+		if val != want {
+			t.Errorf("GetAndDelete, expected %v, got %v", want, val)
+		}
+
+		val, ok = tbl.GetAndDelete(p.pfx)
+		if ok {
+			t.Errorf("GetAndDelete, expected false, got (%v, %v)", val, ok)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	t.Run("empty table", func(t *testing.T) {
+		t.Parallel()
+
+		rt := new(Table[int])
+		pfx := randomPrefix()
+		_, ok := rt.Get(pfx)
+
+		if ok {
+			t.Errorf("empty table: Get(%v), ok=%v, expected: %v", pfx, ok, false)
+		}
+	})
+
+	tests := []struct {
+		name string
+		pfx  netip.Prefix
+		val  int
+	}{
+		{
+			name: "default route v4",
+			pfx:  mpp("0.0.0.0/0"),
+			val:  0,
+		},
+		{
+			name: "default route v6",
+			pfx:  mpp("::/0"),
+			val:  0,
+		},
+		{
+			name: "set v4",
+			pfx:  mpp("1.2.3.4/32"),
+			val:  1234,
+		},
+		{
+			name: "set v6",
+			pfx:  mpp("2001:db8::/32"),
+			val:  2001,
+		},
+	}
+
+	rt := new(Table[int])
+	for _, tt := range tests {
+		rt.Insert(tt.pfx, tt.val)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := rt.Get(tt.pfx)
+
+			if !ok {
+				t.Errorf("%s: ok=%v, expected: %v", tt.name, ok, true)
+			}
+
+			if got != tt.val {
+				t.Errorf("%s: val=%v, expected: %v", tt.name, got, tt.val)
+			}
+		})
+	}
+}
+
+func TestGetCompare(t *testing.T) {
+	t.Parallel()
+
+	pfxs := randomPrefixes(10_000)
+	fast := new(Table[int])
+	gold := new(goldTable[int]).insertMany(pfxs)
+
+	for _, pfx := range pfxs {
+		fast.Insert(pfx.pfx, pfx.val)
+	}
+
+	for _, pfx := range pfxs {
+		goldVal, goldOK := gold.get(pfx.pfx)
+		fastVal, fastOK := fast.Get(pfx.pfx)
+
+		if !getsEqual(goldVal, goldOK, fastVal, fastOK) {
+			t.Fatalf("Get(%q) = (%v, %v), want (%v, %v)", pfx.pfx, fastVal, fastOK, goldVal, goldOK)
+		}
+	}
+}
+
+func TestSwapValues(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("0.0.0.0/0"), 0)
+	rt.Insert(mpp("10.0.0.0/8"), 8)
+	rt.Insert(mpp("10.1.2.0/24"), 24)
+	rt.Insert(mpp("2001:db8::/32"), 32)
+
+	if ok := rt.SwapValues(mpp("10.0.0.0/8"), mpp("2001:db8::/32")); !ok {
+		t.Fatalf("SwapValues, expected true, got %v", ok)
+	}
+
+	if ok := rt.SwapValues(mpp("0.0.0.0/0"), mpp("10.1.2.0/24")); !ok {
+		t.Fatalf("SwapValues, expected true, got %v", ok)
+	}
+
+	want := map[netip.Prefix]int{
+		mpp("0.0.0.0/0"):     24,
+		mpp("10.0.0.0/8"):    32,
+		mpp("10.1.2.0/24"):   0,
+		mpp("2001:db8::/32"): 8,
+	}
+
+	for pfx, val := range want {
+		if got, _ := rt.Get(pfx); got != val {
+			t.Errorf("SwapValues, Get(%s), expected %d, got %d", pfx, val, got)
+		}
+	}
+
+	// missing prefix, table unchanged
+	if ok := rt.SwapValues(mpp("10.0.0.0/8"), mpp("11.0.0.0/8")); ok {
+		t.Errorf("SwapValues with missing prefix, expected false, got %v", ok)
+	}
+
+	if ok := rt.SwapValues(netip.Prefix{}, mpp("10.0.0.0/8")); ok {
+		t.Errorf("SwapValues with invalid prefix, expected false, got %v", ok)
+	}
+
+	if got, _ := rt.Get(mpp("10.0.0.0/8")); got != 32 {
+		t.Errorf("SwapValues with missing prefix, table changed, got %d", got)
+	}
+}
+
+func TestDeaggregate(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/24"), 1)
+	rt.Insert(mpp("10.0.0.128/25"), 2)
+	rt.Insert(mpp("10.0.1.1/32"), 3)
+	rt.Insert(mpp("2001:db8::/32"), 4)
+
+	if rt.Deaggregate(mpp("10.0.1.0/24")) {
+		t.Errorf("Deaggregate(10.0.1.0/24), not set, expected false")
+	}
+	if rt.Deaggregate(mpp("10.0.1.1/32")) {
+		t.Errorf("Deaggregate(10.0.1.1/32), host route, expected false")
+	}
+	if rt.Deaggregate(netip.Prefix{}) {
+		t.Errorf("Deaggregate(invalid), expected false")
+	}
+
+	if !rt.Deaggregate(netip.MustParsePrefix("10.0.0.1/24")) {
+		t.Fatalf("Deaggregate(10.0.0.1/24), expected true")
+	}
+
+	if _, ok := rt.Get(mpp("10.0.0.0/24")); ok {
+		t.Errorf("Deaggregate, 10.0.0.0/24 still set")
+	}
+	if val, _ := rt.Get(mpp("10.0.0.0/25")); val != 1 {
+		t.Errorf("Deaggregate, 10.0.0.0/25, got %d, want 1", val)
+	}
+	if val, _ := rt.Get(mpp("10.0.0.128/25")); val != 2 {
+		t.Errorf("Deaggregate, already stored 10.0.0.128/25, got %d, want 2", val)
+	}
+
+	if !rt.Deaggregate(mpp("2001:db8::/32")) {
+		t.Fatalf("Deaggregate(2001:db8::/32), expected true")
+	}
+	for _, pfx := range []netip.Prefix{mpp("2001:db8::/33"), mpp("2001:db8:8000::/33")} {
+		if val, _ := rt.Get(pfx); val != 4 {
+			t.Errorf("Deaggregate, %s, got %d, want 4", pfx, val)
+		}
+	}
+
+	if rt.Size() != 5 {
+		t.Errorf("Deaggregate, Size, got %d, want 5", rt.Size())
+	}
+
+	// lookups unchanged
+	pfxs := randomPrefixes(1_000)
+	probes := randomPrefixes(10_000)
+
+	rt = new(Table[int])
+	for _, item := range pfxs {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	want := make([]int, len(probes))
+	for i, item := range probes {
+		want[i], _ = rt.Lookup(item.pfx.Addr())
+	}
+
+	for _, item := range pfxs {
+		rt.Deaggregate(item.pfx)
+	}
+
+	for i, item := range probes {
+		if got, _ := rt.Lookup(item.pfx.Addr()); got != want[i] {
+			t.Fatalf("Lookup(%s) after Deaggregate, got %d, want %d", item.pfx.Addr(), got, want[i])
+		}
+	}
+}
+
+func TestAggregateSiblings(t *testing.T) {
+	t.Parallel()
+
+	eq := func(a, b int) bool { return a == b }
+
+	rt := new(Table[int])
+	if n := rt.AggregateSiblings(eq); n != 0 {
+		t.Errorf("AggregateSiblings, empty table, got %d, want 0", n)
+	}
+
+	rt.Insert(mpp("10.0.0.0/25"), 1)
+	rt.Insert(mpp("10.0.0.128/25"), 1)
+	rt.Insert(mpp("10.0.1.0/24"), 1)
+	rt.Insert(mpp("10.0.2.0/24"), 2)
+	rt.Insert(mpp("10.0.3.0/24"), 3)
+	rt.Insert(mpp("2001:db8::/33"), 4)
+	rt.Insert(mpp("2001:db8:8000::/33"), 4)
+	rt.Insert(mpp("2001:db8::/32"), 5) // shadowed parent
+
+	if n := rt.AggregateSiblings(eq); n != 2 {
+		t.Errorf("AggregateSiblings, first pass, got %d, want 2", n)
+	}
+	if val, _ := rt.Get(mpp("10.0.0.0/24")); val != 1 {
+		t.Errorf("AggregateSiblings, 10.0.0.0/24, got %d, want 1", val)
+	}
+	if val, _ := rt.Get(mpp("2001:db8::/32")); val != 4 {
+		t.Errorf("AggregateSiblings, 2001:db8::/32, got %d, want 4", val)
+	}
+
+	if n := rt.AggregateSiblings(eq); n != 1 {
+		t.Errorf("AggregateSiblings, second pass, got %d, want 1", n)
+	}
+	if val, _ := rt.Get(mpp("10.0.0.0/23")); val != 1 {
+		t.Errorf("AggregateSiblings, 10.0.0.0/23, got %d, want 1", val)
+	}
+
+	if n := rt.AggregateSiblings(eq); n != 0 {
+		t.Errorf("AggregateSiblings, fixpoint, got %d, want 0", n)
+	}
+	if rt.Size() != 4 {
+		t.Errorf("AggregateSiblings, Size, got %d, want 4", rt.Size())
+	}
+
+	// inverse of Deaggregate
+	rt.Deaggregate(mpp("10.0.0.0/23"))
+	rt.Deaggregate(mpp("10.0.0.0/24"))
+	if n := rt.AggregateSiblings(eq); n != 1 {
+		t.Errorf("AggregateSiblings after Deaggregate, got %d, want 1", n)
+	}
+	if n := rt.AggregateSiblings(eq); n != 1 {
+		t.Errorf("AggregateSiblings after Deaggregate, got %d, want 1", n)
+	}
+	if val, _ := rt.Get(mpp("10.0.0.0/23")); val != 1 || rt.Size() != 4 {
+		t.Errorf("AggregateSiblings after Deaggregate, 10.0.0.0/23 got %d, Size %d", val, rt.Size())
+	}
+
+	// lookups unchanged, small value range for many merges
+	probes := randomPrefixes(10_000)
 
-		if val != want {
-			t.Errorf("GetAndDelete, expected %v, got %v", want, val)
-		}
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(5_000) {
+		rt.Insert(item.pfx, item.val%2+1)
+		rt.Deaggregate(item.pfx)
+	}
 
-		val, ok = tbl.GetAndDelete(p.pfx)
-		if ok {
-			t.Errorf("GetAndDelete, expected false, got (%v, %v)", val, ok)
+	want := make([]int, len(probes))
+	for i, item := range probes {
+		want[i], _ = rt.Lookup(item.pfx.Addr())
+	}
+
+	for rt.AggregateSiblings(eq) > 0 {
+		for i, item := range probes {
+			if got, _ := rt.Lookup(item.pfx.Addr()); got != want[i] {
+				t.Fatalf("Lookup(%s) after AggregateSiblings, got %d, want %d", item.pfx.Addr(), got, want[i])
+			}
 		}
 	}
 }
 
-func TestGet(t *testing.T) {
+func TestDefaultRoute(t *testing.T) {
 	t.Parallel()
 
-	t.Run("empty table", func(t *testing.T) {
-		t.Parallel()
-
-		rt := new(Table[int])
-		pfx := randomPrefix()
-		_, ok := rt.Get(pfx)
+	rt := new(Table[int])
 
-		if ok {
-			t.Errorf("empty table: Get(%v), ok=%v, expected: %v", pfx, ok, false)
-		}
-	})
+	if _, ok := rt.DefaultRoute4(); ok {
+		t.Errorf("empty table, DefaultRoute4, expected false")
+	}
+	if _, ok := rt.DefaultRoute6(); ok {
+		t.Errorf("empty table, DefaultRoute6, expected false")
+	}
 
-	tests := []struct {
-		name string
-		pfx  netip.Prefix
-		val  int
-	}{
-		{
-			name: "default route v4",
-			pfx:  mpp("0.0.0.0/0"),
-			val:  0,
-		},
-		{
-			name: "default route v6",
-			pfx:  mpp("::/0"),
-			val:  0,
-		},
-		{
-			name: "set v4",
-			pfx:  mpp("1.2.3.4/32"),
-			val:  1234,
-		},
-		{
-			name: "set v6",
-			pfx:  mpp("2001:db8::/32"),
-			val:  2001,
-		},
+	pfxs := randomPrefixes(1_000)
+	for _, item := range pfxs {
+		rt.Insert(item.pfx, item.val)
 	}
 
-	rt := new(Table[int])
-	for _, tt := range tests {
-		rt.Insert(tt.pfx, tt.val)
+	rt.Insert(mpp("0.0.0.0/0"), -4)
+	rt.Insert(mpp("::/0"), -6)
+
+	if val, ok := rt.DefaultRoute4(); !ok || val != -4 {
+		t.Errorf("DefaultRoute4, expected (-4, true), got (%v, %v)", val, ok)
+	}
+	if val, ok := rt.DefaultRoute6(); !ok || val != -6 {
+		t.Errorf("DefaultRoute6, expected (-6, true), got (%v, %v)", val, ok)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			got, ok := rt.Get(tt.pfx)
+	t.Run("Subnets of default route", func(t *testing.T) {
+		t.Parallel()
 
-			if !ok {
-				t.Errorf("%s: ok=%v, expected: %v", tt.name, ok, true)
+		var count4, count6 int
+		rt.Subnets(mpp("0.0.0.0/0"))(func(pfx netip.Prefix, _ int) bool {
+			if !pfx.Addr().Is4() {
+				t.Errorf("Subnets(0.0.0.0/0), unexpected IPv6 prefix %s", pfx)
 			}
-
-			if got != tt.val {
-				t.Errorf("%s: val=%v, expected: %v", tt.name, got, tt.val)
+			count4++
+			return true
+		})
+		rt.Subnets(mpp("::/0"))(func(pfx netip.Prefix, _ int) bool {
+			if pfx.Addr().Is4() {
+				t.Errorf("Subnets(::/0), unexpected IPv4 prefix %s", pfx)
 			}
+			count6++
+			return true
 		})
+
+		if count4 != rt.Size4() {
+			t.Errorf("Subnets(0.0.0.0/0), expected %d entries, got %d", rt.Size4(), count4)
+		}
+		if count6 != rt.Size6() {
+			t.Errorf("Subnets(::/0), expected %d entries, got %d", rt.Size6(), count6)
+		}
+	})
+
+	t.Run("Supernets include default route", func(t *testing.T) {
+		t.Parallel()
+
+		for _, item := range pfxs {
+			var last netip.Prefix
+			rt.Supernets(item.pfx)(func(pfx netip.Prefix, _ int) bool {
+				last = pfx
+				return true
+			})
+
+			if last.Bits() != 0 {
+				t.Fatalf("Supernets(%s), expected default route as last item, got %s", item.pfx, last)
+			}
+		}
+	})
+
+	t.Run("Lookup falls back to default route", func(t *testing.T) {
+		t.Parallel()
+
+		if val, ok := rt.Lookup(mpa("255.255.255.255")); !ok || val == 0 {
+			t.Errorf("Lookup, expected match, got (%v, %v)", val, ok)
+		}
+		if _, ok := rt.LookupPrefix(mpp("::/0")); !ok {
+			t.Errorf("LookupPrefix(::/0), expected true")
+		}
+		if lpm, _, ok := rt.LookupPrefixLPM(mpp("0.0.0.0/0")); !ok || lpm != mpp("0.0.0.0/0") {
+			t.Errorf("LookupPrefixLPM(0.0.0.0/0), expected (0.0.0.0/0, true), got (%s, %v)", lpm, ok)
+		}
+	})
+
+	rt2 := rt.Clone()
+	rt2.Delete(mpp("0.0.0.0/0"))
+	if _, ok := rt2.DefaultRoute4(); ok {
+		t.Errorf("DefaultRoute4 after delete, expected false")
 	}
 }
 
-func TestGetCompare(t *testing.T) {
+func TestDefaultRouteOnly(t *testing.T) {
 	t.Parallel()
 
-	pfxs := randomPrefixes(10_000)
-	fast := new(Table[int])
-	gold := new(goldTable[int]).insertMany(pfxs)
+	rt := new(Table[int])
+	rt.Insert(mpp("0.0.0.0/0"), 4)
 
-	for _, pfx := range pfxs {
-		fast.Insert(pfx.pfx, pfx.val)
+	if val, ok := rt.Lookup(randomIP4()); !ok || val != 4 {
+		t.Errorf("default route only, Lookup, expected (4, true), got (%v, %v)", val, ok)
+	}
+	if ok := rt.Contains(randomIP4()); !ok {
+		t.Errorf("default route only, Contains, expected true")
+	}
+	if _, ok := rt.Lookup(randomIP6()); ok {
+		t.Errorf("default route only, Lookup IPv6, expected false")
 	}
 
-	for _, pfx := range pfxs {
-		goldVal, goldOK := gold.get(pfx.pfx)
-		fastVal, fastOK := fast.Get(pfx.pfx)
+	// no longer default only
+	rt.Insert(mpp("10.0.0.0/8"), 8)
+	if val, _ := rt.Lookup(mpa("10.0.0.1")); val != 8 {
+		t.Errorf("Lookup(10.0.0.1), expected 8, got %v", val)
+	}
 
-		if !getsEqual(goldVal, goldOK, fastVal, fastOK) {
-			t.Fatalf("Get(%q) = (%v, %v), want (%v, %v)", pfx.pfx, fastVal, fastOK, goldVal, goldOK)
-		}
+	// and again default only
+	rt.Delete(mpp("10.0.0.0/8"))
+	if val, _ := rt.Lookup(mpa("10.0.0.1")); val != 4 {
+		t.Errorf("Lookup(10.0.0.1) after delete, expected 4, got %v", val)
+	}
+
+	rt.Delete(mpp("0.0.0.0/0"))
+	if ok := rt.Contains(mpa("10.0.0.1")); ok {
+		t.Errorf("empty table, Contains, expected false")
 	}
 }
 
@@ -1372,90 +2525,411 @@ func TestUnionEdgeCases(t *testing.T) {
 		if got != want {
 			t.Fatalf("got:\n%v\nwant:\n%v", got, want)
 		}
-	})
+	})
+
+	t.Run("different IP versions", func(t *testing.T) {
+		t.Parallel()
+		aTbl := new(Table[int])
+		bTbl := new(Table[int])
+
+		// one empty table
+		aTbl.Insert(mpp("0.0.0.0/0"), 1)
+		bTbl.Insert(mpp("::/0"), 2)
+
+		aTbl.Union(bTbl)
+		want := `▼
+└─ 0.0.0.0/0 (1)
+▼
+└─ ::/0 (2)
+`
+		got := aTbl.String()
+		if got != want {
+			t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+		}
+	})
+
+	t.Run("same children", func(t *testing.T) {
+		t.Parallel()
+		aTbl := new(Table[int])
+		bTbl := new(Table[int])
+
+		aTbl.Insert(mpp("127.0.0.1/32"), 1)
+		aTbl.Insert(mpp("::1/128"), 1)
+
+		bTbl.Insert(mpp("127.0.0.2/32"), 2)
+		bTbl.Insert(mpp("::2/128"), 2)
+
+		aTbl.Union(bTbl)
+		want := `▼
+├─ 127.0.0.1/32 (1)
+└─ 127.0.0.2/32 (2)
+▼
+├─ ::1/128 (1)
+└─ ::2/128 (2)
+`
+		got := aTbl.String()
+		if got != want {
+			t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+		}
+	})
+}
+
+// TestUnionMemoryAliasing tests that the Union method does not alias memory
+// between the two tables.
+func TestUnionMemoryAliasing(t *testing.T) {
+	t.Parallel()
+
+	newTable := func(pfx ...string) *Table[struct{}] {
+		t := new(Table[struct{}])
+		for _, s := range pfx {
+			t.Insert(mpp(s), struct{}{})
+		}
+		return t
+	}
+	// First create two tables with disjoint prefixes.
+	stable := newTable("0.0.0.0/24")
+	temp := newTable("100.69.1.0/24")
+
+	// Verify that the tables are disjoint.
+	if stable.Overlaps(temp) {
+		t.Error("stable should not overlap temp")
+	}
+
+	// Now union them.
+	temp.Union(stable)
+
+	// Add a new prefix to temp.
+	temp.Insert(mpp("0.0.1.0/24"), struct{}{})
+
+	// Ensure that stable is unchanged.
+	_, ok := stable.Lookup(mpa("0.0.1.1"))
+	if ok {
+		t.Error("stable should not contain 0.0.1.1")
+	}
+	if stable.OverlapsPrefix(mpp("0.0.1.1/32")) {
+		t.Error("stable should not overlap 0.0.1.1/32")
+	}
+}
+
+func TestEmptyFastPathAllocs(t *testing.T) {
+	// AllocsPerRun must not be called in parallel tests
+	full := new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		full.Insert(item.pfx, item.val)
+	}
+
+	empty := new(Table[int])
+	probe := mpp("10.0.0.0/8")
+
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"empty.Overlaps(full)", func() { boolSink = empty.Overlaps(full) }},
+		{"full.Overlaps(empty)", func() { boolSink = full.Overlaps(empty) }},
+		{"empty.OverlapsPrefix", func() { boolSink = empty.OverlapsPrefix(probe) }},
+		{"full.Union(empty)", func() { full.Union(empty) }},
+		{"empty.Union(empty)", func() { empty.Union(empty) }},
+	}
+
+	for _, tt := range tests {
+		if allocs := testing.AllocsPerRun(100, tt.fn); allocs != 0 {
+			t.Errorf("%s, got %v allocs/op, want 0", tt.name, allocs)
+		}
+	}
+
+	if empty.Size() != 0 || full.Size() == 0 {
+		t.Errorf("fast path changed the tables")
+	}
+}
+
+func TestUnionStrict(t *testing.T) {
+	t.Parallel()
+
+	eq := func(a, b int) bool { return a == b }
+
+	tbl1 := new(Table[int])
+	tbl1.Insert(mpp("10.0.0.0/8"), 1)
+	tbl1.Insert(mpp("10.1.0.0/16"), 2)
+	tbl1.Insert(mpp("2001:db8::/32"), 3)
+
+	tbl2 := new(Table[int])
+	tbl2.Insert(mpp("10.0.0.0/8"), 1)
+	tbl2.Insert(mpp("192.168.0.0/16"), 4)
+
+	got, err := tbl1.UnionStrict(tbl2, eq)
+	if err != nil {
+		t.Fatalf("UnionStrict, unexpected error: %v", err)
+	}
+
+	if got.Size() != 4 {
+		t.Errorf("UnionStrict, expected size 4, got %d", got.Size())
+	}
+
+	if tbl1.Size() != 3 || tbl2.Size() != 2 {
+		t.Errorf("UnionStrict changed the input tables")
+	}
+
+	// conflicting values
+	tbl2.Insert(mpp("10.1.0.0/16"), 42)
+	tbl2.Insert(mpp("2001:db8::/32"), 42)
+
+	got, err = tbl1.UnionStrict(tbl2, eq)
+	if !errors.Is(err, ErrUnionConflict) {
+		t.Fatalf("UnionStrict with conflicts, expected ErrUnionConflict, got %v", err)
+	}
+
+	if got != nil {
+		t.Errorf("UnionStrict with conflicts, expected nil table, got %v", got)
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "10.1.0.0/16") || !strings.Contains(msg, "2 conflicts") {
+		t.Errorf("UnionStrict with conflicts, unexpected error message: %s", msg)
+	}
+
+	if tbl1.Size() != 3 {
+		t.Errorf("UnionStrict with conflicts changed the receiver")
+	}
+}
+
+// versioned route for UnionVersioned
+type versionedRoute struct {
+	nextHop string
+	version uint64
+}
+
+func (r versionedRoute) Version() uint64 { return r.version }
+
+func TestUnionVersioned(t *testing.T) {
+	t.Parallel()
+
+	a := new(Table[versionedRoute])
+	a.Insert(mpp("10.0.0.0/8"), versionedRoute{"a", 5})
+	a.Insert(mpp("10.1.0.0/16"), versionedRoute{"a", 5})
+	a.Insert(mpp("10.2.0.0/16"), versionedRoute{"a", 5})
+	a.Insert(mpp("2001:db8::/32"), versionedRoute{"a", 1})
+
+	b := new(Table[versionedRoute])
+	b.Insert(mpp("10.0.0.0/8"), versionedRoute{"b", 4})
+	b.Insert(mpp("10.1.0.0/16"), versionedRoute{"b", 5})
+	b.Insert(mpp("10.2.0.0/16"), versionedRoute{"b", 6})
+	b.Insert(mpp("192.168.0.0/16"), versionedRoute{"b", 0})
+
+	aDump := a.dumpString()
+	bDump := b.dumpString()
+
+	u := a.UnionVersioned(b)
+
+	want := map[netip.Prefix]versionedRoute{
+		mpp("10.0.0.0/8"):     {"a", 5}, // older in b
+		mpp("10.1.0.0/16"):    {"b", 5}, // tie, b wins
+		mpp("10.2.0.0/16"):    {"b", 6}, // newer in b
+		mpp("192.168.0.0/16"): {"b", 0},
+		mpp("2001:db8::/32"):  {"a", 1},
+	}
+
+	got := map[netip.Prefix]versionedRoute{}
+	u.All()(func(pfx netip.Prefix, val versionedRoute) bool {
+		got[pfx] = val
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnionVersioned\ngot:  %v\nwant: %v", got, want)
+	}
+
+	if a.dumpString() != aDump || b.dumpString() != bDump {
+		t.Errorf("UnionVersioned, receiver or other changed")
+	}
+
+	// nil tables
+	var nilTbl *Table[versionedRoute]
+	if u := nilTbl.UnionVersioned(b); u.Size() != b.Size() {
+		t.Errorf("UnionVersioned, nil receiver, got size %d, want %d", u.Size(), b.Size())
+	}
+	if u := a.UnionVersioned(nil); u.Size() != a.Size() {
+		t.Errorf("UnionVersioned, nil other, got size %d, want %d", u.Size(), a.Size())
+	}
+
+	// not Versioned, equal to Union
+	x := new(Table[int])
+	y := new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		x.Insert(item.pfx, item.val)
+	}
+	for _, item := range randomPrefixes(1_000) {
+		y.Insert(item.pfx, item.val)
+	}
+
+	u2 := x.UnionVersioned(y)
+	x.Union(y)
+
+	if !reflect.DeepEqual(u2.ToSlice(), x.ToSlice()) {
+		t.Errorf("UnionVersioned, values not Versioned, differs from Union")
+	}
+}
+
+func TestUnionAll(t *testing.T) {
+	t.Parallel()
+
+	if got := UnionAll[int](); got.Size() != 0 {
+		t.Errorf("UnionAll without tables, expected empty table, got size %d", got.Size())
+	}
+
+	var tables []*Table[int]
+	for range 5 {
+		rt := new(Table[int])
+		for _, item := range randomPrefixes(1_000) {
+			rt.Insert(item.pfx, item.val)
+		}
 
-	t.Run("different IP versions", func(t *testing.T) {
-		t.Parallel()
-		aTbl := new(Table[int])
-		bTbl := new(Table[int])
+		// some duplicates in all tables
+		rt.Insert(mpp("10.0.0.0/8"), len(tables))
+		rt.Insert(mpp("2001:db8::/32"), len(tables))
 
-		// one empty table
-		aTbl.Insert(mpp("0.0.0.0/0"), 1)
-		bTbl.Insert(mpp("::/0"), 2)
+		tables = append(tables, rt)
+	}
+	tables = append(tables, nil)
 
-		aTbl.Union(bTbl)
-		want := `▼
-└─ 0.0.0.0/0 (1)
-▼
-└─ ::/0 (2)
-`
-		got := aTbl.String()
-		if got != want {
-			t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+	// fold Union left to right
+	want := new(Table[int])
+	for _, rt := range tables[:5] {
+		want.Union(rt)
+	}
+
+	got := UnionAll(tables...)
+
+	if got.Size() != want.Size() {
+		t.Fatalf("UnionAll, expected size %d, got %d", want.Size(), got.Size())
+	}
+
+	want.All()(func(pfx netip.Prefix, val int) bool {
+		if v, ok := got.Get(pfx); !ok || v != val {
+			t.Fatalf("UnionAll, Get(%s), expected (%d, true), got (%d, %v)", pfx, val, v, ok)
 		}
+		return true
 	})
 
-	t.Run("same children", func(t *testing.T) {
-		t.Parallel()
-		aTbl := new(Table[int])
-		bTbl := new(Table[int])
+	if v, _ := got.Get(mpp("10.0.0.0/8")); v != 4 {
+		t.Errorf("UnionAll, last writer must win, expected 4, got %d", v)
+	}
 
-		aTbl.Insert(mpp("127.0.0.1/32"), 1)
-		aTbl.Insert(mpp("::1/128"), 1)
+	// same trie structure as the fold, path compressed
+	if got.dumpString() != want.dumpString() {
+		t.Errorf("UnionAll, trie differs from folding Union\ngot:\n%s\nwant:\n%s", got.dumpString(), want.dumpString())
+	}
 
-		bTbl.Insert(mpp("127.0.0.2/32"), 2)
-		bTbl.Insert(mpp("::2/128"), 2)
+	// combine func, count the duplicates
+	wantDups := -want.Size()
+	for _, rt := range tables[:5] {
+		wantDups += rt.Size()
+	}
 
-		aTbl.Union(bTbl)
-		want := `▼
-├─ 127.0.0.1/32 (1)
-└─ 127.0.0.2/32 (2)
-▼
-├─ ::1/128 (1)
-└─ ::2/128 (2)
-`
-		got := aTbl.String()
-		if got != want {
-			t.Fatalf("got:\n%v\nwant:\n%v", got, want)
-		}
-	})
-}
+	sum, dups := UnionAllFunc(func(a, b int) int { return a + b }, tables...)
+	if dups != wantDups {
+		t.Errorf("UnionAllFunc, expected %d duplicates, got %d", wantDups, dups)
+	}
+	if v, _ := sum.Get(mpp("2001:db8::/32")); v != 0+1+2+3+4 {
+		t.Errorf("UnionAllFunc, expected combined value 10, got %d", v)
+	}
 
-// TestUnionMemoryAliasing tests that the Union method does not alias memory
-// between the two tables.
-func TestUnionMemoryAliasing(t *testing.T) {
-	t.Parallel()
+	// nil combine, duplicates are counted the same way
+	if _, dups2 := UnionAllFunc(nil, tables...); dups2 != dups {
+		t.Errorf("UnionAllFunc with nil combine, expected %d duplicates, got %d", dups, dups2)
+	}
 
-	newTable := func(pfx ...string) *Table[struct{}] {
-		t := new(Table[struct{}])
-		for _, s := range pfx {
-			t.Insert(mpp(s), struct{}{})
-		}
-		return t
+	// input tables unchanged
+	if v, _ := tables[0].Get(mpp("10.0.0.0/8")); v != 0 {
+		t.Errorf("UnionAll changed the input tables")
 	}
-	// First create two tables with disjoint prefixes.
-	stable := newTable("0.0.0.0/24")
-	temp := newTable("100.69.1.0/24")
 
-	// Verify that the tables are disjoint.
-	if stable.Overlaps(temp) {
-		t.Error("stable should not overlap temp")
+	// the multi-way merge yields a consistent trie
+	if err := got.AssertConsistent(); err != nil {
+		t.Errorf("UnionAll, %v", err)
 	}
 
-	// Now union them.
-	temp.Union(stable)
+	// combine is applied in argument order, compare with folding Update
+	sub := func(a, b int) int { return a - b }
 
-	// Add a new prefix to temp.
-	temp.Insert(mpp("0.0.1.0/24"), struct{}{})
+	wantSub := new(Table[int])
+	for _, rt := range tables[:5] {
+		rt.All()(func(pfx netip.Prefix, val int) bool {
+			wantSub.Update(pfx, func(old int, ok bool) int {
+				if !ok {
+					return val
+				}
+				return sub(old, val)
+			})
+			return true
+		})
+	}
 
-	// Ensure that stable is unchanged.
-	_, ok := stable.Lookup(mpa("0.0.1.1"))
-	if ok {
-		t.Error("stable should not contain 0.0.1.1")
+	gotSub, _ := UnionAllFunc(sub, tables...)
+	if !reflect.DeepEqual(gotSub.ToSlice(), wantSub.ToSlice()) {
+		t.Errorf("UnionAllFunc, combine in argument order, result differs from folding Update")
 	}
-	if stable.OverlapsPrefix(mpp("0.0.1.1/32")) {
-		t.Error("stable should not overlap 0.0.1.1/32")
+	if err := gotSub.AssertConsistent(); err != nil {
+		t.Errorf("UnionAllFunc, %v", err)
+	}
+
+	// duplicate as path compressed leaf in one table and node prefix in the other
+	leafTbl, nodeTbl := new(Table[int]), new(Table[int])
+	leafTbl.Insert(mpp("10.1.2.0/24"), 10)
+	nodeTbl.Insert(mpp("10.1.2.0/24"), 3)
+	nodeTbl.Insert(mpp("10.1.3.0/24"), 4)
+
+	for _, tt := range []struct {
+		tables []*Table[int]
+		want   int
+	}{
+		{[]*Table[int]{leafTbl, nodeTbl}, 10 - 3},
+		{[]*Table[int]{nodeTbl, leafTbl}, 3 - 10},
+	} {
+		got, dups := UnionAllFunc(sub, tt.tables...)
+		if v, _ := got.Get(mpp("10.1.2.0/24")); v != tt.want || dups != 1 || got.Size() != 2 {
+			t.Errorf("UnionAllFunc, leaf and node, got (%d, %d dups, size %d), want (%d, 1 dups, size 2)", v, dups, got.Size(), tt.want)
+		}
+	}
+
+	// duplicate leaves in both tables are merged back into a leaf
+	a, b := new(Table[int]), new(Table[int])
+	a.Insert(mpp("10.1.2.0/24"), 1)
+	b.Insert(mpp("10.1.2.0/24"), 2)
+
+	fold := a.Clone()
+	fold.Union(b)
+
+	if got := UnionAll(a, b); got.dumpString() != fold.dumpString() {
+		t.Errorf("UnionAll, duplicate leaves not path compressed\ngot:\n%s\nwant:\n%s", got.dumpString(), fold.dumpString())
+	}
+}
+
+func BenchmarkUnionAll(b *testing.B) {
+	var tables []*Table[int]
+	for range 10 {
+		rt := new(Table[int])
+		for _, item := range randomPrefixes(10_000) {
+			rt.Insert(item.pfx, item.val)
+		}
+		tables = append(tables, rt)
 	}
+
+	b.Run("UnionAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			UnionAll(tables...)
+		}
+	})
+
+	b.Run("FoldUnion", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			rt := new(Table[int])
+			for _, o := range tables {
+				rt.Union(o)
+			}
+		}
+	})
 }
 
 func TestUnionCompare(t *testing.T) {
@@ -1554,6 +3028,167 @@ func TestClone(t *testing.T) {
 	}
 }
 
+func TestShard(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	for _, n := range []int{-1, 0, 1, 2, 7, 16} {
+		shards := rt.Shard(n)
+
+		if len(shards) != max(n, 1) {
+			t.Fatalf("Shard(%d), expected %d shards, got %d", n, max(n, 1), len(shards))
+		}
+
+		size := 0
+		for i, shard := range shards {
+			size += shard.Size()
+
+			// stable assignment
+			shard.All()(func(pfx netip.Prefix, _ int) bool {
+				if j := shardHash(pfx) % uint64(len(shards)); j != uint64(i) {
+					t.Fatalf("Shard(%d), %s in shard %d, expected %d", n, pfx, i, j)
+				}
+				return true
+			})
+		}
+
+		// each entry in exactly one shard
+		if size != rt.Size() {
+			t.Errorf("Shard(%d), expected sum of sizes %d, got %d", n, rt.Size(), size)
+		}
+
+		if got := UnionAll(shards...); got.String() != rt.String() {
+			t.Errorf("Shard(%d), union of shards differs from table", n)
+		}
+	}
+
+	if got := new(Table[int]).Shard(4); len(got) != 4 || got[0].Size() != 0 {
+		t.Errorf("Shard of empty table, expected 4 empty shards")
+	}
+}
+
+func TestClusters(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+
+	count := 0
+	rt.Clusters()(func(*Table[int]) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Errorf("Clusters, empty table, got %d clusters, want 0", count)
+	}
+
+	for i, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.1.0/24",
+		"10.2.0.0/16",
+		"11.0.0.0/16",
+		"11.1.0.0/16",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+	} {
+		rt.Insert(mpp(s), i)
+	}
+
+	var got [][]netip.Prefix
+	rt.Clusters()(func(c *Table[int]) bool {
+		var pfxs []netip.Prefix
+		c.AllSorted()(func(pfx netip.Prefix, _ int) bool {
+			pfxs = append(pfxs, pfx)
+			return true
+		})
+		got = append(got, pfxs)
+		return true
+	})
+
+	want := [][]netip.Prefix{
+		{mpp("10.0.0.0/8"), mpp("10.1.0.0/16"), mpp("10.1.1.0/24"), mpp("10.2.0.0/16")},
+		{mpp("11.0.0.0/16")},
+		{mpp("11.1.0.0/16")},
+		{mpp("2001:db8::/32"), mpp("2001:db8:1::/48")},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Clusters\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// disjoint clusters, the union is the original
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	var clusters []*Table[int]
+	rt.Clusters()(func(c *Table[int]) bool {
+		// clusters in sort order, only the previous one may be a neighbor
+		if n := len(clusters); n > 0 && c.Overlaps(clusters[n-1]) {
+			t.Fatalf("Clusters, clusters overlap")
+		}
+		clusters = append(clusters, c)
+		return true
+	})
+
+	if got := UnionAll(clusters...); !reflect.DeepEqual(got.ToSlice(), rt.ToSlice()) {
+		t.Errorf("Clusters, UnionAll of clusters differs from table")
+	}
+
+	// stop early
+	count = 0
+	rt.Clusters()(func(*Table[int]) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("Clusters, stop early, expected 1 call, got %d", count)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if _, ok := rt.Meta("source"); ok {
+		t.Errorf("empty table, Meta, expected false")
+	}
+
+	rt.SetMeta("source", "rib-in")
+	rt.SetMeta("asn", 65000)
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+
+	if val, ok := rt.Meta("source"); !ok || val != "rib-in" {
+		t.Errorf("Meta(source), expected (rib-in, true), got (%v, %v)", val, ok)
+	}
+
+	clone := rt.Clone()
+	clone.SetMeta("source", "rib-out")
+
+	if val, _ := rt.Meta("source"); val != "rib-in" {
+		t.Errorf("Meta(source) after changing the clone, expected rib-in, got %v", val)
+	}
+	if val, ok := clone.Meta("asn"); !ok || val != 65000 {
+		t.Errorf("clone, Meta(asn), expected (65000, true), got (%v, %v)", val, ok)
+	}
+
+	// not part of the serialization
+	buf, _ := rt.MarshalJSON()
+	if strings.Contains(string(buf), "rib-in") {
+		t.Errorf("MarshalJSON contains metadata: %s", buf)
+	}
+
+	rt.SetMeta("asn", nil)
+	if _, ok := rt.Meta("asn"); ok {
+		t.Errorf("Meta(asn) after removal, expected false")
+	}
+}
+
 func TestCloneShallow(t *testing.T) {
 	t.Parallel()
 
@@ -1563,56 +3198,216 @@ func TestCloneShallow(t *testing.T) {
 		t.Errorf("empty Clone: got:\n%swant:\n%s", clone.String(), tbl.String())
 	}
 
-	val := 1
-	pfx := mpp("10.0.0.1/32")
-	tbl.Insert(pfx, &val)
+	val := 1
+	pfx := mpp("10.0.0.1/32")
+	tbl.Insert(pfx, &val)
+
+	clone = tbl.Clone()
+	want, _ := tbl.Get(pfx)
+	got, _ := clone.Get(pfx)
+
+	if !(*got == *want && got == want) {
+		t.Errorf("shallow copy, values and pointers must be equal:\nvalues(%d, %d)\n(ptr(%v, %v)", *got, *want, got, want)
+	}
+
+	// update value, shallow copy of values, clone must be equal
+	val = 2
+	want, _ = tbl.Get(pfx)
+	got, _ = clone.Get(pfx)
+
+	if *got != *want {
+		t.Errorf("memory aliasing after shallow copy, values must be equal:\nvalues(%d, %d)", *got, *want)
+	}
+}
+
+func TestCloneDeep(t *testing.T) {
+	t.Parallel()
+
+	tbl := new(Table[*MyInt])
+	clone := tbl.Clone()
+	if tbl.String() != clone.String() {
+		t.Errorf("empty Clone: got:\n%swant:\n%s", clone.String(), tbl.String())
+	}
+
+	val := MyInt(1)
+	pfx := mpp("10.0.0.1/32")
+	tbl.Insert(pfx, &val)
+
+	clone = tbl.Clone()
+	want, _ := tbl.Get(pfx)
+	got, _ := clone.Get(pfx)
+
+	if *got != *want || got == want {
+		t.Errorf("value with Cloner interface, pointers must be different:\nvalues(%d, %d)\n(ptr(%v, %v)", *got, *want, got, want)
+	}
+
+	// update value, deep copy of values, cloned value must now be different
+	val = 2
+	want, _ = tbl.Get(pfx)
+	got, _ = clone.Get(pfx)
+
+	if *got == *want {
+		t.Errorf("memory aliasing after deep copy, values must be different:\nvalues(%d, %d)", *got, *want)
+	}
+}
+
+func TestClonePartial(t *testing.T) {
+	t.Parallel()
+
+	// route with an immutable, shared community list and mutable counters
+	type route struct {
+		communities *[]string
+		counters    []int
+	}
+
+	// deep copy of the counters, the communities are shared
+	partial := func(r route) route {
+		return route{
+			communities: r.communities,
+			counters:    append([]int(nil), r.counters...),
+		}
+	}
+
+	var nilTbl *Table[route]
+	if nilTbl.ClonePartial(partial) != nil {
+		t.Errorf("ClonePartial of nil table, expected nil")
+	}
+
+	communities := []string{"65000:1", "65000:2"}
+
+	tbl := new(Table[route])
+	for _, s := range []string{"10.0.0.0/8", "10.0.0.1/32", "2001:db8::/32"} {
+		tbl.Insert(mpp(s), route{&communities, []int{0}})
+	}
+
+	clone := tbl.ClonePartial(partial)
+	if tbl.Size() != clone.Size() {
+		t.Fatalf("ClonePartial, Size, got %d, want %d", clone.Size(), tbl.Size())
+	}
+
+	tbl.All()(func(pfx netip.Prefix, want route) bool {
+		got, ok := clone.Get(pfx)
+		if !ok {
+			t.Fatalf("ClonePartial, %s not found", pfx)
+		}
+
+		if got.communities != want.communities {
+			t.Errorf("ClonePartial, %s, communities must be shared", pfx)
+		}
+
+		// update the original counters, the clone must not change
+		want.counters[0]++
+		if got.counters[0] != 0 {
+			t.Errorf("ClonePartial, %s, memory aliasing of counters", pfx)
+		}
+		return true
+	})
+
+	// nil clone func, equal to Clone
+	val := MyInt(1)
+	ptbl := new(Table[*MyInt])
+	ptbl.Insert(mpp("10.0.0.1/32"), &val)
+
+	want, _ := ptbl.Get(mpp("10.0.0.1/32"))
+	got, _ := ptbl.ClonePartial(nil).Get(mpp("10.0.0.1/32"))
+	if *got != *want || got == want {
+		t.Errorf("ClonePartial(nil), value with Cloner interface, pointers must be different")
+	}
+}
+
+func TestCloneWithContext(t *testing.T) {
+	t.Parallel()
 
-	clone = tbl.Clone()
-	want, _ := tbl.Get(pfx)
-	got, _ := clone.Get(pfx)
+	ctx := context.Background()
+	double := func(_ context.Context, v int) (int, error) { return 2 * v, nil }
 
-	if !(*got == *want && got == want) {
-		t.Errorf("shallow copy, values and pointers must be equal:\nvalues(%d, %d)\n(ptr(%v, %v)", *got, *want, got, want)
+	var nilTbl *Table[int]
+	if c, err := nilTbl.CloneWithContext(ctx, double); c != nil || err != nil {
+		t.Errorf("CloneWithContext of nil table, expected (nil, nil), got (%v, %v)", c, err)
 	}
 
-	// update value, shallow copy of values, clone must be equal
-	val = 2
-	want, _ = tbl.Get(pfx)
-	got, _ = clone.Get(pfx)
+	tbl := new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		tbl.Insert(item.pfx, item.val)
+	}
 
-	if *got != *want {
-		t.Errorf("memory aliasing after shallow copy, values must be equal:\nvalues(%d, %d)", *got, *want)
+	clone, err := tbl.CloneWithContext(ctx, double)
+	if err != nil {
+		t.Fatalf("CloneWithContext, unexpected error: %v", err)
+	}
+	if clone.Size() != tbl.Size() {
+		t.Fatalf("CloneWithContext, Size, got %d, want %d", clone.Size(), tbl.Size())
 	}
-}
 
-func TestCloneDeep(t *testing.T) {
-	t.Parallel()
+	tbl.All()(func(pfx netip.Prefix, want int) bool {
+		if got, _ := clone.Get(pfx); got != 2*want {
+			t.Fatalf("CloneWithContext, Get(%s), got %d, want %d", pfx, got, 2*want)
+		}
+		return true
+	})
 
-	tbl := new(Table[*MyInt])
-	clone := tbl.Clone()
-	if tbl.String() != clone.String() {
-		t.Errorf("empty Clone: got:\n%swant:\n%s", clone.String(), tbl.String())
+	// first error aborts
+	errClone := errors.New("clone failed")
+	calls := 0
+	clone, err = tbl.CloneWithContext(ctx, func(_ context.Context, v int) (int, error) {
+		calls++
+		if calls == 10 {
+			return 0, errClone
+		}
+		return v, nil
+	})
+	if clone != nil || !errors.Is(err, errClone) || calls != 10 {
+		t.Errorf("CloneWithContext, clone error, got (%v, %v) after %d calls, want (nil, %v) after 10 calls", clone, err, calls, errClone)
 	}
 
-	val := MyInt(1)
-	pfx := mpp("10.0.0.1/32")
-	tbl.Insert(pfx, &val)
+	// cancel in the middle of the clone
+	cctx, cancel := context.WithCancel(ctx)
+	calls = 0
+	clone, err = tbl.CloneWithContext(cctx, func(_ context.Context, v int) (int, error) {
+		calls++
+		if calls == 10 {
+			cancel()
+		}
+		return v, nil
+	})
+	if clone != nil || !errors.Is(err, context.Canceled) || calls != 10 {
+		t.Errorf("CloneWithContext, canceled, got (%v, %v) after %d calls, want (nil, %v) after 10 calls", clone, err, calls, context.Canceled)
+	}
 
-	clone = tbl.Clone()
-	want, _ := tbl.Get(pfx)
-	got, _ := clone.Get(pfx)
+	// already canceled
+	if clone, err = tbl.CloneWithContext(cctx, double); clone != nil || !errors.Is(err, context.Canceled) {
+		t.Errorf("CloneWithContext, already canceled, got (%v, %v), want (nil, %v)", clone, err, context.Canceled)
+	}
+}
 
-	if *got != *want || got == want {
-		t.Errorf("value with Cloner interface, pointers must be different:\nvalues(%d, %d)\n(ptr(%v, %v)", *got, *want, got, want)
+func TestCloneWithContextAbort(t *testing.T) {
+	// AllocsPerRun must not be called in parallel tests
+	tbl := new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		tbl.Insert(item.pfx, item.val)
 	}
 
-	// update value, deep copy of values, cloned value must now be different
-	val = 2
-	want, _ = tbl.Get(pfx)
-	got, _ = clone.Get(pfx)
+	ctx := context.Background()
+	errClone := errors.New("clone failed")
 
-	if *got == *want {
-		t.Errorf("memory aliasing after deep copy, values must be different:\nvalues(%d, %d)", *got, *want)
+	calls := 0
+	fail := func(context.Context, int) (int, error) {
+		calls++
+		return 0, errClone
+	}
+
+	// the trie is not copied after the first error, just the first node
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := tbl.CloneWithContext(ctx, fail); !errors.Is(err, errClone) {
+			t.Fatalf("CloneWithContext, expected %v, got %v", errClone, err)
+		}
+	})
+
+	if allocs >= 10 {
+		t.Errorf("CloneWithContext, aborted clone got %v allocs/op, trie has %d nodes", allocs, tbl.DebugState().Nodes4+tbl.DebugState().Nodes6)
+	}
+	if calls != 11 {
+		t.Errorf("CloneWithContext, expected 1 clone call per run, got %d calls in 11 runs", calls)
 	}
 }
 
@@ -1729,6 +3524,448 @@ func TestOverlapsPrefixEdgeCases(t *testing.T) {
 	})
 }
 
+func TestTransform(t *testing.T) {
+	t.Parallel()
+
+	if got := Transform(nil, func(pfx netip.Prefix, val int) (netip.Prefix, string, bool) {
+		return pfx, "", true
+	}); got != nil {
+		t.Errorf("Transform(nil), expected nil, got %v", got)
+	}
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 8)
+	rt.Insert(mpp("10.1.0.0/16"), 16)
+	rt.Insert(mpp("10.1.2.0/24"), 24)
+	rt.Insert(mpp("192.168.0.0/16"), 0)
+	rt.Insert(mpp("2001:db8::/32"), 32)
+
+	// shift the lab network 10.1.0.0/16 into production 172.16.0.0/16,
+	// drop the zero values and convert values to strings
+	lab := mpp("10.1.0.0/16")
+	got := Transform(rt, func(pfx netip.Prefix, val int) (netip.Prefix, string, bool) {
+		if val == 0 {
+			return pfx, "", false
+		}
+		if lab.Overlaps(pfx) && pfx.Bits() >= lab.Bits() {
+			a4 := pfx.Addr().As4()
+			a4[0], a4[1] = 172, 16
+			pfx = netip.PrefixFrom(netip.AddrFrom4(a4), pfx.Bits())
+		}
+		return pfx, fmt.Sprintf("/%d", val), true
+	})
+
+	want := map[netip.Prefix]string{
+		mpp("10.0.0.0/8"):    "/8",
+		mpp("172.16.0.0/16"): "/16",
+		mpp("172.16.2.0/24"): "/24",
+		mpp("2001:db8::/32"): "/32",
+	}
+
+	if got.Size() != len(want) {
+		t.Fatalf("Transform, expected size %d, got %d", len(want), got.Size())
+	}
+
+	for pfx, val := range want {
+		if v, ok := got.Get(pfx); !ok || v != val {
+			t.Errorf("Transform, Get(%s), expected (%q, true), got (%q, %v)", pfx, val, v, ok)
+		}
+	}
+
+	// collisions, last writer in CIDR sort order wins
+	coarse := Transform(rt, func(pfx netip.Prefix, val int) (netip.Prefix, int, bool) {
+		pfx, _ = pfx.Addr().Prefix(min(pfx.Bits(), 8))
+		return pfx, val, true
+	})
+
+	if v, _ := coarse.Get(mpp("10.0.0.0/8")); v != 24 {
+		t.Errorf("Transform with collisions, expected last writer 24, got %d", v)
+	}
+}
+
+func TestClampMaxLen(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("10.0.0.0/24"), 2)
+	rt.Insert(mpp("10.0.0.0/25"), 3)
+	rt.Insert(mpp("10.0.0.128/25"), 4)
+	rt.Insert(mpp("10.0.1.1/32"), 5)
+	rt.Insert(mpp("2001:db8::1/128"), 6)
+
+	sum := func(oldVal, newVal int) int { return oldVal + newVal }
+
+	clamped := rt.ClampMaxLen(24, sum)
+
+	want := map[netip.Prefix]int{
+		mpp("10.0.0.0/8"):    1,
+		mpp("10.0.0.0/24"):   2 + 3 + 4,
+		mpp("10.0.1.0/24"):   5,
+		mpp("2001:d00::/24"): 6,
+	}
+
+	got := map[netip.Prefix]int{}
+	clamped.All()(func(pfx netip.Prefix, val int) bool {
+		got[pfx] = val
+		return true
+	})
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ClampMaxLen(24, sum)\ngot:  %v\nwant: %v", got, want)
+	}
+
+	// nil combine, the last writer in CIDR sort order wins
+	if val, _ := rt.ClampMaxLen(24, nil).Get(mpp("10.0.0.0/24")); val != 4 {
+		t.Errorf("ClampMaxLen(24, nil), 10.0.0.0/24, got %d, want 4", val)
+	}
+
+	// the receiver is unchanged
+	if rt.Size() != 6 {
+		t.Errorf("ClampMaxLen, receiver changed, size %d", rt.Size())
+	}
+
+	// lookups are coarser by design, 10.0.1.2 is now covered by 10.0.1.0/24
+	if val, _ := rt.Lookup(mpa("10.0.1.2")); val != 1 {
+		t.Errorf("Lookup(10.0.1.2) before clamping, got %d, want 1", val)
+	}
+	if val, _ := clamped.Lookup(mpa("10.0.1.2")); val != 5 {
+		t.Errorf("Lookup(10.0.1.2) after clamping, got %d, want 5", val)
+	}
+
+	// negative maxBits is treated as 0
+	if got := rt.ClampMaxLen(-1, nil); got.Size() != 2 {
+		t.Errorf("ClampMaxLen(-1), expected the two default routes, got size %d", got.Size())
+	}
+
+	// no entry is longer than maxBits, every address covered before is still covered
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(10_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	clamped = rt.ClampMaxLen(20, nil)
+	clamped.All()(func(pfx netip.Prefix, _ int) bool {
+		if pfx.Bits() > 20 {
+			t.Fatalf("ClampMaxLen(20), got %s", pfx)
+		}
+		return true
+	})
+
+	for range 10_000 {
+		ip := randomAddr()
+		if rt.Contains(ip) && !clamped.Contains(ip) {
+			t.Fatalf("ClampMaxLen(20), %s is no longer covered", ip)
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	t.Parallel()
+
+	t.Run("mismatch", func(t *testing.T) {
+		t.Parallel()
+		rt := new(Table[int])
+		rt.Insert(mpp("10.1.0.0/16"), 16)
+
+		for _, tt := range []struct {
+			from, to netip.Prefix
+		}{
+			{mpp("10.1.0.0/16"), mpp("172.16.0.0/12")},
+			{mpp("10.1.0.0/16"), mpp("2001::/16")},
+			{mpp("2001:db8::/32"), mpp("10.0.0.0/32")},
+			{netip.Prefix{}, mpp("10.0.0.0/16")},
+			{mpp("10.1.0.0/16"), netip.Prefix{}},
+		} {
+			if _, err := rt.Shift(tt.from, tt.to); !errors.Is(err, ErrShiftMismatch) {
+				t.Errorf("Shift(%s, %s), expected ErrShiftMismatch, got %v", tt.from, tt.to, err)
+			}
+		}
+	})
+
+	t.Run("ipv4", func(t *testing.T) {
+		t.Parallel()
+		rt := new(Table[int])
+		for i, s := range []string{
+			"0.0.0.0/0",
+			"10.0.0.0/8",
+			"10.1.0.0/16",
+			"10.1.2.0/24",
+			"10.1.2.3/32",
+			"10.1.255.128/25",
+			"10.2.0.0/16",
+			"172.16.0.0/12",
+		} {
+			rt.Insert(mpp(s), i)
+		}
+
+		got, err := rt.Shift(mpp("10.1.0.0/16"), mpp("172.20.0.0/16"))
+		if err != nil {
+			t.Fatalf("Shift, unexpected error: %v", err)
+		}
+
+		want := map[netip.Prefix]int{
+			mpp("0.0.0.0/0"):         0,
+			mpp("10.0.0.0/8"):        1,
+			mpp("172.20.0.0/16"):     2,
+			mpp("172.20.2.0/24"):     3,
+			mpp("172.20.2.3/32"):     4,
+			mpp("172.20.255.128/25"): 5,
+			mpp("10.2.0.0/16"):       6,
+			mpp("172.16.0.0/12"):     7,
+		}
+
+		if got.Size() != len(want) {
+			t.Fatalf("Shift, expected size %d, got %d\n%s", len(want), got.Size(), got)
+		}
+		for pfx, val := range want {
+			if v, ok := got.Get(pfx); !ok || v != val {
+				t.Errorf("Shift, Get(%s), expected (%d, true), got (%d, %v)", pfx, val, v, ok)
+			}
+		}
+
+		// receiver unchanged
+		if v, ok := rt.Get(mpp("10.1.2.3/32")); !ok || v != 4 || rt.Size() != len(want) {
+			t.Errorf("Shift, receiver changed")
+		}
+
+		// non canonical prefixes and identity
+		got, err = rt.Shift(netip.MustParsePrefix("10.1.2.3/16"), netip.MustParsePrefix("10.1.9.9/16"))
+		if err != nil {
+			t.Fatalf("Shift identity, unexpected error: %v", err)
+		}
+		if got.String() != rt.String() {
+			t.Errorf("Shift identity, expected:\n%s\ngot:\n%s", rt, got)
+		}
+	})
+
+	t.Run("ipv4_collision", func(t *testing.T) {
+		t.Parallel()
+		rt := new(Table[int])
+		rt.Insert(mpp("10.1.2.0/24"), 1)
+		rt.Insert(mpp("10.5.0.0/16"), 2)
+		rt.Insert(mpp("10.5.2.0/24"), 3)
+
+		if _, err := rt.Shift(mpp("10.1.0.0/16"), mpp("10.5.0.0/16")); !errors.Is(err, ErrShiftCollision) {
+			t.Errorf("Shift, expected ErrShiftCollision, got %v", err)
+		}
+
+		// the covering 10.5.0.0/16 is no collision
+		rt.Delete(mpp("10.5.2.0/24"))
+		got, err := rt.Shift(mpp("10.1.0.0/16"), mpp("10.5.0.0/16"))
+		if err != nil {
+			t.Fatalf("Shift, unexpected error: %v", err)
+		}
+		if v, ok := got.Get(mpp("10.5.2.0/24")); !ok || v != 1 {
+			t.Errorf("Shift, Get(10.5.2.0/24), expected (1, true), got (%d, %v)", v, ok)
+		}
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		t.Parallel()
+		rt := new(Table[int])
+		for i, s := range []string{
+			"::/0",
+			"2001:db8::/32",
+			"2001:db8:1::/48",
+			"2001:db8:1:ff00::/56",
+			"2001:db8:1:2::1/128",
+			"2001:db9::/33",
+			"fe80::/10",
+		} {
+			rt.Insert(mpp(s), i)
+		}
+
+		// shift on a non octet boundary
+		got, err := rt.Shift(mpp("2001:db8::/36"), mpp("2001:db8:f000::/36"))
+		if err != nil {
+			t.Fatalf("Shift, unexpected error: %v", err)
+		}
+
+		want := map[netip.Prefix]int{
+			mpp("::/0"):                    0,
+			mpp("2001:db8::/32"):           1,
+			mpp("2001:db8:f001::/48"):      2,
+			mpp("2001:db8:f001:ff00::/56"): 3,
+			mpp("2001:db8:f001:2::1/128"):  4,
+			mpp("2001:db9::/33"):           5,
+			mpp("fe80::/10"):               6,
+		}
+
+		if got.Size() != len(want) {
+			t.Fatalf("Shift, expected size %d, got %d\n%s", len(want), got.Size(), got)
+		}
+		for pfx, val := range want {
+			if v, ok := got.Get(pfx); !ok || v != val {
+				t.Errorf("Shift, Get(%s), expected (%d, true), got (%d, %v)", pfx, val, v, ok)
+			}
+		}
+
+		rt.Insert(mpp("2001:db8:2:2::1/128"), 7)
+		if _, err := rt.Shift(mpp("2001:db8:1::/48"), mpp("2001:db8:2::/48")); !errors.Is(err, ErrShiftCollision) {
+			t.Errorf("Shift, expected ErrShiftCollision, got %v", err)
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		t.Parallel()
+		from, to := mpp("10.0.0.0/8"), mpp("99.0.0.0/8")
+
+		rt := new(Table[int])
+		for _, item := range randomPrefixes4(10_000) {
+			// avoid collisions
+			if to.Overlaps(item.pfx) && item.pfx.Bits() >= to.Bits() {
+				continue
+			}
+			rt.Insert(item.pfx, item.val)
+		}
+
+		got, err := rt.Shift(from, to)
+		if err != nil {
+			t.Fatalf("Shift, unexpected error: %v", err)
+		}
+
+		if got.Size() != rt.Size() {
+			t.Fatalf("Shift, expected size %d, got %d", rt.Size(), got.Size())
+		}
+
+		// shift back
+		back, err := got.Shift(to, from)
+		if err != nil {
+			t.Fatalf("Shift back, unexpected error: %v", err)
+		}
+
+		if back.String() != rt.String() {
+			t.Errorf("Shift and shift back, tables differ")
+		}
+	})
+}
+
+func TestOverlappingPrefixes(t *testing.T) {
+	t.Parallel()
+
+	rt := new(Table[int])
+	if got := rt.OverlappingPrefixes([]netip.Prefix{mpp("0.0.0.0/0"), mpp("::/0")}); got != nil {
+		t.Errorf("OverlappingPrefixes, empty table, got %v, want nil", got)
+	}
+
+	rt.Insert(mpp("10.0.0.0/8"), 1)
+	rt.Insert(mpp("192.168.1.0/24"), 2)
+
+	candidates := []netip.Prefix{
+		mpp("192.168.0.0/16"),
+		mpp("11.0.0.0/8"),
+		netip.MustParsePrefix("10.1.2.3/16"),
+		netip.Prefix{},
+		mpp("2001:db8::/32"),
+		mpp("0.0.0.0/0"),
+	}
+
+	want := []netip.Prefix{
+		mpp("192.168.0.0/16"),
+		netip.MustParsePrefix("10.1.2.3/16"),
+		mpp("0.0.0.0/0"),
+	}
+
+	if got := rt.OverlappingPrefixes(candidates); !reflect.DeepEqual(got, want) {
+		t.Errorf("OverlappingPrefixes, got %v, want %v", got, want)
+	}
+
+	// compare with OverlapsPrefix
+	rt = new(Table[int])
+	for _, item := range randomPrefixes(1_000) {
+		rt.Insert(item.pfx, item.val)
+	}
+
+	candidates = candidates[:0]
+	for _, item := range randomPrefixes(10_000) {
+		candidates = append(candidates, item.pfx)
+	}
+
+	got := rt.OverlappingPrefixes(candidates)
+
+	want = want[:0]
+	for _, pfx := range candidates {
+		if rt.OverlapsPrefix(pfx) {
+			want = append(want, pfx)
+		}
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("OverlappingPrefixes differs from OverlapsPrefix per element")
+	}
+}
+
+func TestOverlapEquivalent(t *testing.T) {
+	t.Parallel()
+
+	probes := []netip.Prefix{
+		mpp("10.0.0.0/8"),
+		netip.MustParsePrefix("10.1.2.3/16"),
+		mpp("2001:db8::/32"),
+		netip.Prefix{},
+	}
+
+	a := new(Table[int])
+	if !a.OverlapEquivalent(nil, probes) || !a.OverlapEquivalent(a, probes) {
+		t.Errorf("OverlapEquivalent, empty tables, expected true")
+	}
+
+	a.Insert(mpp("10.0.0.0/8"), 1)
+
+	b := new(Table[int])
+	b.Insert(mpp("10.1.0.0/16"), 2)
+
+	// both overlap the IPv4 probes, none the IPv6 probe
+	if !a.OverlapEquivalent(b, probes) {
+		t.Errorf("OverlapEquivalent, expected true")
+	}
+
+	// a overlaps, b not
+	if a.OverlapEquivalent(b, append(probes, mpp("10.2.0.0/16"))) {
+		t.Errorf("OverlapEquivalent(10.2.0.0/16), expected false")
+	}
+
+	if a.OverlapEquivalent(nil, probes) {
+		t.Errorf("OverlapEquivalent with nil, expected false")
+	}
+
+	// random tables and probes, compare with OverlapsPrefix
+	for range 10 {
+		a := new(Table[int])
+		b := new(Table[int])
+		for _, item := range randomPrefixes(100) {
+			a.Insert(item.pfx, item.val)
+			b.Insert(item.pfx, item.val)
+		}
+
+		probes = probes[:0]
+		for _, item := range randomPrefixes(1_000) {
+			probes = append(probes, item.pfx)
+		}
+
+		if !a.OverlapEquivalent(b, probes) {
+			t.Fatalf("OverlapEquivalent, same prefixes, expected true")
+		}
+
+		for _, item := range randomPrefixes(10) {
+			b.Insert(item.pfx, item.val)
+		}
+
+		want := true
+		for _, pfx := range probes {
+			if a.OverlapsPrefix(pfx) != b.OverlapsPrefix(pfx) {
+				want = false
+				break
+			}
+		}
+
+		if got := a.OverlapEquivalent(b, probes); got != want {
+			t.Fatalf("OverlapEquivalent, got %v, want %v", got, want)
+		}
+	}
+}
+
 func TestSize(t *testing.T) {
 	t.Parallel()
 
@@ -1944,6 +4181,32 @@ func BenchmarkTableLPM(b *testing.B) {
 	}
 }
 
+func BenchmarkTableDefaultRouteOnly(b *testing.B) {
+	for _, fam := range []string{"ipv4", "ipv6"} {
+		rt := new(Table[int])
+
+		dg := mpp("0.0.0.0/0")
+		probe := randomIP4()
+		if fam == "ipv6" {
+			dg = mpp("::/0")
+			probe = randomIP6()
+		}
+		rt.Insert(dg, 1)
+
+		b.Run(fmt.Sprintf("%s/Contains", fam), func(b *testing.B) {
+			for range b.N {
+				_ = rt.Contains(probe)
+			}
+		})
+
+		b.Run(fmt.Sprintf("%s/Lookup", fam), func(b *testing.B) {
+			for range b.N {
+				writeSink, _ = rt.Lookup(probe)
+			}
+		})
+	}
+}
+
 func BenchmarkTableOverlapsPrefix(b *testing.B) {
 	for _, fam := range []string{"ipv4", "ipv6"} {
 		rng := randomPrefixes4
@@ -2006,6 +4269,37 @@ func BenchmarkTableOverlaps(b *testing.B) {
 	}
 }
 
+func BenchmarkTableEmptyFastPath(b *testing.B) {
+	full := new(Table[int])
+	for _, route := range randomPrefixes(10_000) {
+		full.Insert(route.pfx, route.val)
+	}
+
+	empty := new(Table[int])
+	probe := randomPrefix()
+
+	b.Run("Overlaps", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			boolSink = empty.Overlaps(full)
+		}
+	})
+
+	b.Run("OverlapsPrefix", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			boolSink = empty.OverlapsPrefix(probe)
+		}
+	})
+
+	b.Run("Union", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			full.Union(empty)
+		}
+	})
+}
+
 func BenchmarkTableClone(b *testing.B) {
 	for _, fam := range []string{"ipv4", "ipv6"} {
 		rng := randomPrefixes4
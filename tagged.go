@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// TaggedTable is a routing table with payload V, where every prefix
+// may additionally be classified with string tags (labels),
+// e.g. "customer" or "transit".
+//
+// The tags are a secondary index, one prefix set per tag.
+// The prefix sets carry no payload, so the memory overhead is low.
+//
+// The zero value is ready to use. A TaggedTable must not be copied by value.
+type TaggedTable[V any] struct {
+	tbl  Table[V]
+	tags map[string]*Table[struct{}]
+}
+
+// InsertTagged adds pfx with val to the table and classifies it with tags.
+// If pfx is already present, its value is set to val and its previous tags
+// are replaced by tags.
+func (t *TaggedTable[V]) InsertTagged(pfx netip.Prefix, val V, tags ...string) {
+	if !pfx.IsValid() {
+		return
+	}
+
+	// canonicalize prefix
+	pfx = pfx.Masked()
+
+	t.tbl.Insert(pfx, val)
+	t.untag(pfx)
+
+	if len(tags) == 0 {
+		return
+	}
+
+	if t.tags == nil {
+		t.tags = make(map[string]*Table[struct{}])
+	}
+
+	for _, tag := range tags {
+		set := t.tags[tag]
+		if set == nil {
+			set = new(Table[struct{}])
+			t.tags[tag] = set
+		}
+		set.Insert(pfx, struct{}{})
+	}
+}
+
+// Delete removes pfx from the table and from all its tag sets.
+func (t *TaggedTable[V]) Delete(pfx netip.Prefix) {
+	if _, ok := t.tbl.GetAndDelete(pfx); !ok {
+		return
+	}
+
+	t.untag(pfx.Masked())
+}
+
+// Get returns the associated payload for prefix and true, or false if
+// prefix is not set in the table.
+func (t *TaggedTable[V]) Get(pfx netip.Prefix) (val V, ok bool) {
+	return t.tbl.Get(pfx)
+}
+
+// Lookup does a route lookup (longest prefix match) for IP and
+// returns the associated value and true, or false if no route matched.
+func (t *TaggedTable[V]) Lookup(ip netip.Addr) (val V, ok bool) {
+	return t.tbl.Lookup(ip)
+}
+
+// Size returns the prefix count.
+func (t *TaggedTable[V]) Size() int {
+	return t.tbl.Size()
+}
+
+// Tags returns the sorted tags of pfx.
+func (t *TaggedTable[V]) Tags(pfx netip.Prefix) []string {
+	if !pfx.IsValid() {
+		return nil
+	}
+
+	// canonicalize prefix
+	pfx = pfx.Masked()
+
+	var tags []string
+	for tag, set := range t.tags {
+		if _, ok := set.Get(pfx); ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	slices.Sort(tags)
+	return tags
+}
+
+// ByTag returns an iterator over all prefixes classified with tag.
+// The iteration is in natural CIDR sort order.
+func (t *TaggedTable[V]) ByTag(tag string) func(yield func(netip.Prefix) bool) {
+	return func(yield func(netip.Prefix) bool) {
+		set := t.tags[tag]
+		if set == nil {
+			return
+		}
+
+		set.AllSorted()(func(pfx netip.Prefix, _ struct{}) bool {
+			return yield(pfx)
+		})
+	}
+}
+
+// untag removes pfx from all tag sets, empty tag sets are dropped.
+func (t *TaggedTable[V]) untag(pfx netip.Prefix) {
+	for tag, set := range t.tags {
+		if _, ok := set.GetAndDelete(pfx); ok && set.Size() == 0 {
+			delete(t.tags, tag)
+		}
+	}
+}
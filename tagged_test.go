@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestTaggedTable(t *testing.T) {
+	t.Parallel()
+
+	byTag := func(tt *TaggedTable[int], tag string) (pfxs []netip.Prefix) {
+		tt.ByTag(tag)(func(pfx netip.Prefix) bool {
+			pfxs = append(pfxs, pfx)
+			return true
+		})
+		return pfxs
+	}
+
+	tt := new(TaggedTable[int])
+
+	if got := byTag(tt, "customer"); got != nil {
+		t.Errorf("empty table, ByTag, expected nil, got %v", got)
+	}
+
+	tt.InsertTagged(mpp("10.0.0.0/8"), 1, "customer")
+	tt.InsertTagged(mpp("10.1.0.0/16"), 2, "customer", "transit")
+	tt.InsertTagged(mpp("2001:db8::/32"), 3, "transit")
+	tt.InsertTagged(mpp("192.168.0.0/16"), 4)
+	tt.InsertTagged(netip.Prefix{}, 5, "invalid")
+
+	if tt.Size() != 4 {
+		t.Errorf("Size, expected 4, got %d", tt.Size())
+	}
+
+	if got, want := byTag(tt, "customer"), []netip.Prefix{mpp("10.0.0.0/8"), mpp("10.1.0.0/16")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByTag(customer), expected %v, got %v", want, got)
+	}
+
+	if got, want := tt.Tags(mpp("10.1.0.0/16")), []string{"customer", "transit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags(10.1.0.0/16), expected %v, got %v", want, got)
+	}
+
+	if val, ok := tt.Lookup(mpa("10.1.2.3")); !ok || val != 2 {
+		t.Errorf("Lookup(10.1.2.3), expected (2, true), got (%d, %v)", val, ok)
+	}
+
+	// retag, replaces the previous tags
+	tt.InsertTagged(mpp("10.0.0.0/8"), 11, "transit")
+
+	if got, want := byTag(tt, "customer"), []netip.Prefix{mpp("10.1.0.0/16")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByTag(customer) after retag, expected %v, got %v", want, got)
+	}
+
+	if val, _ := tt.Get(mpp("10.0.0.0/8")); val != 11 {
+		t.Errorf("Get(10.0.0.0/8) after retag, expected 11, got %d", val)
+	}
+
+	// delete removes the prefix from all tag sets
+	tt.Delete(mpp("10.1.0.0/16"))
+
+	if got := byTag(tt, "customer"); got != nil {
+		t.Errorf("ByTag(customer) after delete, expected nil, got %v", got)
+	}
+
+	if got, want := byTag(tt, "transit"), []netip.Prefix{mpp("10.0.0.0/8"), mpp("2001:db8::/32")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByTag(transit) after delete, expected %v, got %v", want, got)
+	}
+
+	if got := tt.Tags(mpp("192.168.0.0/16")); got != nil {
+		t.Errorf("Tags(192.168.0.0/16), expected nil, got %v", got)
+	}
+}
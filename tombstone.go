@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// TombstoneTable is a routing table with payload V and soft deletes,
+// e.g. for change-review workflows: stage withdrawals, inspect them,
+// then commit with [TombstoneTable.PurgeDeleted] or roll them back
+// with [TombstoneTable.RestoreDeleted].
+//
+// Soft deleted entries are retained as tombstones, but are hidden from
+// Get, Lookup and the iterators. The tombstones are kept in a separate
+// table, so the lookups don't pay for the skipping.
+//
+// The zero value is ready to use. A TombstoneTable must not be copied by value.
+type TombstoneTable[V any] struct {
+	live Table[V]
+	dead Table[V]
+}
+
+// Insert adds pfx to the table, with given val.
+// A tombstone for pfx is removed.
+func (t *TombstoneTable[V]) Insert(pfx netip.Prefix, val V) {
+	if !pfx.IsValid() {
+		return
+	}
+
+	t.dead.Delete(pfx)
+	t.live.Insert(pfx, val)
+}
+
+// Delete removes pfx from the table immediately, including its tombstone.
+func (t *TombstoneTable[V]) Delete(pfx netip.Prefix) {
+	t.dead.Delete(pfx)
+	t.live.Delete(pfx)
+}
+
+// SoftDelete marks pfx as deleted. The entry is hidden from lookups,
+// but retained until [TombstoneTable.PurgeDeleted].
+// Returns false if pfx is not set in the table.
+func (t *TombstoneTable[V]) SoftDelete(pfx netip.Prefix) bool {
+	val, ok := t.live.GetAndDelete(pfx)
+	if !ok {
+		return false
+	}
+
+	t.dead.Insert(pfx, val)
+	return true
+}
+
+// RestoreDeleted revives the soft deleted pfx with its previous value.
+// Returns false if pfx has no tombstone.
+func (t *TombstoneTable[V]) RestoreDeleted(pfx netip.Prefix) bool {
+	val, ok := t.dead.GetAndDelete(pfx)
+	if !ok {
+		return false
+	}
+
+	t.live.Insert(pfx, val)
+	return true
+}
+
+// PurgeDeleted finally removes all tombstones and returns their number.
+func (t *TombstoneTable[V]) PurgeDeleted() int {
+	n := t.dead.Size()
+	t.dead = Table[V]{}
+
+	return n
+}
+
+// Deleted returns an iterator over the soft deleted prefixes and their
+// values in natural CIDR sort order, e.g. for review before purging.
+func (t *TombstoneTable[V]) Deleted() func(yield func(netip.Prefix, V) bool) {
+	return t.dead.AllSorted()
+}
+
+// IsDeleted reports whether pfx is soft deleted.
+func (t *TombstoneTable[V]) IsDeleted(pfx netip.Prefix) bool {
+	_, ok := t.dead.Get(pfx)
+	return ok
+}
+
+// Get returns the associated payload for prefix and true, or false if
+// prefix is not set or soft deleted.
+func (t *TombstoneTable[V]) Get(pfx netip.Prefix) (val V, ok bool) {
+	return t.live.Get(pfx)
+}
+
+// Lookup does a route lookup (longest prefix match) for IP and
+// returns the associated value and true, or false if no route matched.
+// Soft deleted prefixes are skipped.
+func (t *TombstoneTable[V]) Lookup(ip netip.Addr) (val V, ok bool) {
+	return t.live.Lookup(ip)
+}
+
+// All returns an iterator over the prefixes and values,
+// soft deleted prefixes are skipped. The iteration order is not specified.
+func (t *TombstoneTable[V]) All() func(yield func(netip.Prefix, V) bool) {
+	return t.live.All()
+}
+
+// Size returns the prefix count, without the soft deleted prefixes.
+func (t *TombstoneTable[V]) Size() int {
+	return t.live.Size()
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestTombstoneTable(t *testing.T) {
+	t.Parallel()
+
+	var tt TombstoneTable[int]
+	tt.Insert(mpp("10.0.0.0/8"), 8)
+	tt.Insert(mpp("10.1.0.0/16"), 16)
+	tt.Insert(mpp("10.1.2.0/24"), 24)
+	tt.Insert(mpp("2001:db8::/32"), 32)
+
+	if tt.SoftDelete(mpp("192.168.0.0/16")) {
+		t.Errorf("SoftDelete, missing prefix, expected false")
+	}
+
+	if !tt.SoftDelete(mpp("10.1.2.0/24")) || !tt.SoftDelete(mpp("10.1.0.0/16")) {
+		t.Fatalf("SoftDelete, expected true")
+	}
+
+	// hidden from lookups, the lpm falls back to the less specific entry
+	if val, ok := tt.Lookup(mpa("10.1.2.3")); !ok || val != 8 {
+		t.Errorf("Lookup after SoftDelete, expected (8, true), got (%d, %v)", val, ok)
+	}
+	if _, ok := tt.Get(mpp("10.1.2.0/24")); ok {
+		t.Errorf("Get after SoftDelete, expected false")
+	}
+	if tt.Size() != 2 {
+		t.Errorf("Size after SoftDelete, expected 2, got %d", tt.Size())
+	}
+	if !tt.IsDeleted(mpp("10.1.0.0/16")) || tt.IsDeleted(mpp("10.0.0.0/8")) {
+		t.Errorf("IsDeleted, unexpected result")
+	}
+
+	// inspect the staged withdrawals
+	var deleted []netip.Prefix
+	tt.Deleted()(func(pfx netip.Prefix, _ int) bool {
+		deleted = append(deleted, pfx)
+		return true
+	})
+	if want := []netip.Prefix{mpp("10.1.0.0/16"), mpp("10.1.2.0/24")}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("Deleted, expected %v, got %v", want, deleted)
+	}
+
+	// roll back one
+	if !tt.RestoreDeleted(mpp("10.1.2.0/24")) {
+		t.Errorf("RestoreDeleted, expected true")
+	}
+	if tt.RestoreDeleted(mpp("10.1.2.0/24")) {
+		t.Errorf("RestoreDeleted twice, expected false")
+	}
+	if val, ok := tt.Lookup(mpa("10.1.2.3")); !ok || val != 24 {
+		t.Errorf("Lookup after RestoreDeleted, expected (24, true), got (%d, %v)", val, ok)
+	}
+
+	// insert removes the tombstone
+	tt.SoftDelete(mpp("2001:db8::/32"))
+	tt.Insert(mpp("2001:db8::/32"), 100)
+	if tt.IsDeleted(mpp("2001:db8::/32")) {
+		t.Errorf("Insert, expected tombstone removed")
+	}
+
+	// commit
+	if n := tt.PurgeDeleted(); n != 1 {
+		t.Errorf("PurgeDeleted, expected 1, got %d", n)
+	}
+	if tt.RestoreDeleted(mpp("10.1.0.0/16")) {
+		t.Errorf("RestoreDeleted after purge, expected false")
+	}
+	if n := tt.PurgeDeleted(); n != 0 {
+		t.Errorf("PurgeDeleted again, expected 0, got %d", n)
+	}
+
+	// hard delete, including tombstone
+	tt.SoftDelete(mpp("10.0.0.0/8"))
+	tt.Delete(mpp("10.0.0.0/8"))
+	if tt.IsDeleted(mpp("10.0.0.0/8")) || tt.Size() != 2 {
+		t.Errorf("Delete, expected prefix and tombstone removed")
+	}
+
+	count := 0
+	tt.All()(func(netip.Prefix, int) bool {
+		count++
+		return true
+	})
+	if count != tt.Size() {
+		t.Errorf("All, expected %d entries, got %d", tt.Size(), count)
+	}
+}
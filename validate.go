@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+)
+
+// Validity is the result of a route origin validation, see [Table.ValidateMaxLen].
+type Validity int
+
+const (
+	// NotFound, no covering entry for the announcement.
+	NotFound Validity = iota
+
+	// Valid, a covering entry allows the announced prefix length.
+	Valid
+
+	// Invalid, covering entries exist, but none allows the announced prefix length.
+	Invalid
+)
+
+// String implements the [fmt.Stringer] interface.
+func (v Validity) String() string {
+	switch v {
+	case Valid:
+		return "valid"
+	case Invalid:
+		return "invalid"
+	default:
+		return "not-found"
+	}
+}
+
+// ValidateMaxLen validates the announced prefix against the table entries
+// as ROA-like prefixes with a max-length, returned by maxLenOf for the entry value.
+//
+// The result is [NotFound] if no entry covers the announcement, [Valid] if any
+// covering entry has a max-length greater or equal to the announced prefix length
+// and [Invalid] otherwise, as in the RPKI route origin validation (RFC 6811),
+// but without origin AS matching. An invalid announce prefix is [NotFound].
+func (t *Table[V]) ValidateMaxLen(announce netip.Prefix, maxLenOf func(V) int) Validity {
+	result := NotFound
+
+	t.Supernets(announce)(func(_ netip.Prefix, val V) bool {
+		if announce.Bits() <= maxLenOf(val) {
+			result = Valid
+			return false
+		}
+		result = Invalid
+		return true
+	})
+
+	return result
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2024 Karl Gaissmaier
+// SPDX-License-Identifier: MIT
+
+package bart
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestValidateMaxLen(t *testing.T) {
+	t.Parallel()
+
+	// ROA-like entries, value is the max-length
+	roas := new(Table[int])
+	roas.Insert(mpp("10.0.0.0/8"), 16)
+	roas.Insert(mpp("10.1.0.0/16"), 24)
+	roas.Insert(mpp("192.168.0.0/16"), 16)
+	roas.Insert(mpp("2001:db8::/32"), 48)
+
+	maxLen := func(v int) int { return v }
+
+	tests := []struct {
+		announce netip.Prefix
+		want     Validity
+	}{
+		{mpp("10.0.0.0/8"), Valid},
+		{mpp("10.2.0.0/16"), Valid},
+		{mpp("10.2.3.0/24"), Invalid},
+		{mpp("10.1.3.0/24"), Valid},     // less specific ROA invalid, but more specific valid
+		{mpp("10.1.3.128/25"), Invalid}, // beyond all max-lengths
+		{mpp("192.168.0.0/16"), Valid},
+		{mpp("192.168.1.0/24"), Invalid},
+		{mpp("192.0.0.0/8"), NotFound}, // less specific than the ROA
+		{mpp("11.0.0.0/8"), NotFound},
+		{mpp("2001:db8:1::/48"), Valid},
+		{mpp("2001:db8:1::/64"), Invalid},
+		{mpp("2001:db9::/32"), NotFound},
+		{netip.Prefix{}, NotFound},
+	}
+
+	for _, tt := range tests {
+		if got := roas.ValidateMaxLen(tt.announce, maxLen); got != tt.want {
+			t.Errorf("ValidateMaxLen(%s), expected %s, got %s", tt.announce, tt.want, got)
+		}
+	}
+}